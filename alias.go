@@ -0,0 +1,66 @@
+package envsubst
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+var (
+	aliasMu sync.RWMutex
+	aliases = map[string]string{}
+)
+
+// aliasPattern matches valid operator aliases. Requiring a leading | means
+// an alias can never be confused with one of the built-in operators.
+var aliasPattern = regexp.MustCompile(`^\|[A-Za-z_][A-Za-z0-9_]*$`)
+
+// operators lists the built-in operators that may be the target of an
+// alias.
+var operators = []string{
+	",", ",,", "^", "^^",
+	":",
+	"#", "##", "%", "%%",
+	"/", "//", "/#", "/%",
+	"=", ":=", ":-", ":?", ":+",
+}
+
+// RegisterOperatorAlias teaches the parser that alias stands for the
+// built-in operator canonical, easing migration from other template
+// dialects. For example, after
+//
+//	RegisterOperatorAlias("|default", ":-")
+//
+// the template ${var|default:x} behaves exactly like ${var:-x}. An alias
+// must be a | followed by a name, and it may be followed by a colon that
+// separates it from the operator's arguments. An alias cannot be redefined
+// to stand for a different operator.
+func RegisterOperatorAlias(alias, canonical string) error {
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("invalid operator alias %q", alias)
+	}
+	if !contains(operators, canonical) {
+		return fmt.Errorf("unknown operator %q", canonical)
+	}
+
+	if isFunction(alias[1:]) {
+		return fmt.Errorf("operator alias %q is already a function", alias)
+	}
+
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	if existing, ok := aliases[alias]; ok && existing != canonical {
+		return fmt.Errorf("operator alias %q already stands for %q", alias, existing)
+	}
+	aliases[alias] = canonical
+	parseCache.reset()
+	return nil
+}
+
+// lookupAlias returns the canonical operator for a registered alias.
+func lookupAlias(alias string) (string, bool) {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	canonical, ok := aliases[alias]
+	return canonical, ok
+}
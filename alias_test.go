@@ -0,0 +1,46 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterOperatorAlias(t *testing.T) {
+	assert.Nil(t, RegisterOperatorAlias("|default", ":-"))
+	assert.Nil(t, RegisterOperatorAlias("|upper", "^^"))
+	assert.Nil(t, RegisterOperatorAlias("|substr", ":"))
+
+	m := func(s string) string {
+		return map[string]string{"name": "world"}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${missing|default:x}":          "x",
+		"${missing|default:http://x/y}": "http://x/y",
+		"${name|default:x}":             "world",
+		"${missing|default:${name}}":    "world",
+		"hello ${name|upper}!":          "hello WORLD!",
+		"${name|substr:1:3}":            "orl",
+	} {
+		got, err := Eval(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	// unregistered aliases are rejected
+	_, err := Eval("${name|unknown:x}", m)
+	assert.NotNil(t, err)
+}
+
+func TestRegisterOperatorAliasInvalid(t *testing.T) {
+	for _, alias := range []string{"", ":-", "default", "|", "|1st", "|de-fault", "||x"} {
+		assert.NotNil(t, RegisterOperatorAlias(alias, ":-"), alias)
+	}
+
+	assert.NotNil(t, RegisterOperatorAlias("|nothing", "!"))
+
+	assert.Nil(t, RegisterOperatorAlias("|fallback", ":-"))
+	assert.Nil(t, RegisterOperatorAlias("|fallback", ":-"))
+	assert.NotNil(t, RegisterOperatorAlias("|fallback", ":="))
+}
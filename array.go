@@ -0,0 +1,99 @@
+package envsubst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// resolveArray returns the expansion of an array reference and whether it
+// is set. The elements of ${arr[@]} and the indices of ${!arr[@]} are
+// joined by a space, the first character of the default IFS. Only
+// integer-indexed arrays are supported, so indices are always in order.
+func (t *Template) resolveArray(s *state, node *parse.FuncNode) (string, bool, error) {
+	values, err := t.lookupArray(s, node)
+	if err != nil {
+		return "", false, err
+	}
+
+	if node.Name == "!" {
+		indices := make([]string, len(values))
+		for i := range values {
+			indices[i] = strconv.Itoa(i)
+		}
+		return strings.Join(indices, " "), len(indices) != 0, nil
+	}
+
+	switch node.Index {
+	case "@", "*":
+		return strings.Join(values, " "), len(values) != 0, nil
+	}
+
+	i, err := strconv.Atoi(node.Index)
+	if err != nil || i >= len(values) {
+		return "", false, nil
+	}
+	return values[i], true, nil
+}
+
+// isArrayJoin reports whether node joins the elements of an array with the
+// join function, as in ${arr[@]|join:,}, which replaces the default join.
+func isArrayJoin(node *parse.FuncNode) bool {
+	return node.Name == "|join" && (node.Index == "@" || node.Index == "*")
+}
+
+// joinArray returns the elements of the array referenced by node joined by
+// the separator given as the argument of the join function, so that an
+// element containing a space is not split by the default join first.
+func (t *Template) joinArray(s *state, node *parse.FuncNode, values []string) (string, error) {
+	args, err := t.evalArgs(s, node)
+	if err != nil {
+		return "", err
+	}
+	sep, err := joinSeparator(args)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(values, sep), nil
+}
+
+// join implements ${var|join:sep}. A scalar value is an array of one
+// element, so it is unchanged; arrays are joined by joinArray.
+func join(value string, args ...string) (string, error) {
+	_, err := joinSeparator(args)
+	return value, err
+}
+
+// joinSeparator returns the separator given by the arguments of the join
+// function, a space if there are none.
+func joinSeparator(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return " ", nil
+	case 1:
+		return args[0], nil
+	}
+	return "", fmt.Errorf(currentMessages().JoinArgs, len(args))
+}
+
+// lookupArray returns the elements of the array referenced by node. A
+// variable that is not an array is an array of its value, if it is set.
+func (t *Template) lookupArray(s *state, node *parse.FuncNode) ([]string, error) {
+	if t.opts.ArrayMapping != nil {
+		name := node.Param
+		if t.opts.NameTransform != nil {
+			name = t.opts.NameTransform(name)
+		}
+		if values, ok := t.opts.ArrayMapping(name); ok {
+			return values, nil
+		}
+	}
+
+	v, set, err := s.mapper(node.Param, ResolveContext{node, s.inDefault})
+	if err != nil || !set {
+		return nil, err
+	}
+	return []string{v}, nil
+}
@@ -0,0 +1,87 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalArray(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"scalar": "value"}[s]
+	}
+	opts := &Options{
+		ArrayMapping: func(name string) ([]string, bool) {
+			v, ok := map[string][]string{"arr": {"a", "b", "c"}}[name]
+			return v, ok
+		},
+	}
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"${!arr[@]}", "0 1 2"},
+		{"${!arr[*]}", "0 1 2"},
+		{"${arr[@]}", "a b c"},
+		{"${arr[1]}", "b"},
+		{"${arr[3]:-none}", "none"},
+		{"${arr[@]^^}", "A B C"},
+		{"${!scalar[@]} ${scalar[0]}", "0 value"},
+		{"[${!unset[@]}]", "[]"},
+	}
+
+	for _, test := range tests {
+		output, err := EvalWithOptions(test.input, mapping, opts)
+		assert.Nil(t, err, test.input)
+		assert.Equal(t, test.output, output, test.input)
+	}
+}
+
+func TestEvalArrayJoin(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"scalar": "a value", "SEP": "; "}[s]
+	}
+	opts := &Options{
+		ArrayMapping: func(name string) ([]string, bool) {
+			v, ok := map[string][]string{"arr": {"a", "b c", "d"}, "one": {"x"}, "none": {}}[name]
+			return v, ok
+		},
+	}
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"${arr[@]|join:,}", "a,b c,d"},
+		{"${arr[*]|join:, }", "a, b c, d"},
+		{"${arr[@]|join:}", "ab cd"},
+		{"${arr[@]|join:${SEP}}", "a; b c; d"},
+		{"${arr[@]|join}", "a b c d"},
+		{"${one[@]|join:,}", "x"},
+		{"[${none[@]|join:,}] [${unset[@]|join:,}]", "[] []"},
+		{"${arr[1]|join:,} ${scalar|join:,} ${scalar[@]|join:,}", "b c a value a value"},
+	}
+
+	for _, test := range tests {
+		output, err := EvalWithOptions(test.input, mapping, opts)
+		assert.Nil(t, err, test.input)
+		assert.Equal(t, test.output, output, test.input)
+	}
+
+	_, err := EvalWithOptions("${arr[@]|join:,:;}", mapping, opts)
+	assert.NotNil(t, err)
+	_, err = EvalWithOptions("${scalar|join:,:;}", mapping, opts)
+	assert.NotNil(t, err)
+
+	// an unset array is reported as unset variables are
+	opts.UnsetPlaceholder = func(name string) string { return "<" + name + ">" }
+	output, err := EvalWithOptions("${unset[@]|join:,} ${arr[@]|join:,}", mapping, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "<unset> a,b c,d", output)
+
+	opts.UnsetPlaceholder = nil
+	opts.Strict = true
+	_, err = EvalWithOptions("${unset[@]|join:,}", mapping, opts)
+	assert.Equal(t, "unset: unbound variable", err.Error())
+}
@@ -0,0 +1,128 @@
+package envsubst
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// redactedResult replaces the result of a substitution in an audit that
+// does not include values.
+const redactedResult = "[redacted]"
+
+// AuditRecord describes how a substitution was resolved.
+type AuditRecord struct {
+	// Name is the name of the variable, e.g. VAR for ${VAR:-default}.
+	Name string `json:"name"`
+
+	// Operator is the operator of the substitution, e.g. :-, or empty for
+	// a plain reference such as ${VAR}.
+	Operator string `json:"operator"`
+
+	// Result is the text the substitution was replaced by, or [redacted]
+	// if values are not included and the text is not empty.
+	Result string `json:"result"`
+
+	// UsedDefault reports whether the default of an operator such as :-
+	// or = was used because the variable was unset.
+	UsedDefault bool `json:"usedDefault"`
+}
+
+// EvalAudit replaces ${var} in the string based on the mapping function
+// and returns an audit of the substitutions for logging, a JSON array of
+// AuditRecord with one record for each top-level substitution in
+// evaluation order. The results are redacted, so that no value can leak
+// into the log; use ExecuteAudit to include them.
+func EvalAudit(s string, mapping Mapping) (output string, auditJSON []byte, err error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, nil, err
+	}
+	return t.ExecuteAudit(mapping, false)
+}
+
+// ExecuteAudit applies a parsed template to the specified data mapping and
+// returns an audit of the substitutions as EvalAudit does. If
+// includeValues is true, the results in the audit are not redacted. If
+// evaluation fails, the audit of the substitutions before the failure is
+// returned with the error.
+func (t *Template) ExecuteAudit(mapping Mapping, includeValues bool) (output string, auditJSON []byte, err error) {
+	var current *parse.FuncNode
+	var set bool
+	resolve := t.sentinels(t.positional(t.transformNames(simpleResolver(mapping))))
+
+	b := new(bytes.Buffer)
+	s := new(state)
+	s.writer = b
+	s.mapper = func(name string, ctx ResolveContext) (string, bool, error) {
+		v, ok, err := resolve(name, ctx)
+		if ctx.node == current {
+			set = ok
+		}
+		return v, ok, err
+	}
+
+	records := []AuditRecord{}
+	for _, node := range flatten(t.tree.Root) {
+		s.node = node
+		fn, ok := node.(*parse.FuncNode)
+		if ok {
+			// the length of a nested expansion is recorded for the
+			// variable of the nested expansion
+			current, set = fn, false
+			if inner, nested := nestedLength(fn); nested {
+				current = inner
+			}
+		}
+		start := b.Len()
+		err = t.eval(s)
+		if err != nil {
+			break
+		}
+		if !ok {
+			continue
+		}
+
+		result := b.String()[start:]
+		if !includeValues && result != "" {
+			result = redactedResult
+		}
+		records = append(records, AuditRecord{
+			Name:        current.Param,
+			Operator:    fn.Name,
+			Result:      result,
+			UsedDefault: usedDefault(current, set),
+		})
+	}
+
+	// records of strings and booleans always marshal
+	auditJSON, _ = json.Marshal(records)
+	if err != nil {
+		return b.String(), auditJSON, t.redact(s, err)
+	}
+	if len(s.unset) != 0 {
+		return b.String(), auditJSON, UnsetErrors(s.unset)
+	}
+	return b.String(), auditJSON, nil
+}
+
+// nestedLength returns the expansion nested within the length of a nested
+// expansion, ${#${...}}.
+func nestedLength(node *parse.FuncNode) (*parse.FuncNode, bool) {
+	if node.Name != "#" || node.Param != "" || len(node.Args) != 1 {
+		return nil, false
+	}
+	inner, ok := node.Args[0].(*parse.FuncNode)
+	return inner, ok
+}
+
+// usedDefault reports whether the default of the substitution was used,
+// given whether its variable was set.
+func usedDefault(node *parse.FuncNode, set bool) bool {
+	switch node.Name {
+	case "+", ":+":
+		return false
+	}
+	return !set && isDefaultFunc(node.Name)
+}
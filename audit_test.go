@@ -0,0 +1,95 @@
+package envsubst
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalAudit(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"HOST": "example.com", "TOKEN": "s3cret"}[s]
+	}
+	input := "http://${HOST}:${PORT:-80}/?t=${TOKEN@Q}&u=$USER${DEBUG:+&debug}"
+
+	out, audit, err := EvalAudit(input, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://example.com:80/?t='s3cret'&u=", out)
+	assert.True(t, json.Valid(audit))
+	assert.NotContains(t, string(audit), "s3cret")
+
+	var records []AuditRecord
+	assert.Nil(t, json.Unmarshal(audit, &records))
+	assert.Equal(t, []AuditRecord{
+		{Name: "HOST", Operator: "", Result: "[redacted]"},
+		{Name: "PORT", Operator: ":-", Result: "[redacted]", UsedDefault: true},
+		{Name: "TOKEN", Operator: "@Q", Result: "[redacted]"},
+		{Name: "USER", Operator: "", Result: ""},
+		{Name: "DEBUG", Operator: ":+", Result: ""},
+	}, records)
+
+	tmpl, err := Parse(input)
+	assert.Nil(t, err)
+	_, audit, err = tmpl.ExecuteAudit(m, true)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"name":"HOST","operator":"","result":"example.com","usedDefault":false},`+
+		`{"name":"PORT","operator":":-","result":"80","usedDefault":true},`+
+		`{"name":"TOKEN","operator":"@Q","result":"'s3cret'","usedDefault":false},`+
+		`{"name":"USER","operator":"","result":"","usedDefault":false},`+
+		`{"name":"DEBUG","operator":":+","result":"","usedDefault":false}]`, string(audit))
+}
+
+func TestEvalAuditError(t *testing.T) {
+	out, audit, err := EvalAudit("${A:-a} ${B:?required} ${C}", func(string) string { return "" })
+	assert.Equal(t, "B: required", err.Error())
+	assert.Equal(t, "a ", out)
+	assert.Equal(t, `[{"name":"A","operator":":-","result":"[redacted]","usedDefault":true}]`, string(audit))
+
+	_, audit, err = EvalAudit("no substitutions", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", string(audit))
+}
+
+func TestExecuteAuditOptions(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"A": "h"}[s]
+	}
+
+	// the mapping is wrapped as Execute wraps it
+	tmpl, err := ParseWithOptions("${a}", &Options{NameTransform: strings.ToUpper})
+	assert.Nil(t, err)
+	want, err := tmpl.Execute(m)
+	assert.Nil(t, err)
+	out, audit, err := tmpl.ExecuteAudit(m, true)
+	assert.Nil(t, err)
+	assert.Equal(t, want, out)
+	assert.Equal(t, `[{"name":"a","operator":"","result":"h","usedDefault":false}]`, string(audit))
+
+	// unset variables are reported as Execute reports them
+	tmpl, err = ParseWithOptions("${B} ${A} ${C}", &Options{Strict: true, ReportAllUnset: true})
+	assert.Nil(t, err)
+	_, wantErr := tmpl.Execute(m)
+	out, audit, err = tmpl.ExecuteAudit(m, false)
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, "B: unbound variable; C: unbound variable", err.Error())
+	assert.Equal(t, " h ", out)
+	assert.True(t, json.Valid(audit))
+}
+
+func TestEvalAuditNestedLength(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"A": "abc"}[s]
+	}
+	out, audit, err := EvalAudit("${#${A}} ${#${B:-xy}}", m)
+	assert.Nil(t, err)
+	assert.Equal(t, "3 2", out)
+
+	var records []AuditRecord
+	assert.Nil(t, json.Unmarshal(audit, &records))
+	assert.Equal(t, []AuditRecord{
+		{Name: "A", Operator: "#", Result: "[redacted]"},
+		{Name: "B", Operator: "#", Result: "[redacted]", UsedDefault: true},
+	}, records)
+}
@@ -0,0 +1,111 @@
+package envsubst
+
+import (
+	"container/list"
+	"sync"
+)
+
+// templateCache holds templates parsed with the default options, keyed by
+// their input, so that a string evaluated repeatedly is only parsed once.
+// Templates are not changed by execution, so a cached one may be executed
+// by several goroutines at once. The least recently used template is
+// evicted once the cache is full.
+type templateCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	input    string
+	template *Template
+}
+
+// parseCache is used by Eval and EvalEnv. It is disabled until
+// SetParseCacheSize is called.
+var parseCache templateCache
+
+// SetParseCacheSize enables the reuse of parsed templates by Eval and
+// EvalEnv, keeping up to n of the most recently used ones. This saves
+// parsing when the same string is evaluated repeatedly, such as in a
+// loop, at the cost of holding on to the parsed strings. Zero, the
+// default, disables the cache and drops the templates it holds.
+func SetParseCacheSize(n int) {
+	parseCache.resize(n)
+}
+
+func (c *templateCache) resize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = n
+	if n == 0 {
+		c.order, c.entries = nil, nil
+		return
+	}
+	if c.order == nil {
+		c.order = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+	for c.order.Len() > n {
+		c.remove(c.order.Back())
+	}
+}
+
+// parse returns the cached template for s, parsing and caching it if it
+// is not there. Strings that fail to parse are not cached.
+func (c *templateCache) parse(s string) (*Template, error) {
+	c.mu.Lock()
+	if c.size == 0 {
+		c.mu.Unlock()
+		return Parse(s)
+	}
+	if e, ok := c.entries[s]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).template, nil
+	}
+	c.mu.Unlock()
+
+	// parse without the lock so that other strings are not held up
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size == 0 {
+		return t, nil
+	}
+	if e, ok := c.entries[s]; ok {
+		// parsed by another goroutine in the meantime
+		c.order.MoveToFront(e)
+		return t, nil
+	}
+	c.entries[s] = c.order.PushFront(&cacheEntry{input: s, template: t})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return t, nil
+}
+
+// remove drops an entry. The caller must hold the lock.
+func (c *templateCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).input)
+}
+
+// reset drops every cached template. It is called when a function or an
+// operator alias is registered, which may change how a string is parsed.
+func (c *templateCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.order != nil {
+		c.order.Init()
+		c.entries = make(map[string]*list.Element)
+	}
+}
@@ -0,0 +1,68 @@
+package envsubst
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCache(t *testing.T) {
+	t.Cleanup(func() { SetParseCacheSize(0) })
+	t.Setenv("CACHE_NAME", "first")
+
+	SetParseCacheSize(2)
+	out, err := EvalEnv("${CACHE_NAME}")
+	assert.Nil(t, err)
+	assert.Equal(t, "first", out)
+
+	// the template is reused, and the value is looked up again
+	t.Setenv("CACHE_NAME", "second")
+	out, err = EvalEnv("${CACHE_NAME}")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", out)
+	assert.Equal(t, 1, parseCache.order.Len())
+
+	_, err = EvalEnv("${CACHE_NAME")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, parseCache.order.Len(), "errors are not cached")
+
+	for i := 0; i < 5; i++ {
+		_, err = EvalEnv(fmt.Sprintf("${CACHE_NAME}%d", i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, parseCache.order.Len())
+	assert.Contains(t, parseCache.entries, "${CACHE_NAME}4")
+	assert.Contains(t, parseCache.entries, "${CACHE_NAME}3")
+
+	SetParseCacheSize(1)
+	assert.Equal(t, 1, parseCache.order.Len())
+	assert.Contains(t, parseCache.entries, "${CACHE_NAME}4")
+
+	SetParseCacheSize(0)
+	assert.Nil(t, parseCache.entries)
+	out, err = EvalEnv("${CACHE_NAME}")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", out)
+}
+
+var benchmarkTemplate = "host=${CACHE_HOST:-localhost} port=${CACHE_PORT:-8080} user=${USER}"
+
+func BenchmarkEvalEnv(b *testing.B) {
+	os.Setenv("CACHE_HOST", "example.com")
+	defer os.Unsetenv("CACHE_HOST")
+	for i := 0; i < b.N; i++ {
+		EvalEnv(benchmarkTemplate)
+	}
+}
+
+func BenchmarkEvalEnvCached(b *testing.B) {
+	os.Setenv("CACHE_HOST", "example.com")
+	defer os.Unsetenv("CACHE_HOST")
+	SetParseCacheSize(16)
+	defer SetParseCacheSize(0)
+	for i := 0; i < b.N; i++ {
+		EvalEnv(benchmarkTemplate)
+	}
+}
@@ -0,0 +1,70 @@
+package envsubst
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// languageTagPattern matches the form of a BCP 47 language tag, such as tr
+// or az-Latn-AZ.
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// checkCaseLanguage reports whether the CaseLanguage option is a well
+// formed language tag.
+func (t *Template) checkCaseLanguage() error {
+	tag := t.opts.CaseLanguage
+	if tag == "" {
+		return nil
+	}
+	if !languageTagPattern.MatchString(tag) {
+		return fmt.Errorf("invalid language tag %q", tag)
+	}
+	if _, err := language.Parse(tag); err != nil {
+		return fmt.Errorf("invalid language tag %q: %w", tag, err)
+	}
+	return nil
+}
+
+// caseFunc returns the case operator named by name mapped with the rules of
+// the CaseLanguage option, if it is set. A caser is created for each call,
+// as casers cannot be shared between goroutines.
+func (t *Template) caseFunc(name string) (substituteFunc, bool) {
+	if t.opts.CaseLanguage == "" {
+		return nil, false
+	}
+	tag := language.Make(t.opts.CaseLanguage)
+
+	switch name {
+	case ",":
+		return func(s string, _ ...string) string {
+			return mapFirst(s, cases.Lower(tag))
+		}, true
+	case ",,":
+		return func(s string, _ ...string) string {
+			return cases.Lower(tag).String(s)
+		}, true
+	case "^":
+		return func(s string, _ ...string) string {
+			return mapFirst(s, cases.Upper(tag))
+		}, true
+	case "^^":
+		return func(s string, _ ...string) string {
+			return cases.Upper(tag).String(s)
+		}, true
+	}
+	return nil, false
+}
+
+// mapFirst returns a copy of the string s with its first character mapped
+// by c.
+func mapFirst(s string, c cases.Caser) string {
+	if s == "" {
+		return s
+	}
+	_, n := utf8.DecodeRuneInString(s)
+	return c.String(s[:n]) + s[n:]
+}
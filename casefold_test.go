@@ -0,0 +1,41 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaseLanguage(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"CITY": "istanbul", "UPPER": "IĞDIR"}[name]
+	}
+	input := "${CITY^^} ${CITY^} ${UPPER,,} ${UPPER,}"
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"", "ISTANBUL Istanbul iğdir iĞDIR"},
+		{"en-US", "ISTANBUL Istanbul iğdir iĞDIR"},
+		{"tr", "İSTANBUL İstanbul ığdır ıĞDIR"},
+		{"tr-TR", "İSTANBUL İstanbul ığdır ıĞDIR"},
+		{"AZ", "İSTANBUL İstanbul ığdır ıĞDIR"},
+	}
+	for _, test := range tests {
+		out, err := EvalWithOptions(input, mapping, &Options{CaseLanguage: test.lang})
+		assert.Nil(t, err, test.lang)
+		assert.Equal(t, test.want, out, test.lang)
+	}
+
+	// other languages with rules of their own follow them too
+	out, err := EvalWithOptions("${WORD^^}", func(string) string { return "άδικος" }, &Options{CaseLanguage: "el"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ΑΔΙΚΟΣ", out)
+
+	_, err = ParseWithOptions(input, &Options{CaseLanguage: "tr_TR"})
+	assert.EqualError(t, err, `invalid language tag "tr_TR"`)
+
+	_, err = ParseWithOptions(input, &Options{CaseLanguage: "zz"})
+	assert.NotNil(t, err)
+}
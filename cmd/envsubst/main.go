@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/logandavies181/envsubst"
+	"github.com/logandavies181/envsubst/parse"
 )
 
 func main() {
@@ -15,6 +17,10 @@ func main() {
 
 	for stdin.Scan() {
 		line, err := envsubst.EvalEnv(stdin.Text())
+		var syntaxErr *parse.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			log.Fatalf("Error while envsubst: %s", syntaxErr.Pretty())
+		}
 		if err != nil {
 			log.Fatalf("Error while envsubst: %v", err)
 		}
@@ -0,0 +1,49 @@
+package envsubst
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCommand runs the command if it is in the allow-list and returns its
+// standard output with trailing newlines removed.
+func (t *Template) runCommand(command string) (string, error) {
+	if len(t.opts.AllowedCommands) == 0 {
+		return "", ErrNoAllowedCommands
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if !contains(t.opts.AllowedCommands, fields[0]) {
+		return "", fmt.Errorf(currentMessages().CommandNotAllowed, fields[0])
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf(currentMessages().CommandFailedOutput, command, err, msg)
+		}
+		return "", fmt.Errorf(currentMessages().CommandFailed, command, err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// ErrNoAllowedCommands is returned when command substitution is enabled
+// without any allowed commands.
+var ErrNoAllowedCommands = messageError(func(m *Messages) string { return m.NoAllowedCommands })
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
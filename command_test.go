@@ -0,0 +1,75 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandSubstitution(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"var": "foo"}[s]
+	}
+	opts := &Options{
+		AllowCommandSubstitution: true,
+		AllowedCommands:          []string{"echo"},
+	}
+
+	out, err := EvalWithOptions("$(echo hello world) ${var}", m, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world foo", out)
+
+	out, err = EvalWithOptions("[$()]", m, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", out)
+
+	// commands are not run unless enabled
+	out, err = Eval("$(echo hello) ${var}", m)
+	assert.Nil(t, err)
+	assert.Equal(t, "$(echo hello) foo", out)
+}
+
+func TestCommandSubstitutionInDefault(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"var": "foo"}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${unset:-$(date)}":             "$(date)",
+		"${unset:-$(date +%s) later}":   "$(date +%s) later",
+		"${unset:-$(echo ${var} $var)}": "$(echo ${var} $var)",
+		"${unset:-$(echo })}":           "$(echo })",
+		"${var:-$(date)}":               "foo",
+		"${var:+$(date)}":               "$(date)",
+	} {
+		out, err := Eval(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, out, input)
+	}
+
+	opts := &Options{
+		AllowCommandSubstitution: true,
+		AllowedCommands:          []string{"echo"},
+	}
+	out, err := EvalWithOptions("${unset:-$(echo hello)} ${var:-$(date)}", m, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello foo", out)
+}
+
+func TestCommandSubstitutionErrors(t *testing.T) {
+	opts := &Options{
+		AllowCommandSubstitution: true,
+		AllowedCommands:          []string{"echo"},
+	}
+
+	_, err := EvalWithOptions("$(date)", nil, opts)
+	assert.EqualError(t, err, `command "date" is not allowed`)
+
+	opts.AllowedCommands = []string{"false"}
+	_, err = EvalWithOptions("$(false)", nil, opts)
+	assert.EqualError(t, err, `command "false": exit status 1`)
+
+	opts.AllowedCommands = nil
+	_, err = EvalWithOptions("$(echo hello)", nil, opts)
+	assert.Equal(t, ErrNoAllowedCommands, err)
+}
@@ -0,0 +1,40 @@
+package envsubst
+
+import "strings"
+
+// Placeholder produces the text that stands for a substitution of the
+// named variable in a dry run. The NodeInfo describes the substitution,
+// with its arguments rendered as in the dry run.
+type Placeholder func(name string, n NodeInfo) string
+
+// BracketPlaceholder is the default Placeholder of DryRun. Every
+// substitution, whatever its operator, is shown as its variable name in
+// brackets, e.g. ${FOO:-bar} as [FOO]. A function called without a
+// variable, e.g. ${|coalesce:A:B}, is shown as the function, [|coalesce],
+// and the length of a nested expansion, e.g. ${#${FOO:-bar}}, as the
+// nested expansion, [FOO].
+func BracketPlaceholder(name string, n NodeInfo) string {
+	switch {
+	case name == "" && n.Fn() == "#":
+		return strings.Join(n.Args(), "")
+	case name == "":
+		name = n.Fn()
+	}
+	return "[" + name + "]"
+}
+
+// DryRun renders s with a placeholder for every substitution, using
+// BracketPlaceholder, to preview the structure of a template. No mapping
+// is ever called and no command is run.
+func DryRun(s string) (string, error) {
+	return DryRunWithPlaceholder(s, BracketPlaceholder)
+}
+
+// DryRunWithPlaceholder is like DryRun, but each substitution is rendered
+// by placeholder, e.g. to show the operator or default of a substitution
+// with NodeInfo.Fn and NodeInfo.Args.
+func DryRunWithPlaceholder(s string, placeholder Placeholder) (string, error) {
+	return EvalAdvanced(s, func(name string, n NodeInfo) (string, bool) {
+		return placeholder(name, n), false
+	})
+}
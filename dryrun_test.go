@@ -0,0 +1,42 @@
+package envsubst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	for input, want := range map[string]string{
+		"hello ${NAME}":               "hello [NAME]",
+		"$HOST:${PORT:-8080}":         "[HOST]:[PORT]",
+		"${A/x/y} ${#B} ${C,,}":       "[A] [B] [C]",
+		"${URL:-http://${HOST}/}":     "[URL]",
+		"${|coalesce:A:B}":            "[|coalesce]",
+		"${#${A}} ${#${B:-x}}":        "[A] [B]",
+		"$(rm -rf /tmp/x) ${A}":       "$(rm -rf /tmp/x) [A]",
+		"no substitutions, only text": "no substitutions, only text",
+	} {
+		got, err := DryRun(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err := DryRun("${A")
+	assert.NotNil(t, err)
+}
+
+func TestDryRunWithPlaceholder(t *testing.T) {
+	// show defaults, with the defaults' own substitutions as placeholders
+	placeholder := func(name string, n NodeInfo) string {
+		if n.Fn() == ":-" || n.Fn() == "-" {
+			return "<" + name + " or " + strings.Join(n.Args(), "") + ">"
+		}
+		return "<" + name + ">"
+	}
+
+	got, err := DryRunWithPlaceholder("${URL:-http://${HOST}:${PORT-80}/} ${USER}", placeholder)
+	assert.Nil(t, err)
+	assert.Equal(t, "<URL or http://<HOST>:<PORT or 80>/> <USER>", got)
+}
@@ -0,0 +1,86 @@
+package envsubst
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvError reports the failure to evaluate a template against one of the
+// environments given to EvalEach or EvalEachAll.
+type EnvError struct {
+	Index int // index of the environment in the slice
+	Err   error
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("environment %d: %v", e.Index, e.Err)
+}
+
+func (e *EnvError) Unwrap() error {
+	return e.Err
+}
+
+// EnvErrors is returned by EvalEachAll when evaluation fails against one or
+// more environments, in the order of the environments.
+type EnvErrors []*EnvError
+
+func (e EnvErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// EvalEach parses s once and evaluates it against each environment in
+// turn, returning one output per environment, such as for rendering a
+// matrix of configurations. A variable is set if it is a key of the
+// environment, even if its value is empty. Evaluation stops at the first
+// failure, which is returned as an *EnvError with the index of the
+// environment, along with the outputs of the environments before it.
+func EvalEach(s string, envs []map[string]string) ([]string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]string, 0, len(envs))
+	for i, env := range envs {
+		out, err := t.ExecuteSet(lookupMap(env))
+		if err != nil {
+			return outputs, &EnvError{Index: i, Err: err}
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
+
+// EvalEachAll is like EvalEach, but evaluation continues past failures, so
+// that there is an output for every environment. The output for an
+// environment that failed is the output produced before the failure. The
+// failures are returned together as an EnvErrors.
+func EvalEachAll(s string, envs []map[string]string) ([]string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]string, len(envs))
+	var errs EnvErrors
+	for i, env := range envs {
+		outputs[i], err = t.ExecuteSet(lookupMap(env))
+		if err != nil {
+			errs = append(errs, &EnvError{Index: i, Err: err})
+		}
+	}
+	if errs != nil {
+		return outputs, errs
+	}
+	return outputs, nil
+}
+
+// lookupMap returns a mapping that reports the variables of the map.
+func lookupMap(env map[string]string) MappingSet {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
@@ -0,0 +1,59 @@
+package envsubst
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/logandavies181/envsubst/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalEach(t *testing.T) {
+	envs := []map[string]string{
+		{"REGION": "us-east-1", "SIZE": "small"},
+		{"REGION": "eu-west-1", "SIZE": "large"},
+		{"REGION": "ap-south-1", "SIZE": ""},
+	}
+	out, err := EvalEach("${REGION}/${SIZE-medium}/${SIZE:-medium}", envs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"us-east-1/small/small",
+		"eu-west-1/large/large",
+		"ap-south-1//medium",
+	}, out)
+
+	_, err = EvalEach("${REGION", envs)
+	var syntaxErr *parse.SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+
+	out, err = EvalEach("${SIZE:?required}", envs)
+	var envErr *EnvError
+	if assert.True(t, errors.As(err, &envErr)) {
+		assert.Equal(t, 2, envErr.Index)
+		assert.Equal(t, "environment 2: SIZE: required", err.Error())
+	}
+	assert.Equal(t, []string{"small", "large"}, out)
+}
+
+func TestEvalEachAll(t *testing.T) {
+	envs := []map[string]string{
+		{"NAME": "a"},
+		{},
+		{"NAME": "c"},
+		nil,
+	}
+	out, err := EvalEachAll("x-${NAME:?}", envs)
+	assert.Equal(t, []string{"x-a", "x-", "x-c", "x-"}, out)
+
+	var errs EnvErrors
+	if assert.True(t, errors.As(err, &errs)) && assert.Len(t, errs, 2) {
+		assert.Equal(t, 1, errs[0].Index)
+		assert.Equal(t, 3, errs[1].Index)
+		var unset *UnsetError
+		assert.True(t, errors.As(errs[0], &unset))
+	}
+
+	out, err = EvalEachAll("${NAME}", envs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "", "c", ""}, out)
+}
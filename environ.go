@@ -0,0 +1,46 @@
+package envsubst
+
+import (
+	"runtime"
+	"strings"
+)
+
+// foldEnvNames reports whether the names of environment variables match
+// regardless of case, as they do on Windows.
+var foldEnvNames = runtime.GOOS == "windows"
+
+// EnvironMapping returns a mapping that resolves variables from environ, a
+// list of key=value strings such as os.Environ returns. If caseInsensitive
+// is true, names match regardless of case, as environment variables do on
+// Windows, so that ${Path} and ${PATH} are the same variable; of several
+// variables whose names differ only in case, the first is used.
+func EnvironMapping(environ []string, caseInsensitive bool) Mapping {
+	env := environMap(environ, caseInsensitive)
+	return func(name string) string {
+		if caseInsensitive {
+			name = strings.ToUpper(name)
+		}
+		return env[name]
+	}
+}
+
+// environMap returns the variables of environ by name, upper-cased if the
+// names are matched regardless of case.
+func environMap(environ []string, caseInsensitive bool) map[string]string {
+	m := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 {
+			continue
+		}
+		name := kv[:i]
+		if caseInsensitive {
+			name = strings.ToUpper(name)
+			if _, ok := m[name]; ok {
+				continue
+			}
+		}
+		m[name] = kv[i+1:]
+	}
+	return m
+}
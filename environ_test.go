@@ -0,0 +1,36 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironMapping(t *testing.T) {
+	environ := []string{"Path=C:\\Windows", "PATH=ignored", "home=C:\\Users\\me", "EMPTY=", "=C:=C:\\", "bad"}
+
+	// as on Windows
+	got, err := Eval("${PATH} ${Path} ${path} ${HOME} ${Empty:-unset}", EnvironMapping(environ, true))
+	assert.Nil(t, err)
+	assert.Equal(t, `C:\Windows C:\Windows C:\Windows C:\Users\me unset`, got)
+
+	// as on Unix
+	got, err = Eval("${PATH} ${Path} ${path} ${HOME} ${home}", EnvironMapping(environ, false))
+	assert.Nil(t, err)
+	assert.Equal(t, `ignored C:\Windows   C:\Users\me`, got)
+}
+
+func TestEvalEnvSnapshotFoldNames(t *testing.T) {
+	t.Setenv("ENVSUBST_Fold", "value")
+	defer func(fold bool) { foldEnvNames = fold }(foldEnvNames)
+
+	foldEnvNames = false
+	got, err := EvalEnvSnapshot("[${ENVSUBST_FOLD}] [${ENVSUBST_Fold}]")
+	assert.Nil(t, err)
+	assert.Equal(t, "[] [value]", got)
+
+	foldEnvNames = true
+	got, err = EvalEnvSnapshot("[${ENVSUBST_FOLD}] [${envsubst_fold}] [${!ENVSUBST_Fo*}]")
+	assert.Nil(t, err)
+	assert.Equal(t, "[value] [value] [ENVSUBST_Fold]", got)
+}
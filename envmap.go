@@ -0,0 +1,67 @@
+package envsubst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvalToMap replaces ${var} in the string based on the mapping function and
+// parses the result as lines of KEY=value, as in an env file. Blank lines
+// and lines starting with # are skipped, and a line may start with export.
+// Each line is split at its first =, and white space around the key and
+// value is trimmed. A value in double quotes is unquoted with Go escapes,
+// such as \n and \", and a value in single quotes is taken literally. If a
+// key appears more than once, the last value wins.
+func EvalToMap(s string, mapping Mapping) (map[string]string, error) {
+	out, err := Eval(s, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvLines(out)
+}
+
+// parseEnvLines parses lines of KEY=value into a map.
+func parseEnvLines(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("line %d: missing = in %q", i+1, line)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key in %q", i+1, line)
+		}
+		value, err := unquoteEnvValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// unquoteEnvValue removes the quotes around a value, if any.
+func unquoteEnvValue(v string) (string, error) {
+	if v == "" || v[0] != '"' && v[0] != '\'' {
+		return v, nil
+	}
+	if len(v) < 2 || v[len(v)-1] != v[0] {
+		return "", fmt.Errorf("unterminated quoted value %s", v)
+	}
+	if v[0] == '\'' {
+		return v[1 : len(v)-1], nil
+	}
+	u, err := strconv.Unquote(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted value %s", v)
+	}
+	return u, nil
+}
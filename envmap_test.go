@@ -0,0 +1,50 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalToMap(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"HOST": "db.local", "GREETING": "hello world"}[name]
+	}
+
+	m, err := EvalToMap(`# generated
+DB_HOST=${HOST}
+
+export DB_PORT = ${PORT:-5432}
+GREETING="${GREETING}\n"
+LITERAL='a\nb "c"'
+EMPTY=
+URL=postgres://${HOST}:5432/app?sslmode=disable
+DB_HOST=${HOST}.internal
+`, mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":  "db.local.internal",
+		"DB_PORT":  "5432",
+		"GREETING": "hello world\n",
+		"LITERAL":  `a\nb "c"`,
+		"EMPTY":    "",
+		"URL":      "postgres://db.local:5432/app?sslmode=disable",
+	}, m)
+
+	m, err = EvalToMap("A=1\r\nB=\"2\"\r\n", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, m)
+
+	for input, want := range map[string]string{
+		"A=1\nB":        `line 2: missing = in "B"`,
+		"=1":            `line 1: missing key in "=1"`,
+		`A="unclosed`:   `line 1: unterminated quoted value "unclosed`,
+		`A="bad \q"`:    `line 1: invalid quoted value "bad \q"`,
+		"A=${B:?unset}": "B: unset",
+	} {
+		_, err := EvalToMap(input, mapping)
+		if assert.NotNil(t, err, input) {
+			assert.Equal(t, want, err.Error(), input)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package envsubst
+
+import "strings"
+
+// EOLStyle is a style of line ending.
+type EOLStyle int
+
+const (
+	// EOLPreserve leaves line endings as they are.
+	EOLPreserve EOLStyle = iota
+
+	// EOLLF ends lines with a line feed, \n.
+	EOLLF
+
+	// EOLCRLF ends lines with a carriage return and line feed, \r\n.
+	EOLCRLF
+)
+
+// normalizeEOL converts the line endings of s, both \n and \r\n, to the
+// style.
+func normalizeEOL(s string, style EOLStyle) string {
+	switch style {
+	case EOLLF:
+		return strings.ReplaceAll(s, "\r\n", "\n")
+	case EOLCRLF:
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return s
+}
@@ -0,0 +1,29 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalNormalizeEOL(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"value": "c\r\nd\n"}[s]
+	}
+	input := "a\r\nb\n${value}e\r\n"
+
+	tests := []struct {
+		style  EOLStyle
+		output string
+	}{
+		{EOLPreserve, "a\r\nb\nc\r\nd\ne\r\n"},
+		{EOLLF, "a\nb\nc\nd\ne\n"},
+		{EOLCRLF, "a\r\nb\r\nc\r\nd\r\ne\r\n"},
+	}
+
+	for _, test := range tests {
+		output, err := EvalWithOptions(input, mapping, &Options{NormalizeEOL: test.style})
+		assert.Nil(t, err)
+		assert.Equal(t, test.output, output)
+	}
+}
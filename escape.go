@@ -0,0 +1,44 @@
+package envsubst
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Escaper escapes a substituted value for the context of the output.
+type Escaper func(value string) string
+
+// dockerNewlines matches the line breaks that DockerEnvEscaper collapses,
+// with the white space around them.
+var dockerNewlines = regexp.MustCompile(`[ \t]*\r?\n[ \t]*`)
+
+// DockerEnvEscaper escapes a value for a Dockerfile ENV or ARG
+// instruction, as ${var@docker} does, so that ENV KEY=${VALUE} sets KEY to
+// the value. Each line break, with the white space around it, is replaced
+// by a single space, since a value cannot span lines. A value that is
+// empty or contains white space, quotes, backslashes, dollar signs or #
+// is put in double quotes, with ", \ and $ escaped by a backslash so
+// that Docker does not substitute variables within it.
+func DockerEnvEscaper(value string) string {
+	value = dockerNewlines.ReplaceAllString(value, " ")
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\$#") {
+		return value
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\', '$':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// toDockerEnv implements ${var@docker}.
+func toDockerEnv(s string, args ...string) string {
+	return DockerEnvEscaper(s)
+}
@@ -0,0 +1,41 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerEnvEscaper(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain":              "plain",
+		"with spaces":        `"with spaces"`,
+		"":                   `""`,
+		"line one\nline two": `"line one line two"`,
+		"a \r\n  b\n":        `"a b "`,
+		`say "hi"`:           `"say \"hi\""`,
+		`C:\path $HOME`:      `"C:\\path \$HOME"`,
+		"it's#1":             `"it's#1"`,
+		"tab\there":          "\"tab\there\"",
+	} {
+		assert.Equal(t, want, DockerEnvEscaper(value), value)
+	}
+}
+
+func TestEscaper(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"GREETING": "hello\nworld", "NAME": "app", "EMPTY": ""}[name]
+	}
+
+	out, err := Eval("ENV GREETING=${GREETING@docker} NAME=${NAME@docker}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, `ENV GREETING="hello world" NAME=app`, out)
+
+	input := "ENV GREETING=${GREETING} NAME=${NAME} EMPTY=${EMPTY} OTHER=${UNSET:-${NAME} x}"
+	out, err = EvalWithOptions(input, mapping, &Options{Escaper: DockerEnvEscaper})
+	assert.Nil(t, err)
+	assert.Equal(t, `ENV GREETING="hello world" NAME=app EMPTY="" OTHER="app x"`, out)
+
+	_, err = Parse("${NAME@dockers}")
+	assert.NotNil(t, err)
+}
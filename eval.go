@@ -1,19 +1,213 @@
 package envsubst
 
-import "os"
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
-// Eval replaces ${var} in the string based on the mapping function.
-func Eval(s string, mapping func(string) string) (string, error) {
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// MappingError is returned when the mapping function fails to resolve a
+// variable.
+type MappingError struct {
+	Name string
+	Err  error
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf(currentMessages().MappingError, e.Name, e.Err)
+}
+
+func (e *MappingError) Unwrap() error {
+	return e.Err
+}
+
+// Eval replaces ${var} in the string based on the mapping function. If s
+// cannot be parsed it is returned with the error, and if evaluation fails
+// the output produced before the failure is returned with the error. See
+// SetParseCacheSize to reuse the parsed form of strings evaluated
+// repeatedly.
+func Eval(s string, mapping Mapping) (string, error) {
+	if strings.IndexByte(s, '$') == -1 {
+		return s, nil
+	}
+	t, err := parseCache.parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.Execute(mapping)
+}
+
+// EvalTo is like Eval, but writes the result to w as it is produced
+// instead of returning it, e.g. to an http.ResponseWriter or a file. If s
+// cannot be parsed nothing is written, and if evaluation fails the output
+// produced before the failure has already been written.
+func EvalTo(w io.Writer, s string, mapping Mapping) error {
+	if strings.IndexByte(s, '$') == -1 {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+	t, err := parseCache.parse(s)
+	if err != nil {
+		return err
+	}
+	return t.executeTo(w, simpleResolver(mapping))
+}
+
+// EvalE replaces ${var} in the string based on a mapping function that may
+// fail. Evaluation stops at the first mapping error, which is returned as a
+// *MappingError naming the variable.
+func EvalE(s string, mapping func(string) (string, error)) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteE(mapping)
+}
+
+// EvalSet replaces ${var} in the string based on a mapping function that
+// reports whether each variable is set.
+func EvalSet(s string, mapping MappingSet) (string, error) {
 	t, err := Parse(s)
 	if err != nil {
 		return s, err
 	}
+	return t.ExecuteSet(mapping)
+}
+
+// EvalWithOptions replaces ${var} in the string based on the mapping
+// function, parsing and evaluating the string according to opts.
+func EvalWithOptions(s string, mapping Mapping, opts *Options) (string, error) {
+	t, err := ParseWithOptions(s, opts)
+	if err != nil {
+		return s, err
+	}
 	return t.Execute(mapping)
 }
 
+// EvalSafe is like Eval, but s is returned unchanged whenever evaluation
+// fails, so that a caller can skip a template rather than write a partly
+// expanded one.
+func EvalSafe(s string, mapping Mapping) (string, error) {
+	out, err := Eval(s, mapping)
+	if err != nil {
+		return s, err
+	}
+	return out, nil
+}
+
 // EvalEnv replaces ${var} in the string according to the values of the
 // current environment variables. References to undefined variables are
-// replaced by the empty string.
+// replaced by the empty string. Names match as the operating system
+// matches them, regardless of case on Windows and exactly elsewhere.
 func EvalEnv(s string) (string, error) {
 	return Eval(s, os.Getenv)
 }
+
+// EvalEnvSnapshot is like EvalEnv, but the environment is copied once at the
+// start of the call and every variable is resolved from the copy, so that
+// the result is consistent even if another goroutine calls os.Setenv during
+// evaluation. The whole environment is copied on every call, which costs
+// more than EvalEnv when the environment is large and few variables are
+// used.
+func EvalEnvSnapshot(s string) (string, error) {
+	env := os.Environ()
+	names := environNames(env)
+	t, err := ParseWithOptions(s, &Options{ListNames: func() []string { return names }})
+	if err != nil {
+		return s, err
+	}
+	return t.Execute(EnvironMapping(env, foldEnvNames))
+}
+
+// EscapeLiteral returns a template that evaluates to s when it is parsed
+// with opts, whatever the mapping, so that templates can be built to
+// include arbitrary text. With BackslashEscape each sigil that would start
+// an expansion is escaped as \$, or every sigil with StrictDollar, and the
+// backslashes before a sigil are doubled. Backslashes at the end of s are
+// left as they are, so the result must not be followed directly by an
+// expansion if s ends with a backslash. Without BackslashEscape there is no
+// escape for a sigil that starts an expansion, as $$ still expands what
+// follows it, and EscapeLiteral fails if s contains one.
+func EscapeLiteral(s string, opts *Options) (string, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	out := s
+	if o.BackslashEscape {
+		sigil := o.Sigil
+		if sigil == 0 {
+			sigil = '$'
+		}
+		out = escapeSigils(s, sigil, o.StrictDollar)
+	}
+
+	// the template must parse as the text alone
+	if t, err := ParseWithOptions(out, &o); err == nil {
+		if text, ok := literalText(t.tree.Root); ok && text == s {
+			return out, nil
+		}
+	}
+	return "", fmt.Errorf(currentMessages().CannotEscape, s)
+}
+
+// literalText returns the text of a tree that holds only text.
+func literalText(root parse.Node) (string, bool) {
+	var b strings.Builder
+	for _, node := range flatten(root) {
+		text, ok := node.(*parse.TextNode)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(text.Value)
+	}
+	return b.String(), true
+}
+
+// escapeSigils escapes each sigil of s that would start an expansion, or
+// every sigil if all is set, with a backslash, and doubles the backslashes
+// before every sigil.
+func escapeSigils(s string, sigil rune, all bool) string {
+	var b strings.Builder
+	run := 0 // backslashes not yet written
+	for i, r := range s {
+		switch r {
+		case '\\':
+			run++
+			continue
+		case sigil:
+			run *= 2
+			if all || startsExpansion(s[i+utf8.RuneLen(r):]) {
+				run++
+			}
+		}
+		b.WriteString(strings.Repeat(`\`, run))
+		run = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, run))
+	return b.String()
+}
+
+// startsExpansion reports whether a sigil followed by rest would start an
+// expansion or a command substitution.
+func startsExpansion(rest string) bool {
+	r, _ := utf8.DecodeRuneInString(rest)
+	return r == '{' || r == '(' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// ExpandCompat replaces $var and ${var} in the string based on the mapping
+// function with exactly the semantics of os.Expand, as a drop-in
+// replacement. Operators are not supported, so ${var:-x} looks up the
+// variable "var:-x", and $$ looks up the variable "$". It never fails.
+func ExpandCompat(s string, mapping Mapping) string {
+	t := new(Template)
+	t.tree, _ = (&parse.Tree{ExpandCompat: true}).Parse(s)
+	out, _ := t.Execute(mapping)
+	return out
+}
@@ -3,6 +3,7 @@ package envsubst
 import (
 	"bytes"
 	"io"
+	"strings"
 
 	"github.com/logandavies181/envsubst/parse"
 )
@@ -11,6 +12,14 @@ type NodeInfo struct {
 	node parse.Node
 	args []string
 	name string
+
+	// the variable's value before the substitution function runs, as
+	// resolved by the base mapping
+	value string
+	set   bool
+
+	// lookup resolves other variables for a registered function in Result
+	lookup MappingSet
 }
 
 // Orig returns the original text of the substitution template,
@@ -25,15 +34,56 @@ func (n NodeInfo) Args() []string {
 	return n.args
 }
 
+// WithArgs returns a copy of the NodeInfo with its arguments replaced by
+// args, so that Result runs the substitution function with them. This
+// allows a mapping to adjust the arguments, e.g. sanitize a default value,
+// before the substitution function runs.
+func (n NodeInfo) WithArgs(args []string) NodeInfo {
+	n.args = append([]string(nil), args...)
+	return n
+}
+
 // Fn returns the string representing the shell-style substitution function
 // e.g. `:-`
 func (n NodeInfo) Fn() string {
 	return n.name
 }
 
+// Value returns the value of the variable before the substitution function
+// runs, as resolved by the base mapping of ExecuteAdvancedSet. It is empty
+// if there is no base mapping.
+func (n NodeInfo) Value() string {
+	return n.value
+}
+
+// WasSet reports whether the base mapping of ExecuteAdvancedSet found the
+// variable, so the mapping can tell whether a default function would use
+// its default. It is false if there is no base mapping.
+func (n NodeInfo) WasSet() bool {
+	return n.set
+}
+
 // Result returns the value that will be set by the substitution function
-// if it runs
+// if it runs. A registered function such as ${var|name} looks up other
+// variables with the base mapping of ExecuteAdvancedSet, and its result is
+// empty if it fails.
 func (n NodeInfo) Result(mapResult string) string {
+	if strings.HasPrefix(n.name, "|") {
+		fn, ok := lookupFunction(n.name[1:])
+		if !ok {
+			return ""
+		}
+		lookup := n.lookup
+		if lookup == nil {
+			lookup = func(string) (string, bool) { return "", false }
+		}
+		v, err := fn(lookup, mapResult, n.args...)
+		if err != nil {
+			return ""
+		}
+		return v
+	}
+
 	fn := lookupFunc(n.Fn(), len(n.Args()))
 
 	return fn(mapResult, n.Args()...)
@@ -44,6 +94,14 @@ func (n NodeInfo) Result(mapResult string) string {
 // string and whether or not to continue processing
 type AdvancedMapping func(string, NodeInfo) (mapped string, shouldContinue bool)
 
+// PassthroughMapping is an AdvancedMapping that leaves every substitution
+// as its original text, so that evaluating with it reproduces the template.
+// Escape sequences such as $$ are still processed. It is useful for
+// round-trip tests and as the fallback of partial evaluation.
+func PassthroughMapping(_ string, n NodeInfo) (string, bool) {
+	return n.Orig(), false
+}
+
 // EvalAdvanced allows the caller to control how ${var} is mapped and how its
 // nested parameters are evaluated.
 //
@@ -57,17 +115,44 @@ func EvalAdvanced(s string, mapping AdvancedMapping) (string, error) {
 	return t.ExecuteAdvanced(mapping)
 }
 
+// EvalAdvancedSet is like EvalAdvanced, but each variable is first resolved
+// by base, so that mapping can see its value and whether it is set through
+// NodeInfo.Value and NodeInfo.WasSet.
+func EvalAdvancedSet(s string, base MappingSet, mapping AdvancedMapping) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteAdvancedSet(base, mapping)
+}
+
 // ExecuteAdvanced applies a parsed template to the specified data mapping,
 // allowing greater control over execution
 func (t *Template) ExecuteAdvanced(mapping AdvancedMapping) (str string, err error) {
+	return t.executeAdvanced(nil, mapping)
+}
+
+// ExecuteAdvancedSet is like ExecuteAdvanced, but each variable is first
+// resolved by base and passed to mapping in its NodeInfo. If mapping
+// continues with the value from base, default functions use whether base
+// found the variable rather than whether the value is empty.
+func (t *Template) ExecuteAdvancedSet(base MappingSet, mapping AdvancedMapping) (str string, err error) {
+	return t.executeAdvanced(func(name string, _ ResolveContext) (string, bool, error) {
+		v, ok := base(name)
+		return v, ok, nil
+	}, mapping)
+}
+
+func (t *Template) executeAdvanced(base resolver, mapping AdvancedMapping) (str string, err error) {
 	b := new(bytes.Buffer)
 	s := new(state)
 	s.node = t.tree.Root
+	s.mapper = base
 	s.advMapper = mapping
 	s.writer = b
 	err = t.evalAdvanced(s)
 	if err != nil {
-		return
+		return b.String(), err
 	}
 	return b.String(), nil
 }
@@ -80,7 +165,39 @@ func (t *Template) evalAdvanced(s *state) (err error) {
 		err = t.evalAdvancedFunc(s, node)
 	case *parse.ListNode:
 		err = t.evalAdvancedList(s, node)
+	case *parse.CommandNode:
+		err = t.evalCommand(s, node)
+	case *parse.IfNode:
+		err = t.evalAdvancedIf(s, node)
+	}
+	return err
+}
+
+// evalAdvancedIf maps the variable of a conditional block like that of a
+// plain reference. If processing stops, the mapped string replaces the
+// whole block.
+func (t *Template) evalAdvancedIf(s *state, node *parse.IfNode) error {
+	info, err := s.resolveBase(NodeInfo{node: node}, node.Cond)
+	if err != nil {
+		return err
+	}
+
+	v, shouldContinue := s.advMapper(node.Cond.Param, info)
+	if !shouldContinue {
+		_, err := io.WriteString(s.writer, v)
+		return err
+	}
+
+	branch := node.Then
+	if v == "" {
+		branch = node.Else
 	}
+	if branch == nil {
+		return nil
+	}
+	s.node = branch
+	err = t.evalAdvanced(s)
+	s.node = node
 	return err
 }
 
@@ -115,14 +232,65 @@ func (t *Template) evalAdvancedFunc(s *state, node *parse.FuncNode) error {
 	s.writer = w
 	s.node = node
 
-	v, shouldContinue := s.advMapper(node.Param, NodeInfo{node, args, node.Name})
+	info, err := s.resolveBase(NodeInfo{node: node, args: args, name: node.Name}, node)
+	if err != nil {
+		return err
+	}
+	info.lookup = s.baseLookup(node)
+
+	v, shouldContinue := s.advMapper(node.Param, info)
 	if !shouldContinue {
 		_, err := io.WriteString(s.writer, v)
 		return err
 	}
 
-	fn := lookupFunc(node.Name, len(args))
+	if isDefaultFunc(node.Name) {
+		// a value passed through from the base mapping keeps whether it
+		// was set, so that ${var-default} keeps an empty value
+		set := v != ""
+		if s.mapper != nil && v == info.value {
+			set = info.set
+		}
+		v, err = evalDefault(node, v, set, t.emptyUnset(node.Name), func() ([]string, error) {
+			return args, nil
+		})
+	} else {
+		v, err = t.apply(s, node, v, args)
+	}
+	if err != nil {
+		return err
+	}
 
-	_, err := io.WriteString(s.writer, fn(v, args...))
+	_, err = io.WriteString(s.writer, v)
 	return err
 }
+
+// baseLookup returns a lookup of other variables with the base mapping, for
+// the registered function of node, or nil without a base mapping. Errors of
+// the mapping leave the variable unset.
+func (s *state) baseLookup(node *parse.FuncNode) MappingSet {
+	if s.mapper == nil {
+		return nil
+	}
+	return func(name string) (string, bool) {
+		v, set, err := s.mapper(name, ResolveContext{node, false})
+		if err != nil {
+			return "", false
+		}
+		return v, set
+	}
+}
+
+// resolveBase adds the value of the variable of node, as resolved by the
+// base mapping, to info. Without a base mapping info is unchanged.
+func (s *state) resolveBase(info NodeInfo, node *parse.FuncNode) (NodeInfo, error) {
+	if s.mapper == nil {
+		return info, nil
+	}
+	var err error
+	info.value, info.set, err = s.mapper(node.Param, ResolveContext{node, false})
+	if err != nil {
+		return info, &MappingError{Name: node.Param, Err: err}
+	}
+	return info, nil
+}
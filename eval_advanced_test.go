@@ -2,6 +2,7 @@ package envsubst
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,3 +18,108 @@ func TestEvalAdvanced(t *testing.T) {
 
 	assert.Equal(t, `"5011"`, out)
 }
+
+func TestPassthroughMapping(t *testing.T) {
+	input := `text $bare ${plain} ${#len} ${a,,} ${b^} ${c:1:2} ${d##*.} ` +
+		`${e%/} ${f//x/y} ${g/#x/} ${h:-${i:=${j:-x}}-${k}} $(cmd ${l}) end`
+
+	out, err := EvalAdvanced(input, PassthroughMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, input, out)
+}
+
+func TestNodeInfoWithArgs(t *testing.T) {
+	// enforce a policy of no plain http defaults
+	m := func(in string, n NodeInfo) (string, bool) {
+		args := n.Args()
+		for i, arg := range args {
+			if strings.HasPrefix(arg, "http://") {
+				args[i] = "https://" + strings.TrimPrefix(arg, "http://")
+			}
+		}
+		return n.WithArgs(args).Result(""), false
+	}
+
+	out, err := EvalAdvanced("${URL:-http://example.com}", m)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com", out)
+
+	// the original node info is unchanged
+	n := NodeInfo{args: []string{"a"}}
+	assert.Equal(t, []string{"b"}, n.WithArgs([]string{"b"}).Args())
+	assert.Equal(t, []string{"a"}, n.Args())
+}
+
+func TestNodeInfoWasSet(t *testing.T) {
+	base := func(s string) (string, bool) {
+		v, ok := map[string]string{"HOST": "example.com", "PORT": ""}[s]
+		return v, ok
+	}
+
+	// report which variables fall back to their defaults
+	var defaulted []string
+	m := func(in string, n NodeInfo) (string, bool) {
+		if !n.WasSet() {
+			defaulted = append(defaulted, in)
+		}
+		return n.Value(), true
+	}
+
+	out, err := EvalAdvancedSet("${HOST:-localhost}:${PORT-80}/${PATH_PREFIX:-api}", base, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:/api", out)
+	assert.Equal(t, []string{"PATH_PREFIX"}, defaulted)
+
+	// the original accessors are unchanged
+	m = func(in string, n NodeInfo) (string, bool) {
+		assert.Equal(t, "${HOST:-localhost}", n.Orig())
+		assert.Equal(t, ":-", n.Fn())
+		assert.Equal(t, []string{"localhost"}, n.Args())
+		assert.Equal(t, "example.com", n.Value())
+		return n.Value(), true
+	}
+	_, err = EvalAdvancedSet("${HOST:-localhost}", base, m)
+	assert.Nil(t, err)
+
+	// without a base mapping nothing is resolved
+	m = func(in string, n NodeInfo) (string, bool) {
+		assert.False(t, n.WasSet())
+		assert.Equal(t, "", n.Value())
+		return "x", false
+	}
+	out, err = EvalAdvanced("${HOST}", m)
+	assert.Nil(t, err)
+	assert.Equal(t, "x", out)
+}
+
+func TestNodeInfoResultFunc(t *testing.T) {
+	m := func(in string, n NodeInfo) (string, bool) {
+		return n.Result("x"), false
+	}
+
+	for input, want := range map[string]string{
+		"${A|eq:x:yes:no}":   "yes",
+		"${A|pathjoin:b}":    "x/b",
+		"${A|split::1}":      "",
+		"${A|eq:too:many}":   "",
+		"${A|coalesce:B}":    "x",
+		"${A:-default}":      "x",
+		"${A|urlencode:bad}": "",
+	} {
+		out, err := EvalAdvanced(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, out, input)
+	}
+
+	// a mapping function looks up variables with the base mapping
+	base := func(s string) (string, bool) {
+		v, ok := map[string]string{"B": "b"}[s]
+		return v, ok
+	}
+	m = func(in string, n NodeInfo) (string, bool) {
+		return n.Result(""), false
+	}
+	out, err := EvalAdvancedSet("${A|coalesce:C:B}", base, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", out)
+}
@@ -1,8 +1,13 @@
 package envsubst
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/logandavies181/envsubst/parse"
 )
 
 // test cases sourced from tldp.org
@@ -27,6 +32,12 @@ func TestExpand(t *testing.T) {
 			input:  "${#var01}",
 			output: "12",
 		},
+		// length of a nested expansion, in runes
+		{
+			params: map[string]string{"var01": "abcdEFGH28ij"},
+			input:  "${#${var01:-abc}} ${#${var02:-abc}} ${#${var02:-héllo}}",
+			output: "12 3 5",
+		},
 		// uppercase first
 		{
 			params: map[string]string{"var01": "abcdEFGH28ij"},
@@ -63,6 +74,28 @@ func TestExpand(t *testing.T) {
 			input:  "${path_name:11:5}",
 			output: "ideas",
 		},
+		// quoted
+		{
+			params: map[string]string{"var": "a b\tc"},
+			input:  "${var@Q} ${var:0:3}",
+			output: "$'a b\\tc' a b",
+		},
+		// substring with omitted, zero and non-zero length
+		{
+			params: map[string]string{"s": "abcdefg"},
+			input:  "${s:2}",
+			output: "cdefg",
+		},
+		{
+			params: map[string]string{"s": "abcdefg"},
+			input:  "${s:2:0}",
+			output: "",
+		},
+		{
+			params: map[string]string{"s": "abcdefg"},
+			input:  "${s:2:3}",
+			output: "cde",
+		},
 		// default not used
 		{
 			params: map[string]string{"var": "abc"},
@@ -95,6 +128,33 @@ func TestExpand(t *testing.T) {
 			input:  "${var:=xyz}",
 			output: "xyz",
 		},
+		// alternate value
+		{
+			params: map[string]string{"var": "abc"},
+			input:  "${var:+xyz}",
+			output: "xyz",
+		},
+		{
+			params: map[string]string{},
+			input:  "${var:+xyz}",
+			output: "",
+		},
+		// error if unset
+		{
+			params: map[string]string{"var": "abc"},
+			input:  "${var:?must be set}",
+			output: "abc",
+		},
+		{
+			params: map[string]string{},
+			input:  "${var:?must be set}",
+			err:    fmt.Errorf("var: must be set"),
+		},
+		{
+			params: map[string]string{},
+			input:  "${var:?}",
+			err:    fmt.Errorf("var: parameter null or not set"),
+		},
 		// replace suffix
 		{
 			params: map[string]string{"stringZ": "abcABC123ABCabc"},
@@ -107,6 +167,27 @@ func TestExpand(t *testing.T) {
 			input:  "${stringZ/#abc/XYZ}",
 			output: "XYZABC123ABCabc",
 		},
+		// append and prepend with an empty pattern
+		{
+			params: map[string]string{"stringZ": "abc"},
+			input:  "${stringZ/%/.txt} ${stringZ/#/my-}",
+			output: "abc.txt my-abc",
+		},
+		{
+			params: map[string]string{"stringZ": "abc", "ext": ".txt"},
+			input:  "${stringZ/%/${ext}} ${stringZ/%bc/${ext}} ${stringZ/%x/${ext}}",
+			output: "abc.txt a.txt abc",
+		},
+		{
+			params: map[string]string{},
+			input:  "${stringZ/%/.txt}${stringZ/#/my-}",
+			output: ".txtmy-",
+		},
+		{
+			params: map[string]string{"stringZ": "abc"},
+			input:  "${stringZ///x} ${stringZ////x}",
+			output: "abc abc",
+		},
 		// replace all
 		{
 			params: map[string]string{"stringZ": "abcABC123ABCabc"},
@@ -119,6 +200,22 @@ func TestExpand(t *testing.T) {
 			input:  "${stringZ/abc/xyz}",
 			output: "xyzABC123ABCabc",
 		},
+		// replace glob patterns
+		{
+			params: map[string]string{"stringZ": "abcABC123ABCabc"},
+			input:  "${stringZ//[0-9]/#} ${stringZ/B*B/-} ${stringZ/#*C/-} ${stringZ/%C*/-}",
+			output: "abcABC###ABCabc abcA-Cabc -abc abcAB-",
+		},
+		{
+			params: map[string]string{"stringZ": "a*b*c"},
+			input:  `${stringZ//\*/+}`,
+			output: "a+b+c",
+		},
+		{
+			params: map[string]string{"stringZ": `x\y\z`},
+			input:  `${stringZ/\\/-} ${stringZ//\\/-} ${stringZ/#x\\/-} ${stringZ/%\\z/-}`,
+			output: `x-y\z x-y-z -y\z x\y-`,
+		},
 		// delete shortest match prefix
 		{
 			params: map[string]string{"filename": "bash.string.txt"},
@@ -242,6 +339,11 @@ func TestExpand(t *testing.T) {
 			input:  "${var2:-${var}}",
 			output: "foo",
 		},
+		{
+			params: map[string]string{"B": "foo", "C": "bar"},
+			input:  "${A:-${B}${C}} ${A:-${B}${UNSET}${C}} ${B:-${B}${C}}",
+			output: "foobar foobar foo",
+		},
 		{
 			params: map[string]string{"": ""},
 			input:  "${var:-$$}",
@@ -267,6 +369,107 @@ func TestExpand(t *testing.T) {
 			input:  `${var:-${var2:-$$}}`,
 			output: `$$`,
 		},
+		// braces are only special within an expansion
+		{
+			params: map[string]string{"x": "foo"},
+			input:  "a{b}c",
+			output: "a{b}c",
+		},
+		{
+			params: map[string]string{"x": "foo"},
+			input:  "${x}}extra",
+			output: "foo}extra",
+		},
+		{
+			params: map[string]string{"x": "foo"},
+			input:  "pre{${x}}post",
+			output: "pre{foo}post",
+		},
+		{
+			params: map[string]string{"x": "foo"},
+			input:  "}{ {${x} $}",
+			output: "}{ {foo $}",
+		},
+		{
+			params: map[string]string{},
+			input:  "${x:-{}}",
+			output: "{}",
+		},
+		// command substitution is passed through verbatim
+		{
+			params: map[string]string{"var": "foo"},
+			input:  "$(date) ${var}",
+			output: "$(date) foo",
+		},
+		// replace without a replacement string removes the match
+		{
+			params: map[string]string{"var": "foo.bar.baz"},
+			input:  "${var/.}|${var//.}|${var/#foo}|${var/%baz}",
+			output: "foobar.baz|foobarbaz|.bar.baz|foo.bar.",
+		},
+		// remove an empty pattern
+		{
+			params: map[string]string{"var": "foo.bar"},
+			input:  "${var#}|${var##}|${var%}|${var%%}|${#var}",
+			output: "foo.bar|foo.bar|foo.bar|foo.bar|7",
+		},
+		// escaped default arguments
+		{
+			params: map[string]string{},
+			input:  `${var:-a\}b}`,
+			output: "a}b",
+		},
+		{
+			params: map[string]string{},
+			input:  `${var:-a\\b}`,
+			output: `a\b`,
+		},
+		{
+			params: map[string]string{"var": "foo"},
+			input:  `${var:+\}${var}\}}`,
+			output: "}foo}",
+		},
+		{
+			params: map[string]string{},
+			input:  `${var:-a\\}`,
+			output: `a\`,
+		},
+		{
+			params: map[string]string{},
+			input:  `${var:-a\}`,
+			err:    fmt.Errorf("unable to parse substitution within function"),
+		},
+		{
+			params: map[string]string{},
+			input:  `${var:-C:\path}`,
+			output: `C:\path`,
+		},
+		// the backslashes of remove patterns escape the pattern itself
+		{
+			params: map[string]string{"var": `a\b\`},
+			input:  `${var%\\}`,
+			output: `a\b`,
+		},
+		{
+			params: map[string]string{"var": `a\b\`},
+			input:  `${var#a\\}`,
+			output: `b\`,
+		},
+		{
+			params: map[string]string{"var": `a\b\`},
+			input:  `${var%%\\*}`,
+			output: "a",
+		},
+		{
+			params: map[string]string{"var": "foo"},
+			input:  "$(a $(b $var) ${var}) $var",
+			output: "$(a $(b $var) ${var}) foo",
+		},
+		{
+			params: map[string]string{"": ""},
+			input:  "$()",
+			output: "$()",
+		},
 		// newline
 		{
 			params: map[string]string{"": ""},
@@ -301,3 +504,975 @@ func TestExpand(t *testing.T) {
 		})
 	}
 }
+
+func TestMustParse(t *testing.T) {
+	tmpl := MustParse("${NAME:-world}")
+	output, err := tmpl.Execute(func(string) string { return "" })
+	if err != nil || output != "world" {
+		t.Errorf("Want MustParse template to expand to %q, got %q and error %v", "world", output, err)
+	}
+
+	defer func() {
+		want := `envsubst: Parse("a ${NAME"): missing closing brace at offset 8`
+		if r := recover(); r != want {
+			t.Errorf("Want MustParse to panic with %q, got %v", want, r)
+		}
+	}()
+	MustParse("a ${NAME")
+}
+
+func TestEvalE(t *testing.T) {
+	errUnavailable := errors.New("secret store unavailable")
+
+	var calls []string
+	mapping := func(s string) (string, error) {
+		calls = append(calls, s)
+		if s == "password" {
+			return "", errUnavailable
+		}
+		return "value of " + s, nil
+	}
+
+	output, err := EvalE("${user}:${password}@${host}", mapping)
+	if err == nil {
+		t.Fatalf("Want error, got output %q", output)
+	}
+	var mappingErr *MappingError
+	if !errors.As(err, &mappingErr) || mappingErr.Name != "password" {
+		t.Errorf("Want MappingError for variable password, got %v", err)
+	}
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("Want error to wrap mapping error, got %v", err)
+	}
+	if want := "unable to resolve variable password: secret store unavailable"; err.Error() != want {
+		t.Errorf("Want error %q, got %q", want, err.Error())
+	}
+	if len(calls) != 2 {
+		t.Errorf("Want evaluation to stop at the first error, got lookups %v", calls)
+	}
+
+	output, err = EvalE("${user}@${host}", mapping)
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if want := "value of user@value of host"; output != want {
+		t.Errorf("Want %q, got %q", want, output)
+	}
+}
+
+func TestEvalSubstringNegative(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"short": "abc"}[s]
+	}
+
+	output, err := EvalWithOptions("${short: -2}", mapping, nil)
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if output != "bc" {
+		t.Errorf("Want negative offset within the string to give %q, got %q", "bc", output)
+	}
+
+	_, err = EvalWithOptions("${short: -300}", mapping, nil)
+	if err != ErrSubstringNegative {
+		t.Errorf("Want error %v, got %v", ErrSubstringNegative, err)
+	}
+
+	opts := &Options{SubstringNegativeClamp: true}
+	for input, want := range map[string]string{
+		"${short: -300}":   "abc",
+		"${short: -300:2}": "ab",
+	} {
+		output, err = EvalWithOptions(input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", input, err)
+		}
+		if output != want {
+			t.Errorf("Want %q expanded to %q with clamping, got %q", input, want, output)
+		}
+	}
+}
+
+func TestEvalSubstringRunes(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"emoji": "🍎🍌🍒🍇", "short": "日本語"}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${emoji: -2:1}":   "🍒",
+		"${emoji: -1}":     "🍇",
+		"${emoji:1:-1}":    "🍌🍒",
+		"${emoji: -3:-2}":  "🍌",
+		"${emoji:4}":       "",
+		"${short:1:1}":     "本",
+		"${short: -3:-3}":  "",
+		"${short:0:-1}日本": "日本日本",
+	} {
+		output, err := EvalWithOptions(input, mapping, nil)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", input, err)
+		}
+		if output != want {
+			t.Errorf("Want %q expanded to %q, got %q", input, want, output)
+		}
+	}
+
+	for _, input := range []string{"${emoji: -5:1}", "${short:2:-2}"} {
+		_, err := EvalWithOptions(input, mapping, nil)
+		if err != ErrSubstringNegative {
+			t.Errorf("Want %q to fail with %v, got %v", input, ErrSubstringNegative, err)
+		}
+	}
+
+	opts := &Options{SubstringNegativeClamp: true}
+	for input, want := range map[string]string{
+		"${emoji: -5:1}": "🍎",
+		"${short:2:-2}":  "",
+	} {
+		output, err := EvalWithOptions(input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", input, err)
+		}
+		if output != want {
+			t.Errorf("Want %q expanded to %q with clamping, got %q", input, want, output)
+		}
+	}
+}
+
+func TestEvalPathJoin(t *testing.T) {
+	for _, test := range []struct {
+		base, path, want string
+	}{
+		{"https://example.com/", "/api", "https://example.com/api"},
+		{"https://example.com/", "api", "https://example.com/api"},
+		{"https://example.com", "/api", "https://example.com/api"},
+		{"https://example.com", "api", "https://example.com/api"},
+		{"https://example.com/v1/", "/api/", "https://example.com/v1/api/"},
+		{"/", "/api", "/api"},
+		{"", "", "/"},
+		// only a single slash is removed from each side
+		{"https://example.com//", "//api", "https://example.com///api"},
+	} {
+		mapping := func(s string) string {
+			return map[string]string{"BASE": test.base, "PATH": test.path}[s]
+		}
+		got, err := Eval("${BASE%/}/${PATH#/}", mapping)
+		if err != nil {
+			t.Errorf("Want %q and %q joined but got error %v", test.base, test.path, err)
+		}
+		if got != test.want {
+			t.Errorf("Want %q and %q joined as %q, got %q", test.base, test.path, test.want, got)
+		}
+	}
+}
+
+func TestEvalArgsRestoresState(t *testing.T) {
+	tmpl, err := Parse("${A:-x${B:?required}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := tmpl.tree.Root.(*parse.FuncNode)
+
+	var b strings.Builder
+	s := &state{writer: &b, node: node, mapper: simpleResolver(func(string) string { return "" })}
+	if _, err := tmpl.evalArgs(s, node); err == nil {
+		t.Fatal("Want error, got nil")
+	}
+	// the state is restored for evaluation that continues past the error
+	if s.writer != &b || s.node != node || s.inDefault || s.inArgs {
+		t.Errorf("Want the state restored after an error, got %+v", s)
+	}
+}
+
+func TestEvalNestedDefaults(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		output  string
+		lookups []string
+	}{
+		{
+			params:  map[string]string{"A": "a", "B": "b", "C": "c"},
+			output:  "a",
+			lookups: []string{"A"},
+		},
+		{
+			params:  map[string]string{"B": "b", "C": "c"},
+			output:  "b",
+			lookups: []string{"A", "B"},
+		},
+		{
+			params:  map[string]string{"C": "c"},
+			output:  "c",
+			lookups: []string{"A", "B", "C"},
+		},
+		{
+			params:  map[string]string{},
+			output:  "fallback",
+			lookups: []string{"A", "B", "C"},
+		},
+	}
+
+	for _, test := range tests {
+		var lookups []string
+		output, err := Eval("${A:-${B:-${C:-fallback}}}", func(s string) string {
+			lookups = append(lookups, s)
+			return test.params[s]
+		})
+		if err != nil {
+			t.Fatalf("Want no error, got %v", err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q, got %q", test.output, output)
+		}
+		if fmt.Sprint(lookups) != fmt.Sprint(test.lookups) {
+			t.Errorf("Want lookups %v, got %v", test.lookups, lookups)
+		}
+	}
+}
+
+func TestEvalDefaultAlternate(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		output  string
+		lookups []string
+	}{
+		{
+			params:  map[string]string{"A": "a", "B": "b"},
+			output:  "a",
+			lookups: []string{"A"},
+		},
+		{
+			params:  map[string]string{"A": "a"},
+			output:  "a",
+			lookups: []string{"A"},
+		},
+		{
+			params:  map[string]string{"B": "b"},
+			output:  "fromB",
+			lookups: []string{"A", "B"},
+		},
+		{
+			params:  map[string]string{},
+			output:  "",
+			lookups: []string{"A", "B"},
+		},
+	}
+
+	for _, test := range tests {
+		var lookups []string
+		mapping := func(s string) string {
+			lookups = append(lookups, s)
+			return test.params[s]
+		}
+		output, err := Eval("${A:-${B:+fromB}}", mapping)
+		if err != nil {
+			t.Fatalf("Want no error, got %v", err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q, got %q", test.output, output)
+		}
+		if fmt.Sprint(lookups) != fmt.Sprint(test.lookups) {
+			t.Errorf("Want lookups %v, got %v", test.lookups, lookups)
+		}
+
+		// an unset B is not an error, as the alternate applies to it
+		output, err = EvalWithOptions("${A:-${B:+fromB}}", mapping, &Options{Strict: true})
+		if err != nil || output != test.output {
+			t.Errorf("Want strict %q, got %q, %v", test.output, output, err)
+		}
+	}
+}
+
+func TestEvalUnsetPlaceholder(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"set": "value"}[s]
+	}
+	opts := &Options{
+		UnsetPlaceholder: func(name string) string {
+			return "<UNSET:" + name + ">"
+		},
+	}
+
+	tests := []struct {
+		input   string
+		without string
+		with    string
+	}{
+		{"${set} ${unset}", "value ", "value <UNSET:unset>"},
+		{"$unset ${unset^^}", " ", "<UNSET:unset> <UNSET:unset>"},
+		{"${unset:-default}", "default", "default"},
+		{"${unset:=default}", "default", "default"},
+		{"${unset:+alternate}", "", ""},
+		{"${unset:-${other}}", "", "<UNSET:other>"},
+	}
+
+	for _, test := range tests {
+		output, err := Eval(test.input, mapping)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.without {
+			t.Errorf("Want %q expanded to %q without a placeholder, got %q", test.input, test.without, output)
+		}
+
+		output, err = EvalWithOptions(test.input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.with {
+			t.Errorf("Want %q expanded to %q with a placeholder, got %q", test.input, test.with, output)
+		}
+	}
+}
+
+func TestEvalSet(t *testing.T) {
+	var mapping MappingSet = func(s string) (string, bool) {
+		v, ok := map[string]string{"empty": ""}[s]
+		return v, ok
+	}
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"[${empty=default}]", "[]"},
+		{"[${unset=default}]", "[default]"},
+		{"[${empty:=default}]", "[default]"},
+		{"[${empty-default}]", "[]"},
+		{"[${unset-default}]", "[default]"},
+		{"[${empty:-default}]", "[default]"},
+		{"[${empty+alternate}]", "[alternate]"},
+		{"[${unset+alternate}]", "[]"},
+		{"[${empty:+alternate}]", "[]"},
+		{"[${empty?must be set}]", "[]"},
+	}
+
+	for _, test := range tests {
+		output, err := EvalSet(test.input, mapping)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q expanded to %q, got %q", test.input, test.output, output)
+		}
+	}
+
+	_, err := EvalSet("${unset?must be set}", mapping)
+	if err == nil || err.Error() != "unset: must be set" {
+		t.Errorf("Want unset error, got %v", err)
+	}
+}
+
+func TestEvalMaxNestingDepth(t *testing.T) {
+	input := "${a:-${b:-${c:-x}}}"
+
+	output, err := EvalWithOptions(input, os.Getenv, &Options{MaxNestingDepth: 3})
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if output != "x" {
+		t.Errorf("Want %q expanded to %q, got %q", input, "x", output)
+	}
+
+	_, err = EvalWithOptions(input, os.Getenv, &Options{MaxNestingDepth: 2})
+	if !errors.Is(err, parse.ErrNestingTooDeep) {
+		t.Errorf("Want error %v, got %v", parse.ErrNestingTooDeep, err)
+	}
+}
+
+func TestEvalDisableDollarEscape(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"x": "foo", "string": "bar"}[s]
+	}
+	opts := &Options{DisableDollarEscape: true}
+
+	tests := []struct {
+		input   string
+		without string
+		with    string
+	}{
+		{"pid=$$", "pid=$$", "pid=$$"},
+		{"$$string", "$bar", "$$string"},
+		{"$${x}", "$foo", "$${x}"},
+		{"$$ ${x}", "$$ foo", "$$ foo"},
+		{"${missing:-$$}", "$$", "$$"},
+	}
+
+	for _, test := range tests {
+		output, err := Eval(test.input, mapping)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.without {
+			t.Errorf("Want %q expanded to %q with the escape, got %q", test.input, test.without, output)
+		}
+
+		output, err = EvalWithOptions(test.input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.with {
+			t.Errorf("Want %q expanded to %q without the escape, got %q", test.input, test.with, output)
+		}
+	}
+}
+
+func TestEvalSigil(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"x": "foo", "string": "bar"}[s]
+	}
+
+	tests := []struct {
+		input  string
+		output string
+		opts   Options
+	}{
+		{"@{x}", "foo", Options{}},
+		{"@x-@string", "foo-bar", Options{}},
+		{"@{missing:-@x}", "foo", Options{}},
+		{"pid=@@ @x", "pid=@@ foo", Options{}},
+		{"@@{x}", "@foo", Options{}},
+		{"@@{x}", "@@{x}", Options{DisableDollarEscape: true}},
+		{"$x ${x} @", "$x ${x} @", Options{}},
+		{"a @ b", "a @ b", Options{}},
+		{`\@{x}`, `\foo`, Options{}},
+		{`\@{x}`, "@{x}", Options{BackslashEscape: true}},
+	}
+
+	for _, test := range tests {
+		opts := test.opts
+		opts.Sigil = '@'
+		output, err := EvalWithOptions(test.input, mapping, &opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q expanded to %q, got %q", test.input, test.output, output)
+		}
+	}
+}
+
+func TestEvalStrictDollar(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"name": "foo"}[s]
+	}
+
+	for _, input := range []string{"$ ", "$}", "price: 5$"} {
+		output, err := EvalWithOptions(input, mapping, nil)
+		if err != nil || output != input {
+			t.Errorf("Want %q left as it is, got %q, %v", input, output, err)
+		}
+
+		_, err = EvalWithOptions(input, mapping, &Options{StrictDollar: true})
+		if !errors.Is(err, parse.ErrDanglingDollar) {
+			t.Errorf("Want %q to fail with a dangling dollar sign, got %v", input, err)
+		}
+	}
+
+	output, err := EvalWithOptions("$name $${name}", mapping, &Options{StrictDollar: true})
+	if err != nil || output != "foo $foo" {
+		t.Errorf("Want %q, got %q, %v", "foo $foo", output, err)
+	}
+}
+
+func TestExpandCompat(t *testing.T) {
+	mapping := func(s string) string {
+		if s == "empty" {
+			return ""
+		}
+		return "<" + s + ">"
+	}
+
+	corpus := []string{
+		"", "text", "$", "$$", "$$$", "a$", "$ a", "$-", "$1x", "$@$*$#$!$?",
+		"$var", "${var}", "$var_1.x", "${var:-default}", "${var/a/b}",
+		"${", "${}", "${}x", "${var", "a ${b} c $d e", "${$}", "${1}0",
+		"$$var", "$${var}", "${empty}|$empty", "$(cmd)", "${ var }",
+		"\\$var", "日本$語 ${語}", "${a}${b}$c$d",
+	}
+
+	for _, input := range corpus {
+		want := os.Expand(input, mapping)
+		if got := ExpandCompat(input, mapping); got != want {
+			t.Errorf("Want %q expanded to %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	tmpl, err := Parse("a ${b:-${c}} d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := tmpl.Clone()
+
+	// rename every variable in the clone, including the nested one, and
+	// append to its root list
+	walkFuncs(clone.tree.Root, func(node *parse.FuncNode) {
+		node.Param += "2"
+	})
+	list := clone.tree.Root.(*parse.ListNode)
+	list.Nodes = append(list.Nodes, &parse.TextNode{Value: "!"})
+
+	mapping := func(s string) string {
+		return map[string]string{"c": "C", "c2": "C2"}[s]
+	}
+	for _, test := range []struct {
+		tmpl *Template
+		want string
+	}{
+		{tmpl, "a C d"},
+		{clone, "a C2 d!"},
+	} {
+		got, err := test.tmpl.Execute(mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Want %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestEvalRecoverUnterminated(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"VAR": "foo"}[s]
+	}
+	opts := &Options{RecoverUnterminated: true}
+	for input, want := range map[string]string{
+		"a ${VAR":        "a foo",
+		"a ${UNSET:-def": "a def",
+		"a ${VAR:":       "a foo",
+	} {
+		got, err := EvalWithOptions(input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", input, err)
+		}
+		if got != want {
+			t.Errorf("Want %q expanded to %q, got %q", input, want, got)
+		}
+
+		if _, err := EvalWithOptions(input, mapping, nil); err == nil {
+			t.Errorf("Want %q to fail without recovery", input)
+		}
+	}
+}
+
+func TestEvalValueAffix(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"a": "x", "b": "y"}[s]
+	}
+	tests := []struct {
+		opts   Options
+		input  string
+		output string
+	}{
+		{Options{ValuePrefix: `"`, ValueSuffix: `"`}, "a=$a b=${b}", `a="x" b="y"`},
+		{Options{ValuePrefix: "/opt/"}, "${a^^}:${c:-$b}", "/opt/X:/opt/y"},
+		{Options{ValuePrefix: `"`, ValueSuffix: `"`}, "c=$c", `c=""`},
+		{Options{ValuePrefix: `"`, ValueSuffix: `"`, SkipEmptyAffix: true}, "c=$c a=$a", `c= a="x"`},
+	}
+	for _, test := range tests {
+		output, err := EvalWithOptions(test.input, mapping, &test.opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q expanded to %q, got %q", test.input, test.output, output)
+		}
+	}
+}
+
+func TestEvalConditionals(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"ON": "1", "NAME": "app"}[s]
+	}
+	opts := &Options{Conditionals: true, Strict: true}
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"a${if:ON} $NAME${endif}!", "a app!"},
+		{"a${if:OFF} $NAME${endif}!", "a!"},
+		{"${if:OFF}on${else}off ${NAME:-x}${endif}", "off app"},
+		{"${if:ON}${if:OFF}both${else}only on${endif}${endif}", "only on"},
+		{"${if:OFF}${UNSET}${endif}", ""},
+	}
+	for _, test := range tests {
+		output, err := EvalWithOptions(test.input, mapping, opts)
+		if err != nil {
+			t.Fatalf("Want %q expanded but got error %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("Want %q expanded to %q, got %q", test.input, test.output, output)
+		}
+	}
+
+	_, err := EvalWithOptions("${if:ON} unclosed", mapping, opts)
+	if !errors.Is(err, parse.ErrUnclosedIf) {
+		t.Errorf("Want error %v, got %v", parse.ErrUnclosedIf, err)
+	}
+}
+
+func TestEvalNoExpansions(t *testing.T) {
+	input := strings.Repeat("static text with {braces} and \\ backslashes\n", 100)
+	output, err := Eval(input, os.Getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != input {
+		t.Errorf("Want %q unchanged, got %q", input, output)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		Eval(input, os.Getenv)
+	})
+	if allocs != 0 {
+		t.Errorf("Want no allocations, got %v", allocs)
+	}
+}
+
+var benchmarkLiteral = strings.Repeat("static text with {braces} and \\ backslashes\n", 10000)
+
+func BenchmarkEvalLiteral(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Eval(benchmarkLiteral, os.Getenv)
+	}
+}
+
+func BenchmarkExecuteLiteral(b *testing.B) {
+	// the full parse and execution path, for comparison with Eval
+	for i := 0; i < b.N; i++ {
+		t, _ := Parse(benchmarkLiteral)
+		t.Execute(os.Getenv)
+	}
+}
+
+// benchmarkLarge expands to about 5 MB, twice the length of the input.
+var benchmarkLarge = strings.Repeat("key=${VALUE} ${OTHER:-default}\n", 80000)
+
+func benchmarkLargeMapping(name string) string {
+	if name == "VALUE" {
+		return "a value of some length"
+	}
+	return ""
+}
+
+func BenchmarkExecuteLarge(b *testing.B) {
+	t, _ := Parse(benchmarkLarge)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.Execute(benchmarkLargeMapping)
+	}
+}
+
+func BenchmarkExecuteLargeOutputSize(b *testing.B) {
+	t, _ := ParseWithOptions(benchmarkLarge, &Options{OutputSize: 5 << 20})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.Execute(benchmarkLargeMapping)
+	}
+}
+
+func TestOutputSize(t *testing.T) {
+	for _, opts := range []*Options{nil, {OutputSize: 1}, {OutputSize: 1 << 20}} {
+		out, err := EvalWithOptions("${A} and ${B:-b}", func(string) string { return "a" }, opts)
+		if err != nil || out != "a and a" {
+			t.Errorf("Want %q, got %q, %v", "a and a", out, err)
+		}
+	}
+}
+
+func TestPostProcess(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"NAME": "app"}[s]
+	}
+	calls := 0
+	opts := &Options{
+		PostProcess: func(output string) (string, error) {
+			calls++
+			if strings.Contains(output, "$") {
+				return "", errors.New("unexpanded dollar sign")
+			}
+			return strings.ToUpper(output), nil
+		},
+		NormalizeEOL: EOLCRLF,
+	}
+
+	output, err := EvalWithOptions("name: ${NAME}\nport: ${PORT:-80}\n", mapping, opts)
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if want := "NAME: APP\r\nPORT: 80\r\n"; output != want {
+		t.Errorf("Want %q, got %q", want, output)
+	}
+	if calls != 1 {
+		t.Errorf("Want the output processed once, got %d calls", calls)
+	}
+
+	output, err = EvalWithOptions("price: $${NAME}", mapping, opts)
+	if err == nil || err.Error() != "unexpanded dollar sign" {
+		t.Errorf("Want the error of the post-processor, got %v", err)
+	}
+	if want := "price: $app"; output != want {
+		t.Errorf("Want the unprocessed output %q, got %q", want, output)
+	}
+
+	// the output of a failed evaluation is not processed
+	calls = 0
+	_, err = EvalWithOptions("${PORT:?required}", mapping, opts)
+	if err == nil || calls != 0 {
+		t.Errorf("Want a failure without processing, got %v after %d calls", err, calls)
+	}
+}
+
+func TestEvalEnvSnapshot(t *testing.T) {
+	t.Setenv("SNAPSHOT_A", "before")
+	t.Setenv("SNAPSHOT_B", "before")
+
+	// change the environment part way through evaluation
+	unregisterFunc(t, "snapshotsetenv")
+	if err := RegisterFunc("snapshotsetenv", func(value string, args ...string) (string, error) {
+		t.Setenv("SNAPSHOT_B", "after")
+		return value, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	input := "${SNAPSHOT_A|snapshotsetenv} ${SNAPSHOT_B}"
+	output, err := EvalEnvSnapshot(input)
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if want := "before before"; output != want {
+		t.Errorf("Want %q expanded to %q from the snapshot, got %q", input, want, output)
+	}
+
+	t.Setenv("SNAPSHOT_B", "before")
+	output, err = EvalEnv(input)
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if want := "before after"; output != want {
+		t.Errorf("Want %q expanded to %q from the live environment, got %q", input, want, output)
+	}
+
+	output, err = EvalEnvSnapshot("${!SNAPSHOT_*}")
+	if err != nil {
+		t.Fatalf("Want names expanded but got error %v", err)
+	}
+	if want := "SNAPSHOT_A SNAPSHOT_B"; output != want {
+		t.Errorf("Want names listed from the snapshot as %q, got %q", want, output)
+	}
+}
+
+func TestEscapeLiteral(t *testing.T) {
+	mapping := func(s string) string {
+		return "<" + s + ">"
+	}
+	corpus := []string{
+		"", "text", "$", "$$", "$$$", "a$", "$ a", "$A", "${A}", "$$A", "$${A}",
+		"${A:-default}", "$(cmd)", `\`, `\\`, `\$`, `\\$A`, `\${A}`, `a\b\`,
+		`C:\Users\$USER\`, "${if:A}yes${endif}", "100% ${", "日本$語",
+		"@{A} @A @@",
+	}
+
+	for _, opts := range []*Options{
+		{BackslashEscape: true},
+		{BackslashEscape: true, DisableDollarEscape: true},
+		{BackslashEscape: true, Conditionals: true},
+		{BackslashEscape: true, StrictDollar: true},
+		{BackslashEscape: true, Sigil: '@'},
+		{BackslashEscape: true, AllowCommandSubstitution: true, AllowedCommands: []string{"false"}},
+	} {
+		for _, input := range corpus {
+			tmpl, err := EscapeLiteral(input, opts)
+			if err != nil {
+				t.Errorf("Want %q escaped with %+v but got error %v", input, opts, err)
+				continue
+			}
+			output, err := EvalWithOptions(tmpl, mapping, opts)
+			if err != nil {
+				t.Errorf("Want %q escaped as %q to evaluate but got error %v", input, tmpl, err)
+				continue
+			}
+			if output != input {
+				t.Errorf("Want %q escaped as %q to evaluate to itself, got %q", input, tmpl, output)
+			}
+		}
+	}
+
+	// escaped text can be combined with expansions
+	opts := &Options{BackslashEscape: true}
+	escaped, err := EscapeLiteral(`costs \$5 $`, opts)
+	if err != nil {
+		t.Fatalf("Want text escaped but got error %v", err)
+	}
+	input := "${A} " + escaped + "${B}"
+	output, err := EvalWithOptions(input, mapping, opts)
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if want := `<A> costs \$5 $<B>`; output != want {
+		t.Errorf("Want %q expanded to %q, got %q", input, want, output)
+	}
+
+	// without backslash escapes only text that starts no expansion is kept
+	for _, opts := range []*Options{nil, {DisableDollarEscape: true}} {
+		for _, input := range []string{"plain text", "$", "a$ b", `C:\$`, "$$"} {
+			got, err := EscapeLiteral(input, opts)
+			if err != nil || got != input {
+				t.Errorf("Want %q unchanged, got %q and error %v", input, got, err)
+			}
+		}
+		for _, input := range []string{"$A", "${A}", "$(cmd)"} {
+			if _, err := EscapeLiteral(input, opts); err == nil {
+				t.Errorf("Want %q to fail to escape without BackslashEscape", input)
+			}
+		}
+	}
+}
+
+func TestEvalBackslashEscape(t *testing.T) {
+	mapping := func(s string) string {
+		return "<" + s + ">"
+	}
+	for _, tc := range []struct {
+		input, output, escaped string
+	}{
+		{`\${X}`, `\<X>`, `${X}`},
+		{`a\$b`, `a\<b>`, `a$b`},
+		{`C:\\$DIR\\x`, `C:\\<DIR>\\x`, `C:\<DIR>\\x`},
+		{`\\\$X \$`, `\\\<X> \$`, `\$X $`},
+		{`${X:-\$Y}`, `<X>`, `<X>`},
+		{`${U:-\$Y}`, `\<Y>`, `$Y`},
+	} {
+		output, err := EvalWithOptions(tc.input, func(s string) string {
+			if s == "U" {
+				return ""
+			}
+			return mapping(s)
+		}, nil)
+		if err != nil || output != tc.output {
+			t.Errorf("Want %q expanded to %q, got %q and error %v", tc.input, tc.output, output, err)
+		}
+		output, err = EvalWithOptions(tc.input, func(s string) string {
+			if s == "U" {
+				return ""
+			}
+			return mapping(s)
+		}, &Options{BackslashEscape: true})
+		if err != nil || output != tc.escaped {
+			t.Errorf("Want %q expanded to %q with BackslashEscape, got %q and error %v", tc.input, tc.escaped, output, err)
+		}
+	}
+}
+
+func TestEvalPartialResult(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"NAME": "app"}[s]
+	}
+
+	input := "name: ${NAME}\nport: ${PORT:?is required}\nhost: ${HOST}"
+	output, err := Eval(input, mapping)
+	if err == nil {
+		t.Fatalf("Want %q to fail", input)
+	}
+	if want := "name: app\nport: "; output != want {
+		t.Errorf("Want the output rendered before the failure, %q, got %q", want, output)
+	}
+
+	// the whole default fails, so none of it is written
+	input = "a ${X:-b ${Y:?missing}} c"
+	output, _ = Eval(input, mapping)
+	if want := "a "; output != want {
+		t.Errorf("Want %q to give %q before the failure, got %q", input, want, output)
+	}
+
+	// parse errors still return the input
+	input = "${NAME} ${"
+	output, _ = Eval(input, mapping)
+	if output != input {
+		t.Errorf("Want the input %q returned for a parse error, got %q", input, output)
+	}
+}
+
+func TestEvalTo(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"NAME": "app", "PORT": "8080"}[s]
+	}
+
+	for _, input := range []string{
+		"",
+		"no expansions",
+		"name: ${NAME}\nport: $PORT",
+		"${UNSET:-${NAME^^}} $$NAME \\${NAME} $(cmd)",
+		"name: ${NAME}\nport: ${HOST:?is required} ${NAME}",
+		"name: ${NAME",
+	} {
+		want, wantErr := Eval(input, mapping)
+
+		var b strings.Builder
+		err := EvalTo(&b, input, mapping)
+		if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Errorf("Want %q to fail with %v, got %v", input, wantErr, err)
+		}
+		var syntaxErr *parse.SyntaxError
+		if errors.As(wantErr, &syntaxErr) {
+			// nothing is written if the input cannot be parsed
+			want = ""
+		}
+		if b.String() != want {
+			t.Errorf("Want %q written as %q, got %q", input, want, b.String())
+		}
+	}
+}
+
+func TestEvalSafe(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"NAME": "app"}[s]
+	}
+
+	for _, input := range []string{
+		"name: ${NAME}\nport: ${PORT:?is required}",
+		"name: ${NAME}\nport: ${PORT",
+		"name: ${NAME}\nport: ${PORT|nosuchfunc}",
+	} {
+		output, err := EvalSafe(input, mapping)
+		if err == nil {
+			t.Errorf("Want %q to fail", input)
+		}
+		if output != input {
+			t.Errorf("Want the input %q returned unchanged on error, got %q", input, output)
+		}
+	}
+
+	input := "name: ${NAME}"
+	output, err := EvalSafe(input, mapping)
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if want := "name: app"; output != want {
+		t.Errorf("Want %q expanded to %q, got %q", input, want, output)
+	}
+}
+
+func TestEvalComments(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"NAME": "app", "x": "abc"}[s]
+	}
+	opts := &Options{Comments: true}
+
+	input := "${//! the application name}name: ${NAME} ${#x} ${x//b/-} ${UNSET:-${//! fallback}def}"
+	output, err := EvalWithOptions(input, mapping, opts)
+	if err != nil {
+		t.Fatalf("Want %q expanded but got error %v", input, err)
+	}
+	if want := "name: app 3 a-c def"; output != want {
+		t.Errorf("Want %q expanded to %q, got %q", input, want, output)
+	}
+
+	if _, err := EvalWithOptions(input, mapping, nil); err == nil {
+		t.Errorf("Want %q to fail to parse without comments", input)
+	}
+}
@@ -0,0 +1,102 @@
+package envsubst
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// CommentStyle is the syntax of the comments Explain annotates the output
+// with.
+type CommentStyle struct {
+	Open  string // starts a comment, e.g. /*
+	Close string // ends a comment, e.g. */
+}
+
+var (
+	// BlockComment annotates in the style of C, /* ... */.
+	BlockComment = CommentStyle{Open: "/*", Close: "*/"}
+
+	// XMLComment annotates in the style of XML and HTML, <!-- ... -->.
+	XMLComment = CommentStyle{Open: "<!--", Close: "-->"}
+)
+
+// Explain evaluates the string like Eval, but follows the result of each
+// substitution with a comment naming the substitution and how it was
+// resolved, e.g. value/*${VAR:-default} → used default*/. It is meant for
+// teaching and debugging templates.
+func Explain(s string, mapping Mapping) (string, error) {
+	return ExplainWithStyle(s, mapping, BlockComment)
+}
+
+// ExplainWithStyle is like Explain but writes the annotations as comments
+// of the given style, to suit the type of file being templated.
+func ExplainWithStyle(s string, mapping Mapping, style CommentStyle) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteExplain(mapping, style)
+}
+
+// ExecuteExplain applies a parsed template to the specified data mapping,
+// annotating the result of each substitution as Explain does.
+func (t *Template) ExecuteExplain(mapping Mapping, style CommentStyle) (string, error) {
+	var current *parse.FuncNode
+	var set bool
+	resolve := t.sentinels(t.positional(t.transformNames(simpleResolver(mapping))))
+
+	b := new(bytes.Buffer)
+	s := new(state)
+	s.writer = b
+	s.mapper = func(name string, ctx ResolveContext) (string, bool, error) {
+		v, ok, err := resolve(name, ctx)
+		if ctx.node == current {
+			set = ok
+		}
+		return v, ok, err
+	}
+
+	for _, node := range flatten(t.tree.Root) {
+		s.node = node
+		fn, ok := node.(*parse.FuncNode)
+		if ok {
+			current, set = fn, false
+		}
+		if err := t.eval(s); err != nil {
+			return "", t.redact(s, err)
+		}
+		if !ok {
+			continue
+		}
+
+		note := parse.FormatNode(fn) + " → " + explanation(fn, set)
+		if _, err := io.WriteString(b, style.Open+note+style.Close); err != nil {
+			return "", err
+		}
+	}
+	if len(s.unset) != 0 {
+		return b.String(), UnsetErrors(s.unset)
+	}
+	return b.String(), nil
+}
+
+// explanation describes how a substitution was resolved, given whether its
+// variable was set.
+func explanation(node *parse.FuncNode, set bool) string {
+	switch node.Name {
+	case "+", ":+":
+		if set {
+			return "used alternate"
+		}
+		return "unset"
+	}
+	switch {
+	case set:
+		return "set"
+	case isDefaultFunc(node.Name):
+		return "used default"
+	}
+	return "unset"
+}
@@ -0,0 +1,64 @@
+package envsubst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"HOST": "example.com", "PORT": "8080"}[s]
+	}
+
+	for input, want := range map[string]string{
+		"http://${HOST}":     "http://example.com/*${HOST} → set*/",
+		"$PORT":              "8080/*$PORT → set*/",
+		"[${USER}]":          "[/*${USER} → unset*/]",
+		"${USER:-root}":      "root/*${USER:-root} → used default*/",
+		"${PORT:-80}":        "8080/*${PORT:-80} → set*/",
+		"${PORT:+-p $PORT}":  "-p 8080/*${PORT:+-p $PORT} → used alternate*/",
+		"${USER:+-u $USER}":  "/*${USER:+-u $USER} → unset*/",
+		"${HOST^^} $(cmd) x": "EXAMPLE.COM/*${HOST^^} → set*/ $(cmd) x",
+	} {
+		got, err := Explain(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	got, err := ExplainWithStyle("<b>${USER:-${HOST}}</b>", m, XMLComment)
+	assert.Nil(t, err)
+	assert.Equal(t, "<b>example.com<!--${USER:-${HOST}} → used default--></b>", got)
+
+	_, err = Explain("${USER:?required}", m)
+	assert.NotNil(t, err)
+}
+
+func TestExecuteExplainOptions(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"MYVAR": "x", "N": "null"}[s]
+	}
+	opts := &Options{
+		NameTransform:  strings.ToUpper,
+		PositionalArgs: []string{"p"},
+		UnsetSentinels: []string{"null"},
+		Strict:         true,
+		ReportAllUnset: true,
+	}
+
+	// the mapping is wrapped, and unset variables reported, as by Execute
+	tmpl, err := ParseWithOptions("${myVar} ${N:-d} ${1} ${U}", opts)
+	assert.Nil(t, err)
+	got, err := tmpl.ExecuteExplain(m, BlockComment)
+	assert.Equal(t, "U: unbound variable", err.Error())
+	assert.Equal(t, "x/*${myVar} → set*/ d/*${N:-d} → used default*/ p/*${1} → set*/ /*${U} → unset*/", got)
+
+	// errors are redacted
+	tmpl, err = ParseWithOptions("${MYVAR} ${U:?$MYVAR}", &Options{
+		Redactor: func(string, string) string { return "***" },
+	})
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteExplain(m, BlockComment)
+	assert.Equal(t, "U: ***", err.Error())
+}
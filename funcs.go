@@ -1,11 +1,13 @@
 package envsubst
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/logandavies181/envsubst/parse"
 	"github.com/logandavies181/envsubst/path"
 )
 
@@ -59,24 +61,36 @@ func toDefault(s string, args ...string) string {
 	return s
 }
 
+// toAlternate returns a concatenation of the args without a
+// separator if the string s is not empty, else returns s.
+func toAlternate(s string, args ...string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.Join(args, "")
+}
+
 // toSubstr returns a slice of the string s at the specified
-// length and position.
+// length and position, both counted in characters. A negative
+// position counts back from the end of the string, and a negative
+// length gives the number of characters to leave off the end.
 func toSubstr(s string, args ...string) string {
 	if len(args) == 0 {
 		return s // should never happen
 	}
 
-	pos, err := strconv.Atoi(args[0])
+	pos, err := strconv.Atoi(strings.TrimSpace(args[0]))
 	if err != nil {
 		// bash returns the string if the position
 		// cannot be parsed.
 		return s
 	}
 
+	r := []rune(s)
 	if pos < 0 {
 		// if pos is negative (counts from the end) add it
 		// to length to get first character offset
-		pos = len(s) + pos
+		pos = len(r) + pos
 
 		// if negative offset exceeds the length of the string
 		// start from 0
@@ -84,88 +98,166 @@ func toSubstr(s string, args ...string) string {
 			pos = 0
 		}
 	}
-
-	if len(args) == 1 {
-		if pos < len(s) {
-			return s[pos:]
-		}
+	if pos >= len(r) {
 		// if the position exceeds the length of the
 		// string an empty string is returned
 		return ""
 	}
 
-	length, err := strconv.Atoi(args[1])
+	if len(args) == 1 {
+		return string(r[pos:])
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(args[1]))
 	if err != nil {
 		// bash returns the string if the length
 		// cannot be parsed.
 		return s
 	}
 
-	if pos+length >= len(s) {
-		if pos < len(s) {
-			// if the position exceeds the length of the
-			// string just return the rest of it like bash
-			return s[pos:]
-		}
-		// if the position exceeds the length of the
-		// string an empty string is returned
+	end := pos + length
+	if length < 0 {
+		// a negative length counts back from the end
+		// of the string
+		end = len(r) + length
+	}
+	switch {
+	case end <= pos:
+		// an explicit zero length is empty, unlike an
+		// omitted length which runs to the end
 		return ""
+	case end > len(r):
+		// if the length exceeds the rest of the string
+		// just return the rest of it like bash
+		end = len(r)
 	}
+	return string(r[pos:end])
+}
+
+// ErrSubstringNegative is returned when a negative substring offset counts
+// back past the start of the string, or a negative length counts back past
+// the offset.
+var ErrSubstringNegative = messageError(func(m *Messages) string { return m.SubstringNegative })
 
-	return s[pos : pos+length]
+// checkSubstr returns ErrSubstringNegative if the substring offset is
+// negative and its absolute value exceeds the length, in characters, of the
+// string s, or if a negative length ends the substring before its offset.
+func checkSubstr(s string, args ...string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	pos, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil {
+		return nil
+	}
+	n := utf8.RuneCountInString(s)
+	if pos < 0 && -pos > n {
+		return ErrSubstringNegative
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || length >= 0 {
+		return nil
+	}
+	if pos < 0 {
+		pos += n
+	}
+	if pos < n && n+length < pos {
+		return ErrSubstringNegative
+	}
+	return nil
 }
 
-// replaceAll returns a copy of the string s with all instances
-// of the substring replaced with the replacement string.
+// replaceAll returns a copy of the string s with all matches of the
+// pattern replaced with the replacement string.
 func replaceAll(s string, args ...string) string {
-	switch len(args) {
-	case 0:
+	if len(args) == 0 {
 		return s
-	case 1:
-		return strings.Replace(s, args[0], "", -1)
-	default:
-		return strings.Replace(s, args[0], args[1], -1)
 	}
+	return replaceMatches(s, parse.MatchPositions(s, args[0]), replacement(args))
 }
 
-// replaceFirst returns a copy of the string s with the first
-// instance of the substring replaced with the replacement string.
+// replaceFirst returns a copy of the string s with the first match
+// of the pattern replaced with the replacement string.
 func replaceFirst(s string, args ...string) string {
-	switch len(args) {
-	case 0:
+	if len(args) == 0 {
 		return s
-	case 1:
-		return strings.Replace(s, args[0], "", 1)
-	default:
-		return strings.Replace(s, args[0], args[1], 1)
 	}
+	p := parse.MatchPositions(s, args[0])
+	if len(p) > 2 {
+		p = p[:2]
+	}
+	return replaceMatches(s, p, replacement(args))
 }
 
-// replacePrefix returns a copy of the string s with the matching
-// prefix replaced with the replacement string.
+// replacePrefix returns a copy of the string s with the longest prefix
+// matching the pattern replaced with the replacement string. An empty
+// pattern matches the empty prefix, so ${var/#/prefix} prepends.
 func replacePrefix(s string, args ...string) string {
-	if len(args) != 2 {
+	if len(args) == 0 {
 		return s
 	}
-	if strings.HasPrefix(s, args[0]) {
-		return strings.Replace(s, args[0], args[1], 1)
+	for end := len(s); end >= 0; end-- {
+		if end < len(s) && !utf8.RuneStart(s[end]) {
+			continue
+		}
+		if ok, err := path.Match(args[0], s[:end]); err != nil {
+			return s
+		} else if ok {
+			return replacement(args) + s[end:]
+		}
 	}
 	return s
 }
 
-// replaceSuffix returns a copy of the string s with the matching
-// suffix replaced with the replacement string.
+// replaceSuffix returns a copy of the string s with the longest suffix
+// matching the pattern replaced with the replacement string. An empty
+// pattern matches the empty suffix, so ${var/%/suffix} appends.
 func replaceSuffix(s string, args ...string) string {
-	if len(args) != 2 {
+	if len(args) == 0 {
 		return s
 	}
-	if strings.HasSuffix(s, args[0]) {
-		s = strings.TrimSuffix(s, args[0])
-		s = s + args[1]
+	for start := 0; start <= len(s); start++ {
+		if start < len(s) && !utf8.RuneStart(s[start]) {
+			continue
+		}
+		if ok, err := path.Match(args[0], s[start:]); err != nil {
+			return s
+		} else if ok {
+			return s[:start] + replacement(args)
+		}
 	}
 	return s
 }
 
+// replacement returns the replacement string of a replace operator, which
+// is empty if it is omitted, as in ${var/pattern}.
+func replacement(args []string) string {
+	if len(args) < 2 {
+		return ""
+	}
+	return args[1]
+}
+
+// replaceMatches replaces the matches at the positions returned by
+// parse.MatchPositions with the replacement string.
+func replaceMatches(s string, positions []int, repl string) string {
+	if len(positions) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(positions); i += 2 {
+		b.WriteString(s[last:positions[i]])
+		b.WriteString(repl)
+		last = positions[i+1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
 // TODO
 
 func trimShortestPrefix(s string, args ...string) string {
@@ -244,3 +336,62 @@ func reverse(s string) string {
 	}
 	return string(r)
 }
+
+// toQuoted returns the string s quoted for reuse as shell input, as bash
+// expands ${var@Q}. Strings containing control characters or invalid
+// UTF-8 are quoted in the ANSI-C form, $'...', with escapes such as \n,
+// \t and \xHH, and other strings in single quotes.
+func toQuoted(s string, args ...string) string {
+	if !needsANSIQuote(s) {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\a':
+			b.WriteString(`\a`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\v':
+			b.WriteString(`\v`)
+		case r == 0x1b:
+			b.WriteString(`\E`)
+		case r == utf8.RuneError && w == 1, unicode.IsControl(r):
+			for _, c := range []byte(s[i : i+w]) {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			}
+		default:
+			b.WriteRune(r)
+		}
+		i += w
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// needsANSIQuote reports whether s contains characters that single quotes
+// cannot represent readably, i.e. control characters or invalid UTF-8.
+func needsANSIQuote(s string) bool {
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && w == 1 || unicode.IsControl(r) {
+			return true
+		}
+		i += w
+	}
+	return false
+}
@@ -66,6 +66,18 @@ func Test_default(t *testing.T) {
 	}
 }
 
+func Test_alternate(t *testing.T) {
+	got, want := toAlternate("Hello World", "Hola Mundo"), "Hola Mundo"
+	if got != want {
+		t.Errorf("Expect alternate function uses alternate value when variable not empty. Got %s, Want %s", got, want)
+	}
+
+	got, want = toAlternate("", "Hola Mundo"), ""
+	if got != want {
+		t.Errorf("Expect alternate function uses empty value when variable empty. Got %s, Want %s", got, want)
+	}
+}
+
 func Test_substr(t *testing.T) {
 	got, want := toSubstr("123456789123456789", "0", "8"), "12345678"
 	if got != want {
@@ -101,4 +113,65 @@ func Test_substr(t *testing.T) {
 	if got != want {
 		t.Errorf("Expect substr function to cut entire string if pos is itself out of bound")
 	}
+
+	got, want = toSubstr("12345678", "2", "0"), ""
+	if got != want {
+		t.Errorf("Expect substr function to return an empty string for a zero length")
+	}
+
+	got, want = toSubstr("12345678", "8", "0"), ""
+	if got != want {
+		t.Errorf("Expect substr function to return an empty string for a zero length at the end")
+	}
+
+	got, want = toSubstr("12345678", "2", "-2"), "3456"
+	if got != want {
+		t.Errorf("Expect substr function to leave off the end for a negative length")
+	}
+
+	got, want = toSubstr("12345678", "6", "-4"), ""
+	if got != want {
+		t.Errorf("Expect substr function to return an empty string when a negative length ends before the offset")
+	}
+
+	got, want = toSubstr("héllo wörld", "1", "4"), "éllo"
+	if got != want {
+		t.Errorf("Expect substr function to count characters rather than bytes. Got %s, Want %s", got, want)
+	}
+
+	got, want = toSubstr("🍎🍌🍒🍇", "-2", "1"), "🍒"
+	if got != want {
+		t.Errorf("Expect substr function to count negative offsets in characters. Got %s, Want %s", got, want)
+	}
+
+	got, want = toSubstr("🍎🍌🍒🍇", "-3", "-1"), "🍌🍒"
+	if got != want {
+		t.Errorf("Expect substr function to count negative lengths in characters. Got %s, Want %s", got, want)
+	}
+
+	got, want = toSubstr("日本語", "-5", "2"), "日本"
+	if got != want {
+		t.Errorf("Expect substr function to clamp negative offsets in characters. Got %s, Want %s", got, want)
+	}
+
+	got, want = toSubstr("日本語", "1", "10"), "本語"
+	if got != want {
+		t.Errorf("Expect substr function to clamp lengths in characters. Got %s, Want %s", got, want)
+	}
+}
+
+func Test_quoted(t *testing.T) {
+	for s, want := range map[string]string{
+		"plain value":     `'plain value'`,
+		"it's":            `'it'\''s'`,
+		"":                `''`,
+		"line\nnext\tcol": `$'line\nnext\tcol'`,
+		"it's\n":          `$'it\'s\n'`,
+		"\x01\x7f\xff é":  `$'\x01\x7f\xff é'`,
+		"back\\slash\r":   `$'back\\slash\r'`,
+	} {
+		if got := toQuoted(s); got != want {
+			t.Errorf("Expect quoted %q to be %s, got %s", s, want, got)
+		}
+	}
 }
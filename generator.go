@@ -0,0 +1,82 @@
+package envsubst
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// Generator produces a value, such as a random identifier, for a default
+// written as @name, e.g. ${ID:-@uuid}.
+type Generator func() (string, error)
+
+var (
+	generatorMu sync.RWMutex
+	generators  = map[string]Generator{
+		"now":  now,
+		"uuid": uuid,
+	}
+)
+
+// RegisterGenerator makes the generator fn available to templates as the
+// default ${var:-@name}, and likewise with the -, = and := operators. The
+// default must be @name alone. The generator only runs when the default is
+// used, so ${ID:-@uuid} keeps ID if it is set. A generator cannot be
+// redefined.
+func RegisterGenerator(name string, fn Generator) error {
+	if !funcPattern.MatchString(name) {
+		return fmt.Errorf("invalid generator name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("generator %q is nil", name)
+	}
+
+	generatorMu.Lock()
+	defer generatorMu.Unlock()
+	if _, ok := generators[name]; ok {
+		return fmt.Errorf("generator %q is already registered", name)
+	}
+	generators[name] = fn
+	return nil
+}
+
+// generatorArg returns the generator named by the default of node, if its
+// default is @name.
+func generatorArg(node *parse.FuncNode) (Generator, bool) {
+	switch node.Name {
+	case "-", "=", ":-", ":=":
+	default:
+		return nil, false
+	}
+	if len(node.Args) != 1 {
+		return nil, false
+	}
+	text, ok := node.Args[0].(*parse.TextNode)
+	if !ok || len(text.Value) < 2 || text.Value[0] != '@' {
+		return nil, false
+	}
+
+	generatorMu.RLock()
+	defer generatorMu.RUnlock()
+	fn, ok := generators[text.Value[1:]]
+	return fn, ok
+}
+
+// now generates the current time in RFC 3339 format.
+func now() (string, error) {
+	return time.Now().Format(time.RFC3339), nil
+}
+
+// uuid generates a random version 4 UUID.
+func uuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,56 @@
+package envsubst
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unregisterGenerator removes a generator registered by a test, so that
+// the test can run again.
+func unregisterGenerator(t *testing.T, name string) {
+	t.Cleanup(func() {
+		generatorMu.Lock()
+		defer generatorMu.Unlock()
+		delete(generators, name)
+	})
+}
+
+func TestGenerators(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"ID": "fixed"}[name]
+	}
+
+	out, err := Eval("${NEW_ID:-@uuid}", mapping)
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), out)
+
+	out, err = Eval("${TS:=@now}", mapping)
+	assert.Nil(t, err)
+	_, err = time.Parse(time.RFC3339, out)
+	assert.Nil(t, err, out)
+
+	// generators only run when the default is used
+	calls := 0
+	unregisterGenerator(t, "testcounter")
+	assert.Nil(t, RegisterGenerator("testcounter", func() (string, error) {
+		calls++
+		return "generated", nil
+	}))
+	out, err = Eval("${ID:-@testcounter} ${ID-@testcounter} ${UNSET-@testcounter}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "fixed fixed generated", out)
+	assert.Equal(t, 1, calls)
+
+	// only @name alone in a default is a generator
+	out, err = Eval("${UNSET:-@testcounter!} ${UNSET:-x@uuid} ${UNSET:-@unknown} ${ID:+@uuid}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "@testcounter! x@uuid @unknown @uuid", out)
+	assert.Equal(t, 1, calls)
+
+	assert.NotNil(t, RegisterGenerator("uuid", uuid))
+	assert.NotNil(t, RegisterGenerator("bad-name", uuid))
+	assert.NotNil(t, RegisterGenerator("nilgen", nil))
+}
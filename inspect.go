@@ -0,0 +1,117 @@
+package envsubst
+
+import (
+	"sort"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// CountExpansions returns the number of variable expansions in the
+// string, including those nested within the arguments of other
+// expansions, without evaluating it. Expansions within command
+// substitutions are not counted, as they are never evaluated.
+func CountExpansions(s string) (int, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	walkFuncs(t.tree.Root, func(*parse.FuncNode) {
+		n++
+	})
+	return n, nil
+}
+
+// OperatorsUsed returns the distinct operators in the string, such as :-,
+// # or //, sorted in byte order. Operators nested within the arguments of
+// other expansions are included, and aliases are reported as the operator
+// they stand for. Registered functions are reported as |name, and the
+// length ${#var} is reported as #len, so that it is distinct from the
+// prefix removal ${var#pattern}. Plain references such as ${var} use no
+// operator.
+func OperatorsUsed(s string) ([]string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := operatorsIn(t.tree.Root)
+	sort.Strings(ops)
+	return ops, nil
+}
+
+// Unsupported returns the distinct operators in the string, as reported by
+// OperatorsUsed, that are not in supported, in the order in which they
+// first appear. It checks that a template can be handed to a tool that
+// implements fewer operators, e.g. with no supported operators, as for
+// GNU envsubst, every operator is returned.
+func Unsupported(s string, supported []string) ([]string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := make(map[string]bool, len(supported))
+	for _, op := range supported {
+		ok[op] = true
+	}
+	var ops []string
+	for _, op := range operatorsIn(t.tree.Root) {
+		if !ok[op] {
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// operatorsIn returns the distinct operators in the tree in input order.
+func operatorsIn(root parse.Node) []string {
+	seen := make(map[string]bool)
+	var ops []string
+	walkFuncs(root, func(node *parse.FuncNode) {
+		op := operatorName(node)
+		if op != "" && !seen[op] {
+			seen[op] = true
+			ops = append(ops, op)
+		}
+	})
+	return ops
+}
+
+// lengthOperator is the operator reported for the length ${#var}.
+const lengthOperator = "#len"
+
+// operatorName returns the operator of node as reported by OperatorsUsed.
+func operatorName(node *parse.FuncNode) string {
+	if isLength(node) {
+		return lengthOperator
+	}
+	return node.Name
+}
+
+// isLength reports whether node is the length of a variable, ${#var}, or
+// of a nested expansion, ${#${...}}.
+func isLength(node *parse.FuncNode) bool {
+	return node.Name == "#" && (len(node.Args) == 0 || node.Param == "")
+}
+
+// walkFuncs calls fn for every substitution in the tree, in input order,
+// visiting each substitution before the substitutions in its arguments.
+func walkFuncs(node parse.Node, fn func(*parse.FuncNode)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, item := range n.Nodes {
+			walkFuncs(item, fn)
+		}
+	case *parse.FuncNode:
+		fn(n)
+		for _, arg := range n.Args {
+			walkFuncs(arg, fn)
+		}
+	case *parse.IfNode:
+		fn(n.Cond)
+		walkFuncs(n.Then, fn)
+		walkFuncs(n.Else, fn)
+	}
+}
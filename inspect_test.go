@@ -0,0 +1,61 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountExpansions(t *testing.T) {
+	for input, want := range map[string]int{
+		"text only":                        0,
+		"$$ $(cmd ${ignored})":             0,
+		"${a} $b ${c^^}":                   3,
+		"${a:-${b:-${c}}}":                 3,
+		"${a/${b}/${c}} ${d|eq:x:$e-$f:y}": 6,
+		"${a:${b}:${c}}":                   3,
+	} {
+		got, err := CountExpansions(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err := CountExpansions("${a")
+	assert.NotNil(t, err)
+}
+
+func TestOperatorsUsed(t *testing.T) {
+	got, err := OperatorsUsed("${a:-${b//x/${c^^}}} ${d#p} ${#e} $f ${g} ${h:-z} ${i|eq:y:${j%%s}:n}")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#", "#len", "%%", "//", ":-", "^^", "|eq"}, got)
+
+	got, err = OperatorsUsed("${#a} ${#${b}}")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#len"}, got)
+
+	got, err = OperatorsUsed("${a} $b $$ $(cmd ${c:-d})")
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	_, err = OperatorsUsed("${a")
+	assert.NotNil(t, err)
+}
+
+func TestUnsupported(t *testing.T) {
+	input := "${a:-${b//x/${c^^}}} ${d#p} ${#e} $f ${g} ${h:-z} ${i|eq:y:${j%%s}:n}"
+
+	got, err := Unsupported(input, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{":-", "//", "^^", "#", "#len", "|eq", "%%"}, got)
+
+	got, err = Unsupported(input, []string{":-", "#", "%%", "|eq"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"//", "^^", "#len"}, got)
+
+	got, err = Unsupported("${a} $b $$", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	_, err = Unsupported("${a", nil)
+	assert.NotNil(t, err)
+}
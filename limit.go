@@ -0,0 +1,37 @@
+package envsubst
+
+import (
+	"fmt"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// TooManyVariablesError is returned when a template references more
+// distinct variables than Options.MaxVariables allows.
+type TooManyVariablesError struct {
+	Count int
+	Limit int
+}
+
+func (e *TooManyVariablesError) Error() string {
+	return fmt.Sprintf(currentMessages().TooManyVariables, e.Count, e.Limit)
+}
+
+// checkVariables enforces the MaxVariables option on the parsed template.
+func (t *Template) checkVariables() error {
+	limit := t.opts.MaxVariables
+	if limit <= 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	walkFuncs(t.tree.Root, func(node *parse.FuncNode) {
+		if node.Param != "" {
+			seen[node.Param] = true
+		}
+	})
+	if len(seen) > limit {
+		return &TooManyVariablesError{Count: len(seen), Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package envsubst
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxVariables(t *testing.T) {
+	// A is referenced twice and C is nested within a default
+	input := "${A} ${B:-${C}} $A ${A,,}"
+
+	_, err := ParseWithOptions(input, &Options{MaxVariables: 3})
+	assert.Nil(t, err)
+
+	_, err = ParseWithOptions(input, &Options{MaxVariables: 2})
+	var tooMany *TooManyVariablesError
+	assert.True(t, errors.As(err, &tooMany))
+	assert.Equal(t, 3, tooMany.Count)
+	assert.Equal(t, 2, tooMany.Limit)
+	assert.Equal(t, "template references 3 distinct variables, more than the limit of 2", err.Error())
+
+	out, err := EvalWithOptions(input, func(string) string { return "x" }, &Options{MaxVariables: 2})
+	assert.Equal(t, input, out)
+	assert.NotNil(t, err)
+
+	_, err = ParseWithOptions(input, nil)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,73 @@
+package envsubst
+
+import (
+	"bytes"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// Span relates a range of the output to the node of the template that
+// produced it. All offsets are in bytes.
+type Span struct {
+	// Start and End delimit the range of the output.
+	Start, End int
+
+	// SrcStart and SrcEnd delimit the originating node in the input.
+	SrcStart, SrcEnd int
+}
+
+// EvalMapped replaces ${var} in the string based on the mapping function
+// and reports which part of the input produced each part of the output.
+// Spans are returned in output order, one for each top-level text,
+// substitution or command node. A substitution that expands to the empty
+// string has an empty output range.
+func EvalMapped(s string, mapping Mapping) (out string, spans []Span, err error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, nil, err
+	}
+	return t.ExecuteMapped(mapping)
+}
+
+// ExecuteMapped applies a parsed template to the specified data mapping and
+// reports which part of the input produced each part of the output.
+func (t *Template) ExecuteMapped(mapping Mapping) (str string, spans []Span, err error) {
+	b := new(bytes.Buffer)
+	s := new(state)
+	s.mapper = t.sentinels(t.positional(t.transformNames(simpleResolver(mapping))))
+	s.writer = b
+
+	for _, node := range flatten(t.tree.Root) {
+		start := b.Len()
+		s.node = node
+		err = t.eval(s)
+		if err != nil {
+			return "", spans, t.redact(s, err)
+		}
+		pos, end := parse.Span(node)
+		spans = append(spans, Span{
+			Start:    start,
+			End:      b.Len(),
+			SrcStart: pos,
+			SrcEnd:   end,
+		})
+	}
+	if len(s.unset) != 0 {
+		return b.String(), spans, UnsetErrors(s.unset)
+	}
+	return b.String(), spans, nil
+}
+
+// flatten returns the nodes of nested lists in order.
+func flatten(node parse.Node) []parse.Node {
+	list, ok := node.(*parse.ListNode)
+	if !ok {
+		return []parse.Node{node}
+	}
+
+	var nodes []parse.Node
+	for _, n := range list.Nodes {
+		nodes = append(nodes, flatten(n)...)
+	}
+	return nodes
+}
@@ -0,0 +1,61 @@
+package envsubst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalMapped(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"FOO": "foo", "BAR": "barbar"}[s]
+	}
+
+	input := "a=${FOO} b=$BAR c=${BAZ:-zz}"
+	out, spans, err := EvalMapped(input, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "a=foo b=barbar c=zz", out)
+
+	assert.Equal(t, []Span{
+		{Start: 0, End: 2, SrcStart: 0, SrcEnd: 2},
+		{Start: 2, End: 5, SrcStart: 2, SrcEnd: 8},
+		{Start: 5, End: 8, SrcStart: 8, SrcEnd: 11},
+		{Start: 8, End: 14, SrcStart: 11, SrcEnd: 15},
+		{Start: 14, End: 17, SrcStart: 15, SrcEnd: 18},
+		{Start: 17, End: 19, SrcStart: 18, SrcEnd: 28},
+	}, spans)
+	assert.Equal(t, "${BAZ:-zz}", input[spans[5].SrcStart:spans[5].SrcEnd])
+}
+
+func TestExecuteMappedOptions(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"MYVAR": "x", "N": "null"}[s]
+	}
+	opts := &Options{
+		NameTransform:  strings.ToUpper,
+		PositionalArgs: []string{"p"},
+		UnsetSentinels: []string{"null"},
+		Strict:         true,
+		ReportAllUnset: true,
+	}
+
+	// the mapping is wrapped, and unset variables reported, as by Execute
+	tmpl, err := ParseWithOptions("${myVar} ${N:-null} ${1} ${U}", opts)
+	assert.Nil(t, err)
+	want, wantErr := tmpl.Execute(m)
+	out, spans, err := tmpl.ExecuteMapped(m)
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, "U: unbound variable", err.Error())
+	assert.Equal(t, want, out)
+	assert.Equal(t, "x null p ", out)
+	assert.Len(t, spans, 7)
+
+	// errors are redacted
+	tmpl, err = ParseWithOptions("${MYVAR} ${U:?$MYVAR}", &Options{
+		Redactor: func(string, string) string { return "***" },
+	})
+	assert.Nil(t, err)
+	_, _, err = tmpl.ExecuteMapped(m)
+	assert.Equal(t, "U: ***", err.Error())
+}
@@ -0,0 +1,115 @@
+package envsubst
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Mapping is a function that maps a variable name to its value. An empty
+// value is treated as unset.
+type Mapping func(string) string
+
+// MappingSet is a function that maps a variable name to its value and
+// reports whether the variable is set, so that a variable set to the
+// empty string can be told apart from an unset one, e.g. os.LookupEnv.
+type MappingSet func(string) (string, bool)
+
+// ContextMapping returns a mapping that resolves variables from the
+// map[string]string stored in ctx under key, falling back to the
+// environment for variables the map does not contain. If ctx carries no
+// such map, every variable is resolved from the environment.
+func ContextMapping(ctx context.Context, key any) Mapping {
+	vars, _ := ctx.Value(key).(map[string]string)
+	return func(name string) string {
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	}
+}
+
+// EvalWithDefaults replaces ${var} in the string with values from primary,
+// falling back to defaults for variables that primary leaves empty. A
+// variable is unset only if both leave it empty, so an inline default such
+// as ${var:-x} is used only then, and otherwise the variable is replaced by
+// the empty string. Either mapping may be nil.
+func EvalWithDefaults(s string, primary, defaults Mapping) (string, error) {
+	return Eval(s, func(name string) string {
+		if primary != nil {
+			if v := primary(name); v != "" {
+				return v
+			}
+		}
+		if defaults != nil {
+			return defaults(name)
+		}
+		return ""
+	})
+}
+
+// EvalLayered replaces ${var} in the string with values from override,
+// falling back to base for variables that override leaves empty, and
+// reports where the value of each variable resolved came from: override,
+// base, or default if neither sets it, in which case an inline default
+// such as ${var:-x} is used, or the empty string. Variables in defaults
+// that are not used are never resolved and so are not reported. Either
+// mapping may be nil.
+func EvalLayered(s string, base, override Mapping) (out string, sources map[string]string, err error) {
+	sources = make(map[string]string)
+	out, err = Eval(s, func(name string) string {
+		if override != nil {
+			if v := override(name); v != "" {
+				sources[name] = "override"
+				return v
+			}
+		}
+		if base != nil {
+			if v := base(name); v != "" {
+				sources[name] = "base"
+				return v
+			}
+		}
+		sources[name] = "default"
+		return ""
+	})
+	return out, sources, err
+}
+
+// EvalMapResolveValues replaces ${var} in the string with values from vars.
+// Values that themselves contain expansions are evaluated against vars one
+// level deep, so a value may refer to other keys but the values it refers
+// to are used verbatim.
+func EvalMapResolveValues(s string, vars map[string]string) (string, error) {
+	return EvalMapResolveValuesDepth(s, vars, 1)
+}
+
+// EvalMapResolveValuesDepth is like EvalMapResolveValues but values are
+// evaluated up to depth levels deep. A depth of zero uses every value
+// verbatim. References beyond the allowed depth, including cyclic ones,
+// are left unevaluated.
+func EvalMapResolveValuesDepth(s string, vars map[string]string, depth int) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.execute(mapResolver(vars, depth))
+}
+
+// mapResolver returns a resolver for vars that evaluates values containing
+// expansions up to depth levels deep.
+func mapResolver(vars map[string]string, depth int) resolver {
+	return func(name string, _ ResolveContext) (string, bool, error) {
+		v, ok := vars[name]
+		if depth <= 0 || !strings.Contains(v, "$") {
+			return v, ok, nil
+		}
+
+		t, err := Parse(v)
+		if err != nil {
+			return "", ok, err
+		}
+		v, err = t.execute(mapResolver(vars, depth-1))
+		return v, ok, err
+	}
+}
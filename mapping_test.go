@@ -0,0 +1,129 @@
+package envsubst
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalMapResolveValues(t *testing.T) {
+	vars := map[string]string{
+		"HOST": "example.com",
+		"BASE": "https://${HOST}",
+		"URL":  "${BASE}/api",
+		"LOOP": "[${LOOP}]",
+	}
+
+	// one level: BASE is resolved, but HOST is substituted verbatim
+	out, err := EvalMapResolveValues("${BASE}", vars)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com", out)
+
+	// two levels of reference are cut off at the default depth
+	out, err = EvalMapResolveValues("${URL}", vars)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://${HOST}/api", out)
+
+	out, err = EvalMapResolveValuesDepth("${URL}", vars, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/api", out)
+
+	out, err = EvalMapResolveValuesDepth("${URL}", vars, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "${BASE}/api", out)
+
+	// cycles are cut off at the depth limit
+	out, err = EvalMapResolveValuesDepth("${LOOP}", vars, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "[[[[${LOOP}]]]]", out)
+}
+
+func TestEvalMapResolveValuesError(t *testing.T) {
+	vars := map[string]string{"BAD": "${unterminated"}
+
+	_, err := EvalMapResolveValues("value: ${BAD}", vars)
+	var mappingErr *MappingError
+	assert.ErrorAs(t, err, &mappingErr)
+	assert.Equal(t, "BAD", mappingErr.Name)
+}
+
+type overridesKey struct{}
+
+func TestContextMapping(t *testing.T) {
+	t.Setenv("REGION", "us-east-1")
+	t.Setenv("TENANT", "default")
+
+	ctx := context.WithValue(context.Background(), overridesKey{}, map[string]string{
+		"TENANT": "acme",
+	})
+	out, err := Eval("${TENANT}@${REGION}", ContextMapping(ctx, overridesKey{}))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme@us-east-1", out)
+
+	// without overrides the environment is used
+	out, err = Eval("${TENANT}@${REGION}", ContextMapping(context.Background(), overridesKey{}))
+	assert.Nil(t, err)
+	assert.Equal(t, "default@us-east-1", out)
+}
+
+func TestEvalWithDefaults(t *testing.T) {
+	primary := func(name string) string {
+		return map[string]string{"A": "primary"}[name]
+	}
+	defaults := func(name string) string {
+		return map[string]string{"A": "default", "B": "default"}[name]
+	}
+
+	out, err := EvalWithDefaults("${A:-inline}|${B:-inline}|${C:-inline}|${D}", primary, defaults)
+	assert.Nil(t, err)
+	assert.Equal(t, "primary|default|inline|", out)
+
+	// the defaults mapping is enough for the alternate to apply
+	out, err = EvalWithDefaults("${B:+alt}|${C:+alt}", primary, defaults)
+	assert.Nil(t, err)
+	assert.Equal(t, "alt|", out)
+
+	out, err = EvalWithDefaults("${A:-inline}", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "inline", out)
+
+	_, err = EvalWithDefaults("${C:?required}", primary, defaults)
+	assert.Equal(t, "C: required", err.Error())
+}
+
+func TestEvalLayered(t *testing.T) {
+	base := func(name string) string {
+		return map[string]string{"HOST": "base.example.com", "PORT": "80", "USER": "base"}[name]
+	}
+	override := func(name string) string {
+		return map[string]string{"PORT": "8080", "USER": ""}[name]
+	}
+
+	out, sources, err := EvalLayered("${USER}@${HOST}:${PORT}/${DB:-app} ${TLS} ${HOST:-${UNUSED}}", base, override)
+	assert.Nil(t, err)
+	assert.Equal(t, "base@base.example.com:8080/app  base.example.com", out)
+	assert.Equal(t, map[string]string{
+		"USER": "base",
+		"HOST": "base",
+		"PORT": "override",
+		"DB":   "default",
+		"TLS":  "default",
+	}, sources)
+
+	// the sources found before a failure are returned with the error
+	_, sources, err = EvalLayered("${PORT} ${DB:?required}", base, override)
+	assert.Equal(t, "DB: required", err.Error())
+	assert.Equal(t, map[string]string{"PORT": "override", "DB": "default"}, sources)
+
+	// either mapping may be nil
+	out, sources, err = EvalLayered("${HOST}:${PORT}", base, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "base.example.com:80", out)
+	assert.Equal(t, map[string]string{"HOST": "base", "PORT": "base"}, sources)
+
+	out, sources, err = EvalLayered("${HOST}:${PORT}", nil, override)
+	assert.Nil(t, err)
+	assert.Equal(t, ":8080", out)
+	assert.Equal(t, map[string]string{"HOST": "default", "PORT": "override"}, sources)
+}
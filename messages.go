@@ -0,0 +1,171 @@
+package envsubst
+
+import (
+	"sync"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// Messages holds the text of the errors reported by the parser and the
+// evaluator so that it can be translated. Messages with placeholders are
+// formatted with indexed verbs, such as %[1]s, which a translation may
+// reorder but must keep.
+type Messages struct {
+	parse.Messages
+
+	// UnboundVariable is the message of the UnsetError reported by strict
+	// evaluation.
+	UnboundVariable string
+
+	// ParameterNotSet is the message of an UnsetError from ${var:?} and
+	// ${var?}, which have no message of their own.
+	ParameterNotSet string
+
+	// MappingError is formatted with the variable name, %[1]s, and the
+	// error returned by the mapping, %[2]v.
+	MappingError string
+
+	// UnknownFunction is formatted with the function name, %[1]q.
+	UnknownFunction string
+
+	// CommandNotAllowed is formatted with the command name, %[1]q.
+	CommandNotAllowed string
+
+	// TooManyVariables is formatted with the number of distinct variables
+	// in the template, %[1]d, and the limit, %[2]d.
+	TooManyVariables string
+
+	// CommandFailed is formatted with the command, %[1]q, and the error
+	// running it, %[2]w. CommandFailedOutput is used instead when the
+	// command wrote to its standard error, %[3]s.
+	CommandFailed       string
+	CommandFailedOutput string
+
+	// InvalidName is formatted with a file or directory name that is not
+	// a single path element, %[1]q.
+	InvalidName string
+
+	// CannotEscape is formatted with the text given to EscapeLiteral,
+	// %[1]q.
+	CannotEscape string
+
+	// EqArgs, SplitArgs and JoinArgs are formatted with the number of
+	// arguments given to the function, %[1]d.
+	EqArgs    string
+	SplitArgs string
+	JoinArgs  string
+
+	// ModeArgs is formatted with the function name, %[1]s, and the number
+	// of arguments given to it, %[2]d.
+	ModeArgs string
+
+	// UnknownMode is formatted with the function name, %[1]s, and the
+	// mode, %[2]q.
+	UnknownMode string
+
+	// NotANumber is formatted with the expected value of eq, %[1]q.
+	NotANumber string
+
+	// NotAnInteger is formatted with the index of split, %[1]q.
+	NotAnInteger string
+
+	SubstringNegative string
+	NoAllowedCommands string
+	EmptyName         string
+}
+
+var defaultMessages = Messages{
+	Messages:            parse.DefaultMessages(),
+	UnboundVariable:     "unbound variable",
+	ParameterNotSet:     "parameter null or not set",
+	MappingError:        "unable to resolve variable %[1]s: %[2]v",
+	UnknownFunction:     "unknown function %[1]q",
+	CommandNotAllowed:   "command %[1]q is not allowed",
+	TooManyVariables:    "template references %[1]d distinct variables, more than the limit of %[2]d",
+	CommandFailed:       "command %[1]q: %[2]w",
+	CommandFailedOutput: "command %[1]q: %[2]w: %[3]s",
+	InvalidName:         "invalid name %[1]q",
+	CannotEscape:        "text %[1]q cannot be escaped without BackslashEscape",
+	EqArgs:              "eq takes expected, yes and no arguments and an optional mode, got %[1]d arguments",
+	SplitArgs:           "split takes separator and index arguments, got %[1]d arguments",
+	JoinArgs:            "join takes an optional separator, got %[1]d arguments",
+	ModeArgs:            "%[1]s takes an optional mode, got %[2]d arguments",
+	UnknownMode:         "%[1]s: unknown mode %[2]q",
+	NotANumber:          "eq: expected value %[1]q is not a number",
+	NotAnInteger:        "split: index %[1]q is not an integer",
+	SubstringNegative:   "substring expression < 0",
+	NoAllowedCommands:   "command substitution requires a non-empty allow-list",
+	EmptyName:           "name expands to empty string",
+}
+
+var (
+	messagesMu sync.RWMutex
+	messages   = defaultMessages
+)
+
+// DefaultMessages returns the English messages used by default.
+func DefaultMessages() Messages {
+	return defaultMessages
+}
+
+// SetMessages replaces the messages of the errors reported by the parser
+// and the evaluator. Empty fields keep their default. A message that does
+// not use the same placeholders as its default is an error, and no
+// messages are changed.
+func SetMessages(m Messages) error {
+	d := defaultMessages
+	for _, f := range []struct {
+		name string
+		msg  *string
+		def  string
+	}{
+		{"UnboundVariable", &m.UnboundVariable, d.UnboundVariable},
+		{"ParameterNotSet", &m.ParameterNotSet, d.ParameterNotSet},
+		{"MappingError", &m.MappingError, d.MappingError},
+		{"UnknownFunction", &m.UnknownFunction, d.UnknownFunction},
+		{"CommandNotAllowed", &m.CommandNotAllowed, d.CommandNotAllowed},
+		{"TooManyVariables", &m.TooManyVariables, d.TooManyVariables},
+		{"CommandFailed", &m.CommandFailed, d.CommandFailed},
+		{"CommandFailedOutput", &m.CommandFailedOutput, d.CommandFailedOutput},
+		{"InvalidName", &m.InvalidName, d.InvalidName},
+		{"CannotEscape", &m.CannotEscape, d.CannotEscape},
+		{"EqArgs", &m.EqArgs, d.EqArgs},
+		{"SplitArgs", &m.SplitArgs, d.SplitArgs},
+		{"JoinArgs", &m.JoinArgs, d.JoinArgs},
+		{"ModeArgs", &m.ModeArgs, d.ModeArgs},
+		{"UnknownMode", &m.UnknownMode, d.UnknownMode},
+		{"NotANumber", &m.NotANumber, d.NotANumber},
+		{"NotAnInteger", &m.NotAnInteger, d.NotAnInteger},
+		{"SubstringNegative", &m.SubstringNegative, d.SubstringNegative},
+		{"NoAllowedCommands", &m.NoAllowedCommands, d.NoAllowedCommands},
+		{"EmptyName", &m.EmptyName, d.EmptyName},
+	} {
+		if err := parse.MergeMessage(f.name, f.msg, f.def); err != nil {
+			return err
+		}
+	}
+	if err := parse.SetMessages(m.Messages); err != nil {
+		return err
+	}
+
+	messagesMu.Lock()
+	messages = m
+	messagesMu.Unlock()
+	return nil
+}
+
+// currentMessages returns the messages in use.
+func currentMessages() Messages {
+	messagesMu.RLock()
+	defer messagesMu.RUnlock()
+	return messages
+}
+
+// messageError returns an error whose text is looked up in the current
+// messages each time it is reported.
+func messageError(text func(m *Messages) string) error {
+	return parse.NewMessageError(func() string {
+		m := currentMessages()
+		return text(&m)
+	})
+}
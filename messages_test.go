@@ -0,0 +1,74 @@
+package envsubst
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/logandavies181/envsubst/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetMessages(t *testing.T) {
+	t.Cleanup(func() { SetMessages(DefaultMessages()) })
+
+	m := Messages{
+		UnboundVariable: "variable non définie",
+		MappingError:    "%[2]v (variable %[1]s)",
+	}
+	m.MissingClosingBrace = "accolade fermante manquante"
+	m.BadSubstitution = "substitution invalide"
+	m.Position = "ligne %[1]d, colonne %[2]d : %[3]v"
+	assert.Nil(t, SetMessages(m))
+
+	_, err := Parse("${A")
+	assert.Equal(t, "accolade fermante manquante", err.Error())
+	assert.True(t, errors.Is(err, parse.ErrMissingClosingBrace))
+
+	var syntaxErr *parse.SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, "ligne 1, colonne 4 : accolade fermante manquante\n${A\n   ^", syntaxErr.Pretty())
+
+	_, err = Parse("${A|nosuchfunc}")
+	assert.Equal(t, "substitution invalide", err.Error())
+	assert.True(t, errors.Is(err, parse.ErrBadSubstitution))
+
+	_, err = EvalEnvStrict("${ENVSUBST_TEST_UNSET}")
+	assert.Equal(t, "ENVSUBST_TEST_UNSET: variable non définie", err.Error())
+
+	tmpl, err := Parse("${A}")
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteE(func(string) (string, error) {
+		return "", errors.New("délai dépassé")
+	})
+	assert.Equal(t, "délai dépassé (variable A)", err.Error())
+
+	// errors of the evaluator are translated too
+	m = DefaultMessages()
+	m.JoinArgs = "join prend un séparateur facultatif, %[1]d donnés"
+	m.InvalidName = "nom invalide %[1]q"
+	assert.Nil(t, SetMessages(m))
+	_, err = Eval("${A|join:a:b}", func(string) string { return "x" })
+	assert.Equal(t, "join prend un séparateur facultatif, 2 donnés", err.Error())
+	_, err = renderName("${A}", func(string) string { return ".." }, &RenderOptions{SubstituteNames: true})
+	assert.Equal(t, `nom invalide ".."`, err.Error())
+
+	// fields left empty keep their default
+	_, err = Parse("${A/}")
+	assert.Equal(t, `operator "/" takes 1 to 2 argument(s), got 0`, err.Error())
+}
+
+func TestSetMessagesPlaceholders(t *testing.T) {
+	t.Cleanup(func() { SetMessages(DefaultMessages()) })
+
+	err := SetMessages(Messages{MappingError: "variable introuvable"})
+	assert.NotNil(t, err)
+
+	m := Messages{UnboundVariable: "variable non définie"}
+	m.Arity = "l'opérateur %[1]q prend %[2]s argument(s)"
+	err = SetMessages(m)
+	assert.NotNil(t, err)
+
+	// nothing is changed when a message is rejected
+	_, err = EvalEnvStrict("${ENVSUBST_TEST_UNSET}")
+	assert.Equal(t, "ENVSUBST_TEST_UNSET: unbound variable", err.Error())
+}
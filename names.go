@@ -0,0 +1,66 @@
+package envsubst
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// isPrefixNames reports whether the operator expands to the names of the
+// variables starting with a prefix, as in ${!prefix*} and ${!prefix@}.
+func isPrefixNames(name string) bool {
+	return name == "!*" || name == "!@"
+}
+
+// prefixNames returns the names of the set variables that start with
+// prefix, sorted and separated by spaces as bash expands them, so that
+// the output does not depend on the order the names are listed in.
+func (t *Template) prefixNames(prefix string) string {
+	var names []string
+	if t.opts.ListNames != nil {
+		names = t.opts.ListNames()
+	} else {
+		names = envNames()
+	}
+
+	var matched []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+	return strings.Join(matched, " ")
+}
+
+// envNames returns the names of the environment variables.
+func envNames() []string {
+	return environNames(os.Environ())
+}
+
+// environNames returns the names of the variables of env, in the key=value
+// form of os.Environ. Entries without a name, such as the =C: entries of
+// Windows, are skipped.
+func environNames(env []string) []string {
+	names := make([]string, 0, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			names = append(names, kv[:i])
+		}
+	}
+	return names
+}
+
+// transformNames returns a resolver that looks up variables with mapping
+// under the names given by the NameTransform option.
+func (t *Template) transformNames(mapping resolver) resolver {
+	if t.opts.NameTransform == nil {
+		return mapping
+	}
+	transform := t.opts.NameTransform
+	return func(name string, ctx ResolveContext) (string, bool, error) {
+		return mapping(transform(name), ctx)
+	}
+}
@@ -0,0 +1,97 @@
+package envsubst
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixNames(t *testing.T) {
+	for _, name := range []string{"ENVSUBST_TEST_C", "ENVSUBST_TEST_A", "ENVSUBST_TEST_B", "ENVSUBST_OTHER"} {
+		t.Setenv(name, "x")
+	}
+
+	got, err := EvalEnv("${!ENVSUBST_TEST_*} ${!ENVSUBST_TEST_@}")
+	assert.Nil(t, err)
+	assert.Equal(t, "ENVSUBST_TEST_A ENVSUBST_TEST_B ENVSUBST_TEST_C ENVSUBST_TEST_A ENVSUBST_TEST_B ENVSUBST_TEST_C", got)
+
+	got, err = EvalEnv("[${!ENVSUBST_NONE_*}]")
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", got)
+}
+
+func TestEnvironNames(t *testing.T) {
+	env := []string{"=C:=C:\\work", "PATH=/bin", "EMPTY=", "A=b=c", "NOVALUE"}
+	assert.Equal(t, []string{"PATH", "EMPTY", "A"}, environNames(env))
+}
+
+func TestPrefixNamesListNames(t *testing.T) {
+	opts := &Options{
+		ListNames: func() []string {
+			return []string{"app_port", "db_host", "app_host", "app_port"}
+		},
+		Strict: true,
+	}
+
+	got, err := EvalWithOptions("${!app_*}|${!web_*}", func(string) string { return "" }, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "app_host app_port|", got)
+}
+
+func TestParamName(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"FOO": "value"}[name]
+	}
+
+	got, err := EvalWithOptions("${FOO@K}=${FOO} ${UNSET@K}=${UNSET}", mapping, &Options{Strict: true, ReportAllUnset: true})
+	assert.Equal(t, "FOO=value UNSET=", got)
+	assert.Equal(t, "UNSET: unbound variable", err.Error())
+
+	got, err = EvalWithOptions("${myVar@K}=${myVar}", func(string) string { return "x" }, &Options{NameTransform: strings.ToUpper})
+	assert.Nil(t, err)
+	assert.Equal(t, "myVar=x", got)
+}
+
+func TestNameTransform(t *testing.T) {
+	env := map[string]string{"MYVAR": "value", "OTHER": "other", "EMPTY": ""}
+	mapping := func(s string) (string, bool) {
+		v, ok := env[s]
+		return v, ok
+	}
+	opts := &Options{
+		NameTransform: strings.ToUpper,
+		ArrayMapping: func(name string) ([]string, bool) {
+			return []string{"a", "b"}, name == "LIST"
+		},
+	}
+
+	for input, want := range map[string]string{
+		"${myVar}":                        "value",
+		"$myVar ${Other}":                 "value other",
+		"${missing:-${myVar}}":            "value",
+		"${empty-${other}}":               "",
+		"${missing:-${nested:-${myVar}}}": "value",
+		"${list[1]}":                      "b",
+	} {
+		tmpl, err := ParseWithOptions(input, opts)
+		assert.Nil(t, err, input)
+		got, err := tmpl.ExecuteSet(mapping)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	// names are only transformed when the option is set
+	got, err := EvalSet("${myVar}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "", got)
+
+	// errors name the variable as it is written
+	tmpl, err := ParseWithOptions("${missing}", &Options{NameTransform: strings.ToUpper, Strict: true})
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteSet(mapping)
+	var unsetErr *UnsetError
+	assert.True(t, errors.As(err, &unsetErr))
+	assert.Equal(t, "missing", unsetErr.Name)
+}
@@ -0,0 +1,67 @@
+package envsubst
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	namespaceMu sync.RWMutex
+	namespaces  = map[string]Mapping{}
+)
+
+// RegisterNamespace makes the variables of resolver available to
+// templates as ${prefix:NAME}, e.g. ${secret:DB_PASSWORD}, instead of
+// being resolved from the mapping of the evaluation. As with a Mapping,
+// an empty value is unset, and an operator may follow the name, as in
+// ${secret:DB_PASSWORD:-x}.
+//
+// Substrings keep precedence over namespaces where they can be told
+// apart: the prefix must be registered and be followed by a colon and the
+// start of a name, so ${prefix:-x} is a default and ${prefix:1} a
+// substring. Only ${prefix:name}, which would otherwise be a substring of
+// prefix whose offset is not a number, is read as a namespace. Templates
+// are parsed with the namespaces registered at the time, so namespaces
+// should be registered before templates that use them are parsed. A
+// namespace cannot be redefined.
+func RegisterNamespace(prefix string, resolver Mapping) error {
+	if !funcPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid namespace %q", prefix)
+	}
+	if resolver == nil {
+		return fmt.Errorf("namespace %q is nil", prefix)
+	}
+
+	namespaceMu.Lock()
+	defer namespaceMu.Unlock()
+	if _, ok := namespaces[prefix]; ok {
+		return fmt.Errorf("namespace %q is already registered", prefix)
+	}
+	namespaces[prefix] = resolver
+	parseCache.reset()
+	return nil
+}
+
+// lookupNamespace returns the resolver of the named namespace.
+func lookupNamespace(prefix string) (Mapping, bool) {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+	fn, ok := namespaces[prefix]
+	return fn, ok
+}
+
+// isNamespace reports whether prefix is a registered namespace.
+func isNamespace(prefix string) bool {
+	_, ok := lookupNamespace(prefix)
+	return ok
+}
+
+// resolveNamespace resolves the variable name from the namespace prefix.
+func resolveNamespace(prefix, name string) (string, bool) {
+	fn, ok := lookupNamespace(prefix)
+	if !ok {
+		return "", false
+	}
+	v := fn(name)
+	return v, v != ""
+}
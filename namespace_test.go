@@ -0,0 +1,81 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unregisterNamespace removes the named namespace when the test ends, so
+// that the test can be run again.
+func unregisterNamespace(t *testing.T, prefix string) {
+	t.Cleanup(func() {
+		namespaceMu.Lock()
+		defer namespaceMu.Unlock()
+		delete(namespaces, prefix)
+		parseCache.reset()
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	unregisterNamespace(t, "testsecret")
+	assert.Nil(t, RegisterNamespace("testsecret", func(name string) string {
+		return map[string]string{"DB_PASSWORD": "s3cret"}[name]
+	}))
+	mapping := func(name string) string {
+		return map[string]string{"DB_PASSWORD": "mapped", "testsecret": "abcdef", "offset": "2", "other": "uvwxyz"}[name]
+	}
+
+	for input, want := range map[string]string{
+		// a registered namespace followed by a name is resolved from it
+		"${testsecret:DB_PASSWORD}":           "s3cret",
+		"${DB_PASSWORD}":                      "mapped",
+		"${testsecret:UNSET:-fallback}":       "fallback",
+		"${testsecret:DB_PASSWORD:+set}":      "set",
+		"${testsecret:DB_PASSWORD^^}":         "S3CRET",
+		"${UNSET:-${testsecret:DB_PASSWORD}}": "s3cret",
+
+		// operators and substrings of the namespace variable are kept
+		"${testsecret:-x} ${testsecret:1} ${testsecret: -2} ${testsecret:1:2}": "abcdef bcdef ef bc",
+
+		// other prefixes keep the meaning of a substring
+		"${other:offset}": "uvwxyz",
+	} {
+		got, err := Eval(input, mapping)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	got, err := EvalWithOptions("${testsecret:UNSET}", mapping, &Options{Strict: true})
+	assert.Equal(t, "", got)
+	assert.Equal(t, "UNSET: unbound variable", err.Error())
+
+	_, err = EvalWithOptions("${testsecret:DB_PASSWORD:?bad} ${X:?${testsecret:DB_PASSWORD}}", mapping, &Options{
+		Redactor: func(string, string) string { return "***" },
+	})
+	assert.Equal(t, "X: ***", err.Error())
+
+	assert.NotNil(t, RegisterNamespace("testsecret", mapping))
+	assert.NotNil(t, RegisterNamespace("bad-name", mapping))
+	assert.NotNil(t, RegisterNamespace("testnil", nil))
+}
+
+func TestRegisterNamespaceResetsCache(t *testing.T) {
+	SetParseCacheSize(10)
+	t.Cleanup(func() { SetParseCacheSize(0) })
+	mapping := func(name string) string {
+		return map[string]string{"testcached": "abcdef"}[name]
+	}
+
+	got, err := Eval("${testcached:X}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcdef", got)
+
+	unregisterNamespace(t, "testcached")
+	assert.Nil(t, RegisterNamespace("testcached", func(name string) string {
+		return map[string]string{"X": "namespaced"}[name]
+	}))
+	got, err = Eval("${testcached:X}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "namespaced", got)
+}
@@ -0,0 +1,213 @@
+package envsubst
+
+// Options controls how a template is parsed and evaluated. The zero value
+// provides the default behavior.
+type Options struct {
+	// Redactor, if set, masks the values of variables that appear in the
+	// text of evaluation errors, such as the message of ${var:?message}
+	// or an error returned by a custom function. It is called with the
+	// name and value of each variable resolved before the error, and
+	// should return the value unchanged if it is not secret.
+	Redactor func(name, value string) string
+
+	// MaxNestingDepth is the maximum depth to which substitutions may be
+	// nested, e.g. ${a:-${b}} has a depth of 2. Zero means
+	// parse.DefaultMaxNestingDepth.
+	MaxNestingDepth int
+
+	// MaxVariables, if positive, is the most distinct variable names a
+	// template may reference, including those nested within the arguments
+	// of other expansions. A template that references more fails to parse
+	// with a *TooManyVariablesError.
+	MaxVariables int
+
+	// DisableDollarEscape makes $$ stand for two literal dollar signs,
+	// instead of a single one, so that $$string is left as it is.
+	DisableDollarEscape bool
+
+	// BackslashEscape makes a backslash escape the dollar sign, or the
+	// Sigil, that follows it, so that \${VAR} is left as ${VAR}, and
+	// \\${VAR} is a backslash followed by the value of VAR. See
+	// parse.Tree.BackslashEscape.
+	BackslashEscape bool
+
+	// DottedNames allows names within braces to contain dots, such as
+	// ${Server.Port}, for mappings of nested values like StructMapping.
+	// See parse.Tree.DottedNames.
+	DottedNames bool
+
+	// Sigil, if set, is the character that starts an expansion instead of
+	// the dollar sign, e.g. with @ the template @{VAR:-default} and @VAR
+	// are expanded, @@ is a literal @ and $ is ordinary text. An @ that
+	// is not followed by { or a name is left as it is, but one followed by
+	// a name is expanded, as in user@example. See parse.Tree.Sigil.
+	Sigil rune
+
+	// StrictDollar makes a dollar sign, or the Sigil, that does not start
+	// an expansion, an escape or a command substitution fail to parse
+	// with a *parse.SyntaxError at its offset, e.g. in $ {VAR} or a
+	// trailing $. By default it is literal text. See
+	// parse.Tree.StrictDollar.
+	StrictDollar bool
+
+	// RecoverUnterminated treats a substitution cut off by the end of the
+	// input, such as ${VAR or ${VAR:-def, as if it were closed there
+	// instead of failing to parse. See parse.Tree.RecoverUnterminated.
+	RecoverUnterminated bool
+
+	// Conditionals enables conditional blocks, ${if:FLAG}...${endif}
+	// with an optional ${else}, whose content is only output if FLAG is
+	// set and not empty. Blocks may be nested. Unbalanced blocks fail to
+	// parse. See parse.Tree.Conditionals.
+	Conditionals bool
+
+	// Comments enables comments, ${//! text}, which produce no output.
+	// See parse.Tree.Comments.
+	Comments bool
+
+	// AllowCommandSubstitution enables the execution of command
+	// substitutions, $(command). By default they are passed through to
+	// the output verbatim.
+	//
+	// This is dangerous and should only be used with trusted templates.
+	// The command is split on white space and run directly, without a
+	// shell, so quoting and nested substitutions are not interpreted.
+	// Only commands listed in AllowedCommands may run, and the list must
+	// not be empty.
+	AllowCommandSubstitution bool
+
+	// AllowedCommands lists the names of the commands that may be run
+	// when AllowCommandSubstitution is enabled.
+	AllowedCommands []string
+
+	// SubstringNegativeClamp controls substrings, ${var:offset}, whose
+	// negative offset counts back past the start of the value. When true
+	// the offset is clamped to the start of the value, otherwise
+	// evaluation fails with ErrSubstringNegative.
+	SubstringNegativeClamp bool
+
+	// UnsetPlaceholder, if set, produces the text that replaces a
+	// substitution whose variable is unset, instead of the empty string,
+	// e.g. <UNSET:NAME>. It is not used when a default, alternate or error
+	// operator such as :- applies to the variable.
+	UnsetPlaceholder func(name string) string
+
+	// Strict makes evaluation fail with an *UnsetError when a variable is
+	// unset and no default, alternate or error operator such as :-
+	// applies to it.
+	Strict bool
+
+	// ReportAllUnset makes strict evaluation continue past unset
+	// variables and fail with an UnsetErrors naming every one of them,
+	// instead of stopping at the first.
+	ReportAllUnset bool
+
+	// UnsetSentinels are values, such as null or <nil>, that stand for no
+	// value. A variable whose value is one of them is treated as unset, so
+	// that ${var:-default} and ${var-default} use their default. A value
+	// that is wanted but equals a sentinel is treated as unset too.
+	UnsetSentinels []string
+
+	// TreatEmptyAsSet and TreatEmptyAsUnset override how the default,
+	// alternate and error operators treat a variable whose value is
+	// empty. By default, as in bash, the colon forms :-, :=, :? and :+
+	// treat it as unset, and -, =, ? and + treat it as set:
+	//
+	//   - TreatEmptyAsSet makes the colon forms treat it as set too, so
+	//     that ${var:-default} only uses its default when var is unset.
+	//   - TreatEmptyAsUnset makes the forms without a colon treat it as
+	//     unset too, so that ${var-default} uses its default when var is
+	//     empty.
+	//
+	// TreatEmptyAsUnset takes precedence if both are set. A mapping that
+	// cannot report an empty variable as set, such as a Mapping, has no
+	// set empty variables, so only TreatEmptyAsUnset changes its results.
+	TreatEmptyAsSet   bool
+	TreatEmptyAsUnset bool
+
+	// ResolveBareArgs makes a default, alternate or replacement text that
+	// is a plain variable name stand for that variable if it is set, e.g.
+	// ${VAR:-OTHER} is the value of OTHER when VAR is unset and OTHER is
+	// set, and the text OTHER otherwise. This is not standard shell
+	// behavior, in which the text is always literal.
+	ResolveBareArgs bool
+
+	// TrimDefaultWhitespace trims the white space around the text of a
+	// default, alternate or error message, so that ${var:- x } is x when
+	// var is unset. By default, as in bash, the white space is kept. The
+	// arguments of other operators, such as the pattern of ${var/ /_}, are
+	// never trimmed.
+	TrimDefaultWhitespace bool
+
+	// IndirectDefaults makes a default written as ! and a variable name
+	// an indirect reference, as ${!name} is in bash, e.g. ${A:-!B} is the
+	// value of the variable named by the value of B when A is unset. The
+	// default is empty if B is unset or its value is not a variable name.
+	// By default the text is literal, so ${A:-!B} is !B.
+	IndirectDefaults bool
+
+	// CaseLanguage, if set, is the BCP 47 language tag, such as tr, whose
+	// rules the case operators ^, ^^, , and ,, follow, e.g. so that i
+	// maps to İ in Turkish, using golang.org/x/text/cases. Languages
+	// without rules of their own, and an empty tag, use the default
+	// Unicode mapping. A malformed or unknown tag fails to parse.
+	CaseLanguage string
+
+	// ArrayMapping, if set, resolves the integer-indexed array referenced
+	// by ${arr[@]}, ${arr[N]} or ${!arr[@]}. A variable that it does not
+	// report is treated as an array of its scalar value, as in bash.
+	ArrayMapping func(name string) ([]string, bool)
+
+	// PositionalArgs, if not nil, are the positional parameters, so that
+	// ${1} is the first argument and ${2} the second. A positional
+	// parameter beyond the arguments is unset, while an empty argument is
+	// set, as the - and :- operators distinguish.
+	PositionalArgs []string
+
+	// NameTransform, if set, changes the name of every variable before it
+	// is looked up, including nested references and arrays, e.g. with
+	// strings.ToUpper ${myVar} looks up MYVAR. Errors, such as those of
+	// strict evaluation, name the variable as it is written.
+	NameTransform func(name string) string
+
+	// ListNames, if set, lists the names of the set variables, which
+	// ${!prefix*} and ${!prefix@} search for those starting with prefix.
+	// By default the names of the environment variables are searched.
+	ListNames func() []string
+
+	// NormalizeEOL converts every line ending in the output to the style
+	// once substitution is complete. Line endings within substituted
+	// values are normalized too, so a value ending lines with \r\n loses
+	// its carriage returns with EOLLF. The output of ExecuteMapped is not
+	// normalized, so that its spans stay valid.
+	NormalizeEOL EOLStyle
+
+	// PostProcess, if set, rewrites the output once substitution is
+	// complete, e.g. to format generated code, before NormalizeEOL is
+	// applied. It is called once with the whole output, and an error it
+	// returns fails the evaluation, which returns the output as it was
+	// before processing. Like NormalizeEOL, it is not applied to the
+	// output of ExecuteMapped.
+	PostProcess func(output string) (string, error)
+
+	// Escaper, if set, escapes the value of every substitution for the
+	// context of the output, such as DockerEnvEscaper, before ValuePrefix
+	// and ValueSuffix are added. Literal text and substitutions nested
+	// within the arguments of another are not escaped themselves; the
+	// result of the outer substitution is.
+	Escaper Escaper
+
+	// ValuePrefix and ValueSuffix are added to the value of every
+	// substitution, e.g. to quote each value, but not to literal text. A
+	// substitution nested within the arguments of another is not wrapped
+	// itself; the result of the outer substitution is. Empty values are
+	// wrapped too, unless SkipEmptyAffix is set.
+	ValuePrefix    string
+	ValueSuffix    string
+	SkipEmptyAffix bool
+
+	// OutputSize, if positive, is the expected length of the output in
+	// bytes, which is allocated up front so that a large output is not
+	// copied as it grows. By default the length of the input is used.
+	OutputSize int
+}
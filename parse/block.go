@@ -0,0 +1,141 @@
+package parse
+
+var (
+	// ErrUnclosedIf is returned when an ${if:name} block has no ${endif}.
+	ErrUnclosedIf error = &messageError{func(m *Messages) string { return m.UnclosedIf }}
+
+	// ErrUnexpectedElse is returned when an ${else} is outside a block or
+	// follows another ${else} in the same block.
+	ErrUnexpectedElse error = &messageError{func(m *Messages) string { return m.UnexpectedElse }}
+
+	// ErrUnexpectedEndif is returned when an ${endif} is outside a block.
+	ErrUnexpectedEndif error = &messageError{func(m *Messages) string { return m.UnexpectedEndif }}
+)
+
+// block is a conditional block whose ${endif} has not been parsed yet.
+type block struct {
+	node   *IfNode
+	then   []Node
+	els    []Node
+	inElse bool
+}
+
+// parseBlocks gathers the nodes between ${if:name}, ${else} and ${endif}
+// markers into IfNodes. Only markers at the top level of the tree are
+// recognized, not those within the arguments of a substitution. On error
+// it also returns the offset of the offending marker.
+func parseBlocks(root Node) (Node, int, error) {
+	nodes := flattenList(root)
+	found := false
+	for _, node := range nodes {
+		if blockMarker(node) != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return root, 0, nil
+	}
+
+	var top []Node
+	var stack []*block
+	add := func(node Node) {
+		switch {
+		case len(stack) == 0:
+			top = append(top, node)
+		case stack[len(stack)-1].inElse:
+			b := stack[len(stack)-1]
+			b.els = append(b.els, node)
+		default:
+			b := stack[len(stack)-1]
+			b.then = append(b.then, node)
+		}
+	}
+
+	for _, node := range nodes {
+		fn, _ := node.(*FuncNode)
+		switch blockMarker(node) {
+		case "if":
+			cond := newFuncNode(fn.Args[0].(*TextNode).Value)
+			cond.Pos, cond.End = fn.Pos, fn.End
+			stack = append(stack, &block{node: &IfNode{Cond: cond, Pos: fn.Pos}})
+		case "else":
+			if len(stack) == 0 || stack[len(stack)-1].inElse {
+				return nil, fn.Pos, ErrUnexpectedElse
+			}
+			stack[len(stack)-1].inElse = true
+		case "endif":
+			if len(stack) == 0 {
+				return nil, fn.Pos, ErrUnexpectedEndif
+			}
+			b := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			b.node.Then = joinNodes(b.then)
+			if b.inElse {
+				b.node.Else = joinNodes(b.els)
+			}
+			b.node.End = fn.End
+			add(b.node)
+		default:
+			add(node)
+		}
+	}
+	if len(stack) != 0 {
+		return nil, stack[len(stack)-1].node.Pos, ErrUnclosedIf
+	}
+	return joinNodes(top), 0, nil
+}
+
+// blockMarker returns the kind of block marker the node is, if, else or
+// endif, or the empty string if it is not a marker.
+func blockMarker(node Node) string {
+	fn, ok := node.(*FuncNode)
+	if !ok || fn.bare || fn.Index != "" {
+		return ""
+	}
+	switch {
+	case fn.Param == "if" && fn.Name == ":" && len(fn.Args) == 1:
+		text, ok := fn.Args[0].(*TextNode)
+		if ok && isName(text.Value) {
+			return "if"
+		}
+	case fn.Name == "" && (fn.Param == "else" || fn.Param == "endif"):
+		return fn.Param
+	}
+	return ""
+}
+
+// isName reports whether s is a valid variable name.
+func isName(s string) bool {
+	for i, r := range s {
+		if !acceptIdent(r, i) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// flattenList returns the nodes of nested lists in order.
+func flattenList(node Node) []Node {
+	list, ok := node.(*ListNode)
+	if !ok {
+		return []Node{node}
+	}
+
+	var nodes []Node
+	for _, n := range list.Nodes {
+		nodes = append(nodes, flattenList(n)...)
+	}
+	return nodes
+}
+
+// joinNodes returns the nodes as a single node.
+func joinNodes(nodes []Node) Node {
+	switch len(nodes) {
+	case 0:
+		return empty
+	case 1:
+		return nodes[0]
+	}
+	return newListNode(nodes...)
+}
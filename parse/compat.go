@@ -0,0 +1,90 @@
+package parse
+
+// parseCompat parses buf with the reduced grammar of os.Expand: $name and
+// ${name} only, with no operators. It never fails; invalid syntax is
+// dropped, as os.Expand drops it.
+func parseCompat(buf string) Node {
+	var nodes []Node
+	text := func(pos, end int) {
+		if end > pos {
+			nodes = append(nodes, &TextNode{Value: buf[pos:end], Pos: pos, End: end})
+		}
+	}
+
+	i := 0
+	for j := 0; j < len(buf); j++ {
+		if buf[j] != '$' || j+1 >= len(buf) {
+			continue
+		}
+		text(i, j)
+
+		name, w := shellName(buf[j+1:])
+		switch {
+		case name == "" && w > 0:
+			// invalid syntax, such as ${}, is dropped
+			i = j + w + 1
+		case name == "":
+			// a $ that is not followed by a name is literal
+			i = j
+		default:
+			node := newFuncNode(name)
+			node.bare = buf[j+1] != '{'
+			node.Pos, node.End = j, j+w+1
+			nodes = append(nodes, node)
+			i = j + w + 1
+		}
+		j += w
+	}
+	text(i, len(buf))
+
+	switch len(nodes) {
+	case 0:
+		return empty
+	case 1:
+		return nodes[0]
+	}
+	return newListNode(nodes...)
+}
+
+// shellName returns the name that follows a $ at the start of s and the
+// number of bytes it takes up, following the rules of os.Expand.
+func shellName(s string) (string, int) {
+	switch {
+	case s[0] == '{':
+		if len(s) > 2 && isShellSpecial(s[1]) && s[2] == '}' {
+			return s[1:2], 3
+		}
+		for i := 1; i < len(s); i++ {
+			if s[i] == '}' {
+				if i == 1 {
+					return "", 2 // bad syntax; drop ${}
+				}
+				return s[1:i], i + 1
+			}
+		}
+		return "", 1 // bad syntax; drop ${
+	case isShellSpecial(s[0]):
+		return s[0:1], 1
+	}
+
+	var i int
+	for i < len(s) && isAlphaNum(s[i]) {
+		i++
+	}
+	return s[:i], i
+}
+
+// isShellSpecial reports whether c names one of the special shell
+// variables, such as $* or $1.
+func isShellSpecial(c byte) bool {
+	switch c {
+	case '*', '#', '$', '@', '!', '?', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// isAlphaNum reports whether c may appear in a variable name.
+func isAlphaNum(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,103 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ArityError is reported when an operator is given the wrong number of
+// arguments, such as ${var/} which has no pattern.
+type ArityError struct {
+	Operator string // the operator, such as /
+	Args     int    // the number of arguments given
+	Min, Max int    // the number of arguments the operator takes
+
+	offset int
+}
+
+func (e *ArityError) Error() string {
+	m := currentMessages()
+	var want string
+	switch {
+	case e.Min == e.Max:
+		want = fmt.Sprint(e.Min)
+	default:
+		want = fmt.Sprintf(m.ArityRange, e.Min, e.Max)
+	}
+	return fmt.Sprintf(m.Arity, e.Operator, want, e.Args)
+}
+
+// SyntaxError records a parse error and the position in the input at which
+// it was detected.
+type SyntaxError struct {
+	Err    error // the underlying error, such as ErrMissingClosingBrace
+	Offset int   // byte offset in the input at which the error was detected
+
+	input string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Position returns the 1-based line and column, counted in characters, at
+// which the error was detected.
+func (e *SyntaxError) Position() (line, column int) {
+	before := e.input[:e.offset()]
+	start := strings.LastIndexByte(before, '\n') + 1
+	line = strings.Count(before, "\n") + 1
+	column = utf8.RuneCountInString(before[start:]) + 1
+	return line, column
+}
+
+// RuneOffset returns the offset in the input at which the error was
+// detected counted in characters rather than bytes, for editors that
+// count characters.
+func (e *SyntaxError) RuneOffset() int {
+	return utf8.RuneCountInString(e.input[:e.offset()])
+}
+
+// Pretty returns a human-friendly description of the error, showing the
+// line of the input at which it was detected with a caret under the
+// offending column.
+func (e *SyntaxError) Pretty() string {
+	offset := e.offset()
+	start := strings.LastIndexByte(e.input[:offset], '\n') + 1
+	end := strings.IndexByte(e.input[offset:], '\n')
+	if end == -1 {
+		end = len(e.input)
+	} else {
+		end += offset
+	}
+
+	// keep tabs in the padding so the caret lines up however wide the
+	// tabs are rendered
+	var pad strings.Builder
+	for _, r := range e.input[start:offset] {
+		if r == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+
+	line, column := e.Position()
+	return fmt.Sprintf(currentMessages().Position, line, column, e.Err) +
+		"\n" + e.input[start:end] + "\n" + pad.String() + "^"
+}
+
+// offset returns the error offset limited to the bounds of the input.
+func (e *SyntaxError) offset() int {
+	switch {
+	case e.Offset < 0:
+		return 0
+	case e.Offset > len(e.input):
+		return len(e.input)
+	}
+	return e.Offset
+}
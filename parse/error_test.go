@@ -0,0 +1,131 @@
+package parse
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Parse("some text ${var")
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.ErrorIs(t, err, ErrMissingClosingBrace)
+	assert.Equal(t, "missing closing brace", err.Error())
+	assert.Equal(t, 15, syntaxErr.Offset)
+	assert.Equal(t, 15, syntaxErr.RuneOffset())
+}
+
+func TestSyntaxErrorRuneOffset(t *testing.T) {
+	for input, want := range map[string]struct{ bytes, runes int }{
+		"${var":                  {5, 5},
+		"héllo ${x":              {10, 9},
+		"日本語\n${x:-€":            {18, 10},
+		"👍 ${}":                  {7, 4},
+		"ünïcödé ${a} ${b/} end": {20, 16},
+	} {
+		_, err := Parse(input)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("Want a syntax error for %q, got %v", input, err)
+		}
+		assert.Equal(t, want.bytes, syntaxErr.Offset, input)
+		assert.Equal(t, want.runes, syntaxErr.RuneOffset(), input)
+	}
+
+	// an offset beyond the input is limited to its end
+	err := &SyntaxError{Err: ErrBadSubstitution, Offset: 100, input: "é"}
+	assert.Equal(t, 1, err.RuneOffset())
+}
+
+func TestSyntaxErrorPretty(t *testing.T) {
+	tests := []struct {
+		input  string
+		pretty string
+	}{
+		{
+			input: "${var",
+			pretty: "line 1, column 6: missing closing brace\n" +
+				"${var\n" +
+				"     ^",
+		},
+		{
+			input: "first line\nsecond ${var:-x\nthird line",
+			pretty: "line 3, column 11: unable to parse substitution within function\n" +
+				"third line\n" +
+				"          ^",
+		},
+		{
+			input: "line one\n\tindented ${}",
+			pretty: "line 2, column 13: unable to parse variable name\n" +
+				"\tindented ${}\n" +
+				"\t           ^",
+		},
+		{
+			input: "héllo ${x",
+			pretty: "line 1, column 10: missing closing brace\n" +
+				"héllo ${x\n" +
+				"         ^",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input)
+
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("want SyntaxError for %q, got %v", test.input, err)
+		}
+		assert.Equal(t, test.pretty, syntaxErr.Pretty(), test.input)
+	}
+}
+
+func TestArityError(t *testing.T) {
+	tests := []struct {
+		input    string
+		operator string
+		args     int
+		offset   int
+	}{
+		// too few
+		{"${var:}", ":", 0, 5},
+		{"${var/}", "/", 0, 5},
+		{"${var//}", "//", 0, 5},
+		{"${var/#}", "/#", 0, 5},
+		{"${var/%}", "/%", 0, 5},
+
+		// too many
+		{"${var,x}", ",", 1, 5},
+		{"${var,,x}", ",,", 1, 5},
+		{"${var^x}", "^", 1, 5},
+		{"${var^^x}", "^^", 1, 5},
+		{"text ${var:1:2:3}", ":", 3, 10},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input)
+
+		var arityErr *ArityError
+		if !assert.True(t, errors.As(err, &arityErr), test.input) {
+			continue
+		}
+		assert.Equal(t, test.operator, arityErr.Operator, test.input)
+		assert.Equal(t, test.args, arityErr.Args, test.input)
+
+		var syntaxErr *SyntaxError
+		assert.True(t, errors.As(err, &syntaxErr), test.input)
+		assert.Equal(t, test.offset, syntaxErr.Offset, test.input)
+	}
+
+	_, err := Parse("${var/}")
+	assert.Equal(t, `operator "/" takes 1 to 2 argument(s), got 0`, err.Error())
+}
+
+func TestArityValid(t *testing.T) {
+	for _, input := range []string{"${var/x}", "${var/x/}", "${var/x/y}", "${var:1}", "${var:1:2}", "${var#x}", "${var^^}", "${var:-}"} {
+		_, err := Parse(input)
+		assert.Nil(t, err, input)
+	}
+}
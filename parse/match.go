@@ -0,0 +1,159 @@
+package parse
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/logandavies181/envsubst/path"
+)
+
+// MatchPositions returns the positions in value of the matches of the
+// shell glob pattern, such as *.txt or [0-9]?, as used by the replace
+// operators ${var/pattern/string} and ${var//pattern/string}. The result
+// holds the byte offsets of the start and end of each match in turn, so
+// that value[p[2*i]:p[2*i+1]] is the i-th match. As in bash, the search
+// finds the longest match at the leftmost position and continues after
+// its end, so matches do not overlap. Empty matches are not reported, and
+// an empty or malformed pattern has no matches.
+func MatchPositions(value, pattern string) []int {
+	if pattern == "" {
+		return nil
+	}
+	if text, ok := literal(pattern); ok {
+		return literalPositions(value, text)
+	}
+
+	n, fixed := fixedLength(pattern)
+	var positions []int
+	for start := 0; start < len(value); {
+		var end int
+		if fixed {
+			end = fixedMatch(value, pattern, start, n)
+		} else {
+			end = longestMatch(value, pattern, start)
+		}
+		if end < 0 {
+			return positions
+		}
+		if end == start {
+			_, w := utf8.DecodeRuneInString(value[start:])
+			start += w
+			continue
+		}
+		positions = append(positions, start, end)
+		start = end
+	}
+	return positions
+}
+
+// literal returns the text matched by pattern, with its escapes removed,
+// if it has no wildcards.
+func literal(pattern string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return "", false
+		case '\\':
+			i++
+			if i == len(pattern) {
+				// a trailing backslash is malformed
+				return "", false
+			}
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String(), true
+}
+
+// literalPositions returns the positions of the non-overlapping
+// occurrences of text in value, as MatchPositions does.
+func literalPositions(value, text string) []int {
+	var positions []int
+	for start := 0; ; {
+		i := strings.Index(value[start:], text)
+		if i < 0 {
+			return positions
+		}
+		start += i
+		positions = append(positions, start, start+len(text))
+		start += len(text)
+	}
+}
+
+// fixedLength returns the number of characters matched by pattern, if it
+// has no stars and so always matches the same number.
+func fixedLength(pattern string) (int, bool) {
+	n := 0
+	for i := 0; i < len(pattern); n++ {
+		switch pattern[i] {
+		case '*':
+			return 0, false
+		case '\\':
+			i++
+		case '[':
+			// a character class matches a single character
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+		}
+		if i >= len(pattern) {
+			// the pattern is malformed, which path.Match reports
+			return n + 1, true
+		}
+		_, w := utf8.DecodeRuneInString(pattern[i:])
+		i += w
+	}
+	return n, true
+}
+
+// fixedMatch returns the end of the match of pattern, which matches n
+// characters, starting at start, start if there is none, or -1 if the
+// pattern is malformed.
+func fixedMatch(value, pattern string, start, n int) int {
+	end := start
+	for i := 0; i < n; i++ {
+		if end == len(value) {
+			return start
+		}
+		_, w := utf8.DecodeRuneInString(value[end:])
+		end += w
+	}
+	ok, err := path.Match(pattern, value[start:end])
+	switch {
+	case err != nil:
+		return -1
+	case !ok:
+		return start
+	}
+	return end
+}
+
+// longestMatch returns the end of the longest non-empty match of pattern
+// starting at start, start if there is none, or -1 if the pattern is
+// malformed.
+func longestMatch(value, pattern string, start int) int {
+	// a trailing star matches the rest of the value, so if the pattern
+	// followed by one does not match, neither does any end
+	if ok, err := path.Match(pattern+"*", value[start:]); err != nil {
+		return -1
+	} else if !ok {
+		return start
+	}
+
+	for end := len(value); end > start; end-- {
+		if end < len(value) && !utf8.RuneStart(value[end]) {
+			continue
+		}
+		ok, err := path.Match(pattern, value[start:end])
+		if err != nil {
+			return -1
+		}
+		if ok {
+			return end
+		}
+	}
+	return start
+}
@@ -0,0 +1,60 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPositions(t *testing.T) {
+	tests := []struct {
+		value   string
+		pattern string
+		want    []int
+	}{
+		{"abcabc", "abc", []int{0, 3, 3, 6}},
+		{"abcabc", "b", []int{1, 2, 4, 5}},
+		{"abcabc", "x", nil},
+		// matches do not overlap
+		{"aaa", "aa", []int{0, 2}},
+		{"aaaa", "aa", []int{0, 2, 2, 4}},
+		// the longest match wins
+		{"a.b.c", "*.", []int{0, 4}},
+		{"a.b.c", "?.", []int{0, 2, 2, 4}},
+		{"x1y22", "[0-9]", []int{1, 2, 3, 4, 4, 5}},
+		// anchored at the start and end of the value
+		{"abcabc", "a*", []int{0, 6}},
+		{"abcabc", "*c", []int{0, 6}},
+		{"abcabc", "b*b", []int{1, 5}},
+		// offsets are in bytes but matches are whole runes
+		{"héllo", "?l", []int{1, 4}},
+		{"日本語", "?", []int{0, 3, 3, 6, 6, 9}},
+		{`a*b`, `\*`, []int{1, 2}},
+		{`a\b\`, `\\`, []int{1, 2, 3, 4}},
+		{`a\b\`, `b\\`, []int{2, 4}},
+		{"a.b.c", `\.?`, []int{1, 3, 3, 5}},
+		{"abc", `a\`, nil},
+		{"abc", "[a", nil},
+		{"abc", "", nil},
+		{"", "*", nil},
+		{"abc", "[", nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, MatchPositions(test.value, test.pattern), "%q in %q", test.pattern, test.value)
+	}
+}
+
+func BenchmarkMatchPositionsLiteral(b *testing.B) {
+	value := strings.Repeat("ab", 1000)
+	for i := 0; i < b.N; i++ {
+		MatchPositions(value, "b")
+	}
+}
+
+func BenchmarkMatchPositionsGlob(b *testing.B) {
+	value := strings.Repeat("ab", 1000)
+	for i := 0; i < b.N; i++ {
+		MatchPositions(value, "?b")
+	}
+}
@@ -0,0 +1,153 @@
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Messages holds the text of the errors reported by the parser so that it
+// can be translated. Messages with placeholders are formatted with indexed
+// verbs, such as %[1]q, which a translation may reorder but must keep.
+type Messages struct {
+	BadSubstitution       string
+	MissingClosingBrace   string
+	ParseVariableName     string
+	ParseFuncSubstitution string
+	ParseDefaultFunction  string
+	NestingTooDeep        string
+	UnclosedIf            string
+	UnexpectedElse        string
+	UnexpectedEndif       string
+	DanglingDollar        string
+
+	// DoubleDollar is formatted with the text that follows $$, %[1]s.
+	DoubleDollar string
+
+	// Arity is formatted with the operator, %[1]q, the number of arguments
+	// it takes, %[2]s, and the number given, %[3]d.
+	Arity string
+
+	// ArityRange is formatted with the least, %[1]d, and most, %[2]d,
+	// arguments an operator takes and is used as the second argument of
+	// Arity.
+	ArityRange string
+
+	// Position is formatted with the line, %[1]d, column, %[2]d, and error,
+	// %[3]v, at the start of SyntaxError.Pretty.
+	Position string
+}
+
+var defaultMessages = Messages{
+	BadSubstitution:       "bad substitution",
+	MissingClosingBrace:   "missing closing brace",
+	ParseVariableName:     "unable to parse variable name",
+	ParseFuncSubstitution: "unable to parse substitution within function",
+	ParseDefaultFunction:  "unable to parse default function",
+	NestingTooDeep:        "substitutions nested too deeply",
+	UnclosedIf:            "missing ${endif}",
+	UnexpectedElse:        "${else} without ${if}",
+	UnexpectedEndif:       "${endif} without ${if}",
+	DanglingDollar:        "dollar sign does not start an expansion",
+	DoubleDollar:          "unable to parse double dollar sign %[1]s",
+	Arity:                 "operator %[1]q takes %[2]s argument(s), got %[3]d",
+	ArityRange:            "%[1]d to %[2]d",
+	Position:              "line %[1]d, column %[2]d: %[3]v",
+}
+
+var (
+	messagesMu sync.RWMutex
+	messages   = defaultMessages
+)
+
+// DefaultMessages returns the English messages the parser uses by default.
+func DefaultMessages() Messages {
+	return defaultMessages
+}
+
+// SetMessages replaces the messages of the errors reported by the parser.
+// Empty fields keep their default. A message that does not use the same
+// placeholders as its default is an error, and no messages are changed.
+func SetMessages(m Messages) error {
+	d := defaultMessages
+	for _, f := range []struct {
+		name string
+		msg  *string
+		def  string
+	}{
+		{"BadSubstitution", &m.BadSubstitution, d.BadSubstitution},
+		{"MissingClosingBrace", &m.MissingClosingBrace, d.MissingClosingBrace},
+		{"ParseVariableName", &m.ParseVariableName, d.ParseVariableName},
+		{"ParseFuncSubstitution", &m.ParseFuncSubstitution, d.ParseFuncSubstitution},
+		{"ParseDefaultFunction", &m.ParseDefaultFunction, d.ParseDefaultFunction},
+		{"NestingTooDeep", &m.NestingTooDeep, d.NestingTooDeep},
+		{"UnclosedIf", &m.UnclosedIf, d.UnclosedIf},
+		{"UnexpectedElse", &m.UnexpectedElse, d.UnexpectedElse},
+		{"UnexpectedEndif", &m.UnexpectedEndif, d.UnexpectedEndif},
+		{"DanglingDollar", &m.DanglingDollar, d.DanglingDollar},
+		{"DoubleDollar", &m.DoubleDollar, d.DoubleDollar},
+		{"Arity", &m.Arity, d.Arity},
+		{"ArityRange", &m.ArityRange, d.ArityRange},
+		{"Position", &m.Position, d.Position},
+	} {
+		if err := MergeMessage(f.name, f.msg, f.def); err != nil {
+			return err
+		}
+	}
+
+	messagesMu.Lock()
+	messages = m
+	messagesMu.Unlock()
+	return nil
+}
+
+// currentMessages returns the messages in use.
+func currentMessages() Messages {
+	messagesMu.RLock()
+	defer messagesMu.RUnlock()
+	return messages
+}
+
+// MergeMessage sets an empty message to its default, or checks that it
+// uses the same placeholders as the default. It lets packages that extend
+// Messages check their own messages as SetMessages does.
+func MergeMessage(name string, msg *string, def string) error {
+	switch {
+	case *msg == "":
+		*msg = def
+	case verbs(*msg) != verbs(def):
+		return fmt.Errorf("message %s: %q does not use the placeholders of %q", name, *msg, def)
+	}
+	return nil
+}
+
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+// verbs returns the formatting verbs of the message, sorted so that a
+// translation may use them in a different order.
+func verbs(msg string) string {
+	found := verbPattern.FindAllString(msg, -1)
+	sort.Strings(found)
+	return strings.Join(found, " ")
+}
+
+// messageError is an error whose text is looked up in the current messages
+// each time it is reported, so that errors returned before SetMessages is
+// called are translated too.
+type messageError struct {
+	text func(m *Messages) string
+}
+
+func (e *messageError) Error() string {
+	m := currentMessages()
+	return e.text(&m)
+}
+
+// NewMessageError returns an error whose text is returned by text each
+// time it is reported, so that packages that extend Messages can declare
+// errors that are translated by their own SetMessages.
+func NewMessageError(text func() string) error {
+	return &messageError{func(*Messages) string { return text() }}
+}
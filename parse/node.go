@@ -1,6 +1,6 @@
 package parse
 
-import "bytes"
+import "strings"
 
 // Node is an element in the parse tree.
 type Node interface {
@@ -16,6 +16,9 @@ type (
 	// TextNode represents a string of text.
 	TextNode struct {
 		Value string
+
+		Pos int // byte offset of the start of the node in the input
+		End int // byte offset of the end of the node in the input
 	}
 
 	// FuncNode represents a string function.
@@ -24,9 +27,23 @@ type (
 		Name  string
 		Args  []Node
 
+		// Index is the subscript of an array reference, e.g. @ in
+		// ${arr[@]} or 1 in ${arr[1]}. It is empty for scalar variables.
+		Index string
+
+		// Namespace is the namespace the variable is resolved from, e.g.
+		// secret in ${secret:DB_PASSWORD}. It is empty for variables of
+		// the mapping.
+		Namespace string
+
+		Pos int // byte offset of the start of the node in the input
+		End int // byte offset of the end of the node in the input
+
 		// TODO handle nesting above 1
 		nesting int
-		buf bytes.Buffer
+
+		// bare is true for a reference written without braces, $var.
+		bare bool
 	}
 
 	// ListNode represents a list of nodes.
@@ -34,6 +51,36 @@ type (
 		Nodes []Node
 	}
 
+	// CommandNode represents a command substitution, $(command). The
+	// command is never executed; it is passed through verbatim.
+	CommandNode struct {
+		Command string
+
+		Pos int // byte offset of the start of the node in the input
+		End int // byte offset of the end of the node in the input
+	}
+
+	// CommentNode represents a comment, ${//! text}, which is only parsed
+	// when Tree.Comments is set. It produces no output.
+	CommentNode struct {
+		Text string // the text after the //! marker
+
+		Pos int // byte offset of the start of the node in the input
+		End int // byte offset of the end of the node in the input
+	}
+
+	// IfNode represents a conditional block, ${if:name}...${else}...${endif},
+	// which is only parsed when Tree.Conditionals is set. Then is used if
+	// the variable is set and not empty, and Else otherwise.
+	IfNode struct {
+		Cond *FuncNode // the variable tested, as a plain reference
+		Then Node
+		Else Node // nil if the block has no ${else}
+
+		Pos int // byte offset of the start of the node in the input
+		End int // byte offset of the end of the node in the input
+	}
+
 	// ParamNode struct{
 	// 	Name string
 	// }
@@ -70,8 +117,68 @@ type (
 	// }
 )
 
+// String returns the text of the substitution. It is derived from the
+// fields of the node, so nodes built by hand are formatted as well as
+// parsed ones, though redundant escapes in the input are not preserved.
 func (node FuncNode) String() string {
-	return node.buf.String()
+	if node.bare {
+		return "$" + node.Param
+	}
+
+	var b strings.Builder
+	b.WriteString("${")
+	switch {
+	case node.Name == "!":
+		b.WriteString("!" + node.Param + "[" + node.Index + "]}")
+		return b.String()
+	case node.Name == "!@" || node.Name == "!*":
+		b.WriteString("!" + node.Param + node.Name[1:] + "}")
+		return b.String()
+	case node.Name == "#" && len(node.Args) == 0:
+		b.WriteString("#" + node.Param + "}")
+		return b.String()
+	}
+
+	if node.Namespace != "" {
+		b.WriteString(node.Namespace + ":")
+	}
+	b.WriteString(node.Param)
+	if node.Index != "" {
+		b.WriteString("[" + node.Index + "]")
+	}
+	b.WriteString(node.Name)
+
+	for i, arg := range node.Args {
+		switch {
+		case node.Name == ":":
+			if i > 0 {
+				b.WriteByte(':')
+			}
+			b.WriteString(FormatNode(arg))
+		case strings.HasPrefix(node.Name, "/") && i == 0:
+			b.WriteString(formatPattern(arg))
+		case strings.HasPrefix(node.Name, "/"):
+			b.WriteString("/" + formatArg(arg, "/}"))
+		case strings.HasPrefix(node.Name, "|"):
+			b.WriteString(":" + formatArg(arg, ""))
+		case isRemoveFunc(node.Name):
+			b.WriteString(FormatNode(arg))
+		default:
+			b.WriteString(formatArg(arg, "}"))
+		}
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// isRemoveFunc reports whether name is one of the operators that remove a
+// pattern from the value, whose pattern is written as it is.
+func isRemoveFunc(name string) bool {
+	switch name {
+	case "#", "##", "%", "%%":
+		return true
+	}
+	return false
 }
 
 func (node FuncNode) Nesting() int {
@@ -93,8 +200,100 @@ func newFuncNode(name string) *FuncNode {
 	return &FuncNode{Param: name}
 }
 
+// newCommandNode returns a new CommandNode.
+func newCommandNode(command string) *CommandNode {
+	return &CommandNode{Command: command}
+}
+
+// CopyNode returns a deep copy of the node, so that the copy can be changed
+// without affecting the original.
+func CopyNode(node Node) Node {
+	switch n := node.(type) {
+	case *TextNode:
+		c := *n
+		return &c
+	case *FuncNode:
+		c := *n
+		c.Args = copyNodes(n.Args)
+		return &c
+	case *ListNode:
+		return &ListNode{Nodes: copyNodes(n.Nodes)}
+	case *CommandNode:
+		c := *n
+		return &c
+	case *CommentNode:
+		c := *n
+		return &c
+	case *IfNode:
+		c := *n
+		c.Cond = CopyNode(n.Cond).(*FuncNode)
+		c.Then = CopyNode(n.Then)
+		if n.Else != nil {
+			c.Else = CopyNode(n.Else)
+		}
+		return &c
+	}
+	return node
+}
+
+func copyNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	c := make([]Node, len(nodes))
+	for i, node := range nodes {
+		c[i] = CopyNode(node)
+	}
+	return c
+}
+
+// Span returns the byte offsets of the start and end of the node in the
+// input. The span of a list covers all of its nodes. Nodes that were not
+// produced by the parser have a zero span.
+func Span(node Node) (pos, end int) {
+	switch n := node.(type) {
+	case *TextNode:
+		return n.Pos, n.End
+	case *FuncNode:
+		return n.Pos, n.End
+	case *CommandNode:
+		return n.Pos, n.End
+	case *CommentNode:
+		return n.Pos, n.End
+	case *IfNode:
+		return n.Pos, n.End
+	case *ListNode:
+		if len(n.Nodes) == 0 {
+			return 0, 0
+		}
+		pos, _ = Span(n.Nodes[0])
+		_, end = Span(n.Nodes[len(n.Nodes)-1])
+		return pos, end
+	}
+	return 0, 0
+}
+
+// setSpan records the byte offsets of the node in the input.
+func setSpan(node Node, pos, end int) {
+	switch n := node.(type) {
+	case *TextNode:
+		n.Pos, n.End = pos, end
+	case *FuncNode:
+		n.Pos, n.End = pos, end
+	case *CommandNode:
+		n.Pos, n.End = pos, end
+	case *CommentNode:
+		n.Pos, n.End = pos, end
+	case *IfNode:
+		n.Pos, n.End = pos, end
+	}
+}
+
 // node() defines the node in a parse tree
 
-func (*TextNode) node() {}
-func (*ListNode) node() {}
-func (*FuncNode) node() {}
+func (*TextNode) node()    {}
+func (*ListNode) node()    {}
+func (*FuncNode) node()    {}
+func (*CommandNode) node() {}
+func (*CommentNode) node() {}
+func (*IfNode) node()      {}
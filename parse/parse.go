@@ -4,40 +4,142 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
 	// ErrBadSubstitution represents a substitution parsing error.
-	ErrBadSubstitution = errors.New("bad substitution")
-	ErrBadSubstitution2 = errors.New("bad substitution")
+	ErrBadSubstitution  error = &messageError{func(m *Messages) string { return m.BadSubstitution }}
+	ErrBadSubstitution2 error = &messageError{func(m *Messages) string { return m.BadSubstitution }}
 
 	// ErrMissingClosingBrace represents a missing closing brace "}" error.
-	ErrMissingClosingBrace = errors.New("missing closing brace")
+	ErrMissingClosingBrace error = &messageError{func(m *Messages) string { return m.MissingClosingBrace }}
 
 	// ErrParseVariableName represents the error when unable to parse a
 	// variable name within a substitution.
-	ErrParseVariableName = errors.New("unable to parse variable name")
+	ErrParseVariableName error = &messageError{func(m *Messages) string { return m.ParseVariableName }}
 
 	// ErrParseFuncSubstitution represents the error when unable to parse the
 	// substitution within a function parameter.
-	ErrParseFuncSubstitution = errors.New("unable to parse substitution within function")
+	ErrParseFuncSubstitution error = &messageError{func(m *Messages) string { return m.ParseFuncSubstitution }}
 
 	// ErrParseDefaultFunction represent the error when unable to parse a
 	// default function.
-	ErrParseDefaultFunction = errors.New("unable to parse default function")
+	ErrParseDefaultFunction error = &messageError{func(m *Messages) string { return m.ParseDefaultFunction }}
+
+	// ErrDanglingDollar represents the error when a dollar sign does not
+	// start an expansion and Tree.StrictDollar is set.
+	ErrDanglingDollar error = &messageError{func(m *Messages) string { return m.DanglingDollar }}
+
+	// ErrNestingTooDeep represents the error when substitutions are nested
+	// more deeply than the tree allows.
+	ErrNestingTooDeep error = &messageError{func(m *Messages) string { return m.NestingTooDeep }}
 )
 
+// DefaultMaxNestingDepth is the maximum depth to which substitutions may be
+// nested, e.g. ${a:-${b}} has a depth of 2, unless the tree sets its own.
+const DefaultMaxNestingDepth = 1000
+
 // ErrParseDoubleDollar represents the error when unable to parse a $$
 func ErrParseDoubleDollar(str string) error {
-	return fmt.Errorf("unable to parse double dollar sign %s", str)
+	return fmt.Errorf(currentMessages().DoubleDollar, str)
 }
 
 // Tree is the representation of a single parsed shell format string
 type Tree struct {
 	Root Node
 
+	// Alias, if set, resolves operator aliases such as |default to the
+	// canonical operator they stand for, such as :-. Aliases are written
+	// directly after the variable name and may be followed by a colon
+	// separating them from the operator's arguments, e.g. ${var|default:x}.
+	// The parsed node records the canonical operator.
+	Alias func(alias string) (canonical string, ok bool)
+
+	// Func, if set, reports whether name is a registered function, which
+	// is called as ${var|name:arg1:arg2}. The parsed node records the
+	// function as its operator, e.g. |name.
+	Func func(name string) bool
+
+	// Namespace, if set, reports whether name is a registered namespace,
+	// which selects where the variable written after it and a colon is
+	// resolved from, e.g. ${secret:DB_PASSWORD}. A namespace is only
+	// recognized when it is registered and the colon is followed by the
+	// start of a name, so ${name:-x} and ${name:1} keep their meaning for
+	// any name, but a registered namespace hides the substring operator
+	// with an offset that starts with a letter, ${name:offset}. The parsed
+	// node records the namespace and the variable separately, and an
+	// operator may follow, e.g. ${secret:DB_PASSWORD:-x}.
+	Namespace func(name string) bool
+
+	// DisableDollarEscape makes $$ stand for two literal dollar signs, so
+	// that $$string and $${string} are left as they are. By default $$ is
+	// replaced by a single $, which may start the expansion that follows.
+	DisableDollarEscape bool
+
+	// ExpandCompat restricts the grammar to that of os.Expand: $name and
+	// ${name} only, with no operators, escapes or command substitutions.
+	// Parsing never fails; invalid syntax is dropped as os.Expand drops it.
+	ExpandCompat bool
+
+	// Conditionals enables conditional blocks, ${if:name}...${endif}, with
+	// an optional ${else}, which are parsed into IfNodes. Blocks may be
+	// nested, but only at the top level of the input, not within the
+	// arguments of a substitution.
+	Conditionals bool
+
+	// Comments enables comments, ${//! text}, which are parsed into
+	// CommentNodes and produce no output. The comment ends at the first
+	// closing brace. The marker cannot start a variable name or an
+	// operator, so it does not change the meaning of other substitutions.
+	Comments bool
+
+	// RecoverUnterminated treats a substitution that is cut off by the end
+	// of the input as if it had been closed there, e.g. ${VAR as ${VAR} and
+	// ${VAR:-def as ${VAR:-def}. An operator cut off before its operand is
+	// dropped, so ${VAR: is ${VAR}. By default this is a syntax error.
+	RecoverUnterminated bool
+
+	// MaxNestingDepth is the maximum depth to which substitutions may be
+	// nested. Deeper input fails with ErrNestingTooDeep rather than
+	// exhausting the stack. Zero means DefaultMaxNestingDepth.
+	MaxNestingDepth int
+
+	// Sigil is the character that starts an expansion in place of the
+	// dollar sign, e.g. with @ the input @{VAR} and @VAR are expanded and
+	// @@ is the escape for a literal @, while $ is ordinary text. As with
+	// the dollar sign, a sigil that is not followed by { or a name is left
+	// as it is. Zero means $. The sigil should not be a character that is
+	// part of a name or of the syntax within a substitution, such as {, }
+	// or \. ExpandCompat always uses $, and FormatNode writes nodes with
+	// $.
+	Sigil rune
+
+	// StrictDollar makes a sigil that does not start an expansion, such
+	// as the dollar sign of $ {name} or a trailing $, fail to parse with
+	// ErrDanglingDollar at its offset, to catch typos. The escape $$, \$
+	// with BackslashEscape, and command substitutions are still accepted.
+	// By default such a sigil is literal text.
+	StrictDollar bool
+
+	// BackslashEscape makes a backslash escape the sigil that follows it,
+	// so that \${string} and \$string are left as ${string} and $string.
+	// In a run of backslashes before the sigil each pair stands for one
+	// backslash, and an odd one out escapes the sigil, so \\${string} is a
+	// backslash followed by the expansion. Within the arguments of an
+	// expansion \$ is a literal sigil. FormatNode writes text as it is,
+	// without these escapes.
+	BackslashEscape bool
+
+	// DottedNames allows the name of a variable within braces to contain
+	// dots that join non-empty segments, to name a nested value, e.g.
+	// ${outer.inner}. Bare variables such as $outer.inner end at the
+	// first dot.
+	DottedNames bool
+
 	// Parsing only; cleared after parse.
 	scanner *scanner
+	depth   int
 }
 
 // Parse parses the string and returns a Tree.
@@ -50,21 +152,130 @@ func Parse(buf string) (*Tree, error) {
 // Parse parses the string buffer to construct an ast
 // representation for expansion.
 func (t *Tree) Parse(buf string) (tree *Tree, err error) {
+	if t.scanner == nil {
+		t.scanner = new(scanner)
+	}
+	if t.ExpandCompat {
+		t.Root = parseCompat(buf)
+		return t, nil
+	}
+	sigil := t.sigil()
+	if buf != "" && strings.IndexRune(buf, sigil) == -1 {
+		// nothing can be substituted, so the input is a single text node
+		t.Root = &TextNode{Value: buf, End: len(buf)}
+		return t, nil
+	}
+
 	t.scanner.init(buf)
+	t.scanner.sigil = sigil
+	t.scanner.strictSigil = t.StrictDollar
+	t.depth = 0
 	t.Root, err = t.parseAny()
+	if err == nil && t.scanner.dangling != -1 {
+		return t, &SyntaxError{Err: ErrDanglingDollar, Offset: t.scanner.dangling, input: buf}
+	}
+	if err == nil && t.Conditionals {
+		var offset int
+		t.Root, offset, err = parseBlocks(t.Root)
+		if err != nil {
+			return t, &SyntaxError{Err: err, Offset: offset, input: buf}
+		}
+	}
+	if err != nil {
+		offset := t.scanner.tokenPos
+		var arityErr *ArityError
+		if errors.As(err, &arityErr) {
+			offset = arityErr.offset
+		}
+		err = &SyntaxError{Err: err, Offset: offset, input: buf}
+	}
 	return t, err
 }
 
+// sigil returns the character that starts an expansion.
+func (t *Tree) sigil() rune {
+	if t.Sigil == 0 {
+		return '$'
+	}
+	return t.Sigil
+}
+
+// Copy returns a deep copy of the tree and its nodes.
+func (t *Tree) Copy() *Tree {
+	return &Tree{
+		Root:                CopyNode(t.Root),
+		Alias:               t.Alias,
+		Func:                t.Func,
+		Namespace:           t.Namespace,
+		DisableDollarEscape: t.DisableDollarEscape,
+		ExpandCompat:        t.ExpandCompat,
+		Conditionals:        t.Conditionals,
+		Comments:            t.Comments,
+		RecoverUnterminated: t.RecoverUnterminated,
+		MaxNestingDepth:     t.MaxNestingDepth,
+		Sigil:               t.Sigil,
+		StrictDollar:        t.StrictDollar,
+		BackslashEscape:     t.BackslashEscape,
+		DottedNames:         t.DottedNames,
+	}
+}
+
+// arities lists the number of arguments each operator takes. The default
+// functions, such as :-, take any number of arguments, which are joined
+// together, and so are not listed. The pattern of the remove operators may
+// be empty, as in ${var#}, and # without one is also ${#var}.
+var arities = map[string]struct{ min, max int }{
+	",":  {0, 0},
+	",,": {0, 0},
+	"^":  {0, 0},
+	"^^": {0, 0},
+	"#":  {0, 1},
+	"##": {0, 1},
+	"%":  {0, 1},
+	"%%": {0, 1},
+	":":  {1, 2},
+	"/":  {1, 2},
+	"//": {1, 2},
+	"/#": {1, 2},
+	"/%": {1, 2},
+}
+
+// checkArity returns an *ArityError if the node has the wrong number of
+// arguments for its operator, which starts at offset pos in the input.
+func checkArity(node *FuncNode, pos int) error {
+	arity, ok := arities[node.Name]
+	if !ok || len(node.Args) >= arity.min && len(node.Args) <= arity.max {
+		return nil
+	}
+	return &ArityError{
+		Operator: node.Name,
+		Args:     len(node.Args),
+		Min:      arity.min,
+		Max:      arity.max,
+		offset:   pos,
+	}
+}
+
+// escapes returns the escape characters chars, with a backslash before the
+// sigil added if BackslashEscape is set.
+func (t *Tree) escapes(chars byte) byte {
+	if t.BackslashEscape {
+		return chars | backslashDollar
+	}
+	return chars
+}
+
 func (t *Tree) parseAny() (Node, error) {
 	t.scanner.accept = acceptRune
-	t.scanner.mode = scanIdent | scanLbrack | scanEscape
-	t.scanner.escapeChars = dollar
+	t.scanner.mode = scanIdent | scanLbrack | scanEscape | scanCommand
+	t.scanner.escapeChars = t.escapes(dollar)
 
 	switch t.scanner.scan() {
 	case tokenIdent:
 		left := newTextNode(
 			t.scanner.string(),
 		)
+		left.Pos, left.End = t.scanner.span()
 		right, err := t.parseAny()
 		switch {
 		case err != nil:
@@ -104,7 +315,19 @@ func (t *Tree) parseAny() (Node, error) {
 		}
 		return newListNode(left, right), nil
 	case tokenDoubleDollar:
-		left := newTextNode("$")
+		left := t.parseDoubleDollar()
+
+		right, err := t.parseAny()
+		switch {
+		case err != nil:
+			return nil, err
+		case right == empty:
+			return left, nil
+		}
+		return newListNode(left, right), nil
+	case tokenCommand:
+		left := newCommandNode(t.scanner.command())
+		left.Pos, left.End = t.scanner.span()
 
 		right, err := t.parseAny()
 		switch {
@@ -119,7 +342,26 @@ func (t *Tree) parseAny() (Node, error) {
 	return nil, ErrBadSubstitution
 }
 
-func (t *Tree) parseBareVar() (Node, error) {
+// parseDoubleDollar returns the text of a $$ token. Only the first dollar
+// sign has been consumed, so that the second may start an expansion,
+// unless the escape is disabled.
+func (t *Tree) parseDoubleDollar() *TextNode {
+	text := string(t.scanner.sigil)
+	if t.DisableDollarEscape {
+		t.scanner.read()
+		text += text
+	}
+	node := newTextNode(text)
+	node.Pos, node.End = t.scanner.span()
+	return node
+}
+
+func (t *Tree) parseBareVar() (node Node, err error) {
+	pos := t.scanner.tokenPos
+	defer func() {
+		setSpan(node, pos, t.scanner.offset())
+	}()
+
 	t.scanner.accept = acceptIdent
 	t.scanner.mode = scanIdent
 
@@ -131,38 +373,154 @@ func (t *Tree) parseBareVar() (Node, error) {
 		return nil, ErrParseVariableName
 	}
 
-	node := newFuncNode(name)
-	_, err := node.buf.Write([]byte("$" + name))
-	if err != nil {
-		return nil, err
+	n := newFuncNode(name)
+	n.bare = true
+	return n, nil
+}
+
+func (t *Tree) parseFunc() (node Node, err error) {
+	pos := t.scanner.tokenPos
+	defer func() {
+		setSpan(node, pos, t.scanner.offset())
+	}()
+
+	limit := t.MaxNestingDepth
+	if limit <= 0 {
+		limit = DefaultMaxNestingDepth
+	}
+	if t.depth >= limit {
+		return nil, ErrNestingTooDeep
 	}
+	t.depth++
+	defer func() { t.depth-- }()
 
-	return node, nil
-}
+	if t.Comments && strings.HasPrefix(t.scanner.buf[t.scanner.pos:], commentMarker) {
+		return t.parseComment()
+	}
 
-func (t *Tree) parseFunc() (Node, error) {
 	// Turn on all escape characters
-	t.scanner.escapeChars = escapeAll
+	t.scanner.escapeChars = t.escapes(escapeAll)
 	switch t.scanner.peek() {
 	case '#':
 		return t.parseLenFunc()
+	case '!':
+		return t.parseIndicesFunc()
+	case '|':
+		return t.parseBareCallFunc()
 	}
 
-	var name string
+	name, ok := t.scanName()
+	if !ok {
+		return nil, ErrParseVariableName
+	}
+
+	if t.isNamespace(name) {
+		return t.parseNamespace(name)
+	}
+
+	if t.scanner.peek() == '[' {
+		index, err := t.parseIndex()
+		if err != nil {
+			return nil, err
+		}
+		node, err := t.parseOperator(name)
+		if err != nil {
+			return nil, err
+		}
+		fn := node.(*FuncNode)
+		fn.Param = name
+		fn.Index = index
+		return fn, nil
+	}
+
+	return t.parseOperator(name)
+}
+
+// scanName scans the name of a variable within braces. With DottedNames
+// the name may be made of segments joined by dots, none of them empty.
+func (t *Tree) scanName() (string, bool) {
 	t.scanner.accept = acceptIdent
+	if t.DottedNames {
+		t.scanner.accept = acceptName
+	}
 	t.scanner.mode = scanIdent
+	if t.scanner.scan() != tokenIdent {
+		return "", false
+	}
+	name := t.scanner.string()
+	if strings.Contains(name, "..") || strings.HasSuffix(name, ".") {
+		return "", false
+	}
+	return name, true
+}
 
-	switch t.scanner.scan() {
-	case tokenIdent:
-		name = t.scanner.string()
-	default:
+// isNamespace reports whether name is a registered namespace followed by
+// a colon and the start of a variable name.
+func (t *Tree) isNamespace(name string) bool {
+	rest := t.scanner.buf[t.scanner.pos:]
+	if t.Namespace == nil || len(rest) < 2 || rest[0] != ':' {
+		return false
+	}
+	c := rest[1]
+	return (c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && t.Namespace(name)
+}
+
+// parseNamespace parses the variable following a namespace and its colon,
+// and the operator that follows the variable.
+func (t *Tree) parseNamespace(namespace string) (Node, error) {
+	t.scanner.read()
+
+	name, ok := t.scanName()
+	if !ok {
 		return nil, ErrParseVariableName
 	}
 
+	node, err := t.parseOperator(name)
+	if err != nil {
+		return nil, err
+	}
+	fn := node.(*FuncNode)
+	fn.Namespace = namespace
+	return fn, nil
+}
+
+// commentMarker starts a comment, ${//! text}.
+const commentMarker = "//!"
+
+// parseComment parses a comment up to the brace that closes it.
+func (t *Tree) parseComment() (Node, error) {
+	t.scanner.pos += len(commentMarker)
+	start := t.scanner.pos
+	for {
+		switch t.scanner.read() {
+		case '}':
+			return &CommentNode{Text: t.scanner.buf[start : t.scanner.pos-1]}, nil
+		case eof:
+			if t.RecoverUnterminated {
+				return &CommentNode{Text: t.scanner.buf[start:]}, nil
+			}
+			return nil, ErrMissingClosingBrace
+		}
+	}
+}
+
+// parseOperator parses the remainder of a substitution following the
+// variable name.
+func (t *Tree) parseOperator(name string) (Node, error) {
+	if t.scanner.peek() == '|' {
+		fn, err := t.parseAlias()
+		if err != nil {
+			return nil, err
+		}
+		if fn != "" {
+			return t.parseCallFunc(name, fn)
+		}
+	}
+
 	switch t.scanner.peek() {
 	case ':':
 		return t.parseDefaultOrSubstr(name)
-	case '=':
+	case '=', '-', '?', '+':
 		return t.parseDefaultFunc(name)
 	case ',', '^':
 		return t.parseCasingFunc(name)
@@ -172,36 +530,106 @@ func (t *Tree) parseFunc() (Node, error) {
 		return t.parseRemoveFunc(name, acceptHashFunc)
 	case '%':
 		return t.parseRemoveFunc(name, acceptPercentFunc)
+	case '@':
+		return t.parseTransformFunc(name)
 	}
 
 	// trivial case: ${var}
 
+	if t.unterminated() {
+		return newFuncNode(name), nil
+	}
 	t.scanner.accept = acceptIdent
 	t.scanner.mode = scanRbrack | scanIdent | scanLbrack | scanEscape
 	switch t.scanner.scan() {
 	case tokenRbrack:
-		node := newFuncNode(name)
-		_, err := node.buf.Write([]byte("${" + name + "}"))
-		if err != nil {
-			return nil, err
-		}
-		return node, nil
+		return newFuncNode(name), nil
 	default:
 		return nil, ErrMissingClosingBrace
 	}
 }
 
+// parseBareCallFunc parses a function called without a variable, e.g.
+// ${|coalesce:a:b}. Operator aliases need a variable.
+func (t *Tree) parseBareCallFunc() (Node, error) {
+	node, err := t.parseOperator("")
+	if err != nil {
+		return nil, err
+	}
+	if fn, ok := node.(*FuncNode); !ok || !strings.HasPrefix(fn.Name, "|") {
+		return nil, ErrParseVariableName
+	}
+	return node, nil
+}
+
+// parseIndex parses the subscript of an array reference, e.g. [@] in
+// ${arr[@]}, and returns the index within the brackets.
+func (t *Tree) parseIndex() (string, error) {
+	t.scanner.read() // [
+
+	t.scanner.accept = acceptIndex
+	t.scanner.mode = scanIdent
+	if t.scanner.scan() != tokenIdent {
+		return "", ErrBadSubstitution
+	}
+	index := t.scanner.string()
+	if index != "@" && index != "*" && strings.Trim(index, "0123456789") != "" {
+		return "", ErrBadSubstitution
+	}
+
+	if t.scanner.read() != ']' {
+		return "", ErrBadSubstitution
+	}
+	return index, nil
+}
+
+// parseAlias replaces an operator alias, and the colon that may follow it,
+// with the canonical operator so that it is parsed as if the canonical
+// operator had been written. If the name is a registered function instead,
+// it is consumed and returned, e.g. |name.
+func (t *Tree) parseAlias() (fn string, err error) {
+	t.scanner.accept = acceptAlias
+	t.scanner.mode = scanIdent
+	if t.scanner.scan() != tokenIdent {
+		return "", ErrBadSubstitution
+	}
+	start := t.scanner.start
+	alias := t.scanner.string()
+
+	if t.Alias != nil {
+		if canonical, ok := t.Alias(alias); ok {
+			if t.scanner.peek() == ':' {
+				t.scanner.read()
+			}
+			t.scanner.replace(start, canonical)
+			return "", nil
+		}
+	}
+	if t.Func != nil && t.Func(alias[1:]) {
+		return alias, nil
+	}
+	return "", ErrBadSubstitution
+}
+
 // parse a substitution function parameter.
 func (t *Tree) parseParam(accept acceptFunc, mode byte) (Node, error) {
 	t.scanner.accept = accept
-	t.scanner.mode = mode | scanLbrack
+	t.scanner.mode = mode | scanLbrack | scanCommand
 	switch t.scanner.scan() {
 	case tokenLbrack:
 		return t.parseFunc()
 	case tokenBarevar:
 		return t.parseBareVar()
+	case tokenCommand:
+		node := newCommandNode(t.scanner.command())
+		node.Pos, node.End = t.scanner.span()
+		return node, nil
 	case tokenDoubleDollar:
-		left := newTextNode("$")
+		left := t.parseDoubleDollar()
+		if t.DisableDollarEscape {
+			// both dollar signs are consumed, so the argument may end here
+			return left, nil
+		}
 
 		right, err := t.parseParam(accept, mode)
 		switch {
@@ -211,15 +639,13 @@ func (t *Tree) parseParam(accept acceptFunc, mode byte) (Node, error) {
 			return left, nil
 		}
 		return newListNode(left, right), nil
-	case tokenIdent:
+	case tokenIdent, tokenRbrack:
 		// TODO maybe add a } here?
-		return newTextNode(
+		node := newTextNode(
 			t.scanner.string(),
-		), nil
-	case tokenRbrack:
-		return newTextNode(
-			t.scanner.string(),
-		), nil
+		)
+		node.Pos, node.End = t.scanner.span()
+		return node, nil
 	default:
 		return nil, ErrParseFuncSubstitution
 	}
@@ -252,6 +678,16 @@ func (f *nodeFormatter) getFormat(node Node) {
 		}
 	case *FuncNode:
 		f.buf.WriteString(n.String())
+	case *CommandNode:
+		f.buf.WriteString("$(" + n.Command + ")")
+	case *CommentNode:
+		f.buf.WriteString("${" + commentMarker + n.Text + "}")
+	case *IfNode:
+		f.buf.WriteString("${if:" + n.Cond.Param + "}" + FormatNode(n.Then))
+		if n.Else != nil {
+			f.buf.WriteString("${else}" + FormatNode(n.Else))
+		}
+		f.buf.WriteString("${endif}")
 	}
 }
 
@@ -266,24 +702,22 @@ func FormatNode(node Node) string {
 func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 	node := new(FuncNode)
 	node.Param = name
-	_, err := node.buf.WriteString("${" + name)
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = acceptOneColon
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
-		if err != nil {
-			return nil, err
-		}
+		node.Name = t.scanner.string()
 	default:
 		return nil, ErrBadSubstitution
 	}
+	pos := t.scanner.tokenPos
+	if t.unterminated() {
+		return newFuncNode(name), nil
+	}
+	if t.scanner.peek() == '}' {
+		return nil, checkArity(node, pos)
+	}
 
 	// scan arg[1]
 	{
@@ -292,11 +726,6 @@ func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 			return nil, err
 		}
 
-		_, err = node.buf.WriteString(FormatNode(param))
-		if err != nil {
-			return nil, err
-		}
-
 		switch n := param.(type) {
 		case *FuncNode:
 			n.nesting = node.nesting + 1
@@ -308,30 +737,23 @@ func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 	}
 
 	// expect delimiter or close
+	if t.unterminated() {
+		return node, nil
+	}
 	t.scanner.accept = acceptColon
 	t.scanner.mode = scanIdent | scanRbrack
 	switch t.scanner.scan() {
 	case tokenRbrack:
 		t.scanner.unread()
-		return node, t.consumeRbrack(node)
+		return node, t.consumeRbrack()
 	case tokenIdent:
-		delimiter := t.scanner.string()
-		_, err := node.buf.WriteString(delimiter)
-		if err != nil {
-			return nil, err
-		}
 	default:
 		return nil, ErrBadSubstitution
 	}
 
-	// scan arg[2]
-	{
-		param, err := t.parseParam(acceptNotClosing, scanIdent)
-		if err != nil {
-			return nil, err
-		}
-
-		_, err = node.buf.WriteString(FormatNode(param))
+	// scan arg[2], and any further arguments so they can be reported
+	for !t.unterminated() {
+		param, err := t.parseParam(rejectColonClose, scanIdent)
 		if err != nil {
 			return nil, err
 		}
@@ -344,9 +766,17 @@ func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 		default:
 			node.Args = append(node.Args, param)
 		}
+
+		if t.scanner.peek() != ':' {
+			break
+		}
+		t.scanner.read()
+	}
+	if err := checkArity(node, pos); err != nil {
+		return nil, err
 	}
 
-	return node, t.consumeRbrack(node)
+	return node, t.consumeRbrack()
 }
 
 // parses the ${param%word} string function
@@ -356,37 +786,32 @@ func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (Node, error) {
 	node := new(FuncNode)
 	node.Param = name
-	_, err := node.buf.WriteString("${" + name)
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = accept
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
-		if err != nil {
-			return nil, err
-		}
+		node.Name = t.scanner.string()
 	default:
 		return nil, ErrBadSubstitution
 	}
+	if t.unterminated() {
+		return newFuncNode(name), nil
+	}
+	// an empty pattern removes nothing, as in ${param#}
+	if t.scanner.peek() == '}' {
+		node.Args = append(node.Args, newTextNode(""))
+		return node, t.consumeRbrack()
+	}
 
-	// scan arg[1]
+	// scan arg[1], whose backslashes are left for the pattern to escape
+	// its own characters, e.g. ${param%\\} strips a trailing backslash
 	{
 		param, err := t.parseParam(acceptNotClosing, scanIdent)
 		if err != nil {
 			return nil, err
 		}
 
-		_, err = node.buf.WriteString(FormatNode(param))
-		if err != nil {
-			return nil, err
-		}
-
 		switch n := param.(type) {
 		case *FuncNode:
 			n.nesting = node.nesting + 1
@@ -397,7 +822,7 @@ func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (Node, error) {
 		}
 	}
 
-	return node, t.consumeRbrack(node)
+	return node, t.consumeRbrack()
 }
 
 // parses the ${param/pattern/string} string function
@@ -407,33 +832,29 @@ func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (Node, error) {
 func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 	node := new(FuncNode)
 	node.Param = name
-	_, err := node.buf.WriteString("${" + name)
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = acceptReplaceFunc
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
-		if err != nil {
-			return nil, err
-		}
+		node.Name = t.scanner.string()
 	default:
 		return nil, ErrBadSubstitution
 	}
+	if t.unterminated() {
+		return newFuncNode(name), nil
+	}
+	if t.scanner.peek() == '}' {
+		return nil, checkArity(node, t.scanner.tokenPos)
+	}
 
-	// scan arg[1]
-	{
-		param, err := t.parseParam(acceptNotSlash, scanIdent|scanEscape)
-		if err != nil {
-			return nil, err
-		}
-
-		_, err = node.buf.WriteString(FormatNode(param))
+	// scan arg[1], which may be empty, as in ${param/#/prefix} and
+	// ${param/%/suffix}. An escaped backslash is kept as it is, so that
+	// the pattern matches a literal backslash.
+	if t.scanner.peek() == '/' {
+		node.Args = append(node.Args, newTextNode(""))
+	} else {
+		param, err := t.parseParam(rejectSlashClose, scanIdent|scanEscape|scanPattern)
 		if err != nil {
 			return nil, err
 		}
@@ -448,24 +869,24 @@ func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 		}
 	}
 
-	// expect delimiter or close
+	// ${param/pattern} removes the match
+	if t.scanner.peek() == '}' || t.unterminated() {
+		return node, t.consumeRbrack()
+	}
+
+	// expect delimiter
 	t.scanner.accept = acceptSlash
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		delimiter := t.scanner.string()
-		_, err := node.buf.WriteString(delimiter)
-		if err != nil {
-			return nil, err
-		}
 	default:
 		return nil, ErrBadSubstitution
 	}
 
 	// check for blank string
-	switch t.scanner.peek() {
-	case '}':
-		return node, t.consumeRbrack(node)
+	if t.scanner.peek() == '}' || t.unterminated() {
+		node.Args = append(node.Args, newTextNode(""))
+		return node, t.consumeRbrack()
 	}
 
 	// scan arg[2]
@@ -475,11 +896,6 @@ func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 			return nil, err
 		}
 
-		_, err = node.buf.WriteString(FormatNode(param))
-		if err != nil {
-			return nil, err
-		}
-
 		switch n := param.(type) {
 		case *FuncNode:
 			n.nesting = node.nesting + 1
@@ -490,10 +906,13 @@ func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 		}
 	}
 
-	return node, t.consumeRbrack(node)
+	return node, t.consumeRbrack()
 }
 
 // parses the ${parameter=word} string function
+// parses the ${parameter-word} string function
+// parses the ${parameter?word} string function
+// parses the ${parameter+word} string function
 // parses the ${parameter:=word} string function
 // parses the ${parameter:-word} string function
 // parses the ${parameter:?word} string function
@@ -501,24 +920,15 @@ func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 func (t *Tree) parseDefaultFunc(name string) (Node, error) {
 	node := new(FuncNode)
 	node.Param = name
-	_, err := node.buf.WriteString("${" + name)
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = acceptDefaultFunc
-	if t.scanner.peek() == '=' {
-		t.scanner.accept = acceptOneEqual
+	if t.scanner.peek() != ':' {
+		t.scanner.accept = acceptOneDefault
 	}
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
-		if err != nil {
-			return nil, err
-		}
+		node.Name = t.scanner.string()
 	default:
 		return nil, ErrParseDefaultFunction
 	}
@@ -526,16 +936,10 @@ func (t *Tree) parseDefaultFunc(name string) (Node, error) {
 	// loop through all possible runes in default param
 	for {
 		// this acts as the break condition. Peek to see if we reached the end
-		switch t.scanner.peek() {
-		case '}':
-			return node, t.consumeRbrack(node)
-		}
-		param, err := t.parseParam(acceptNotClosing, scanIdent)
-		if err != nil {
-			return nil, err
+		if t.scanner.peek() == '}' || t.unterminated() {
+			return node, t.consumeRbrack()
 		}
-
-		_, err = node.buf.WriteString(FormatNode(param))
+		param, err := t.parseParam(acceptNotClosing, scanIdent|scanEscape)
 		if err != nil {
 			return nil, err
 		}
@@ -551,6 +955,127 @@ func (t *Tree) parseDefaultFunc(name string) (Node, error) {
 	}
 }
 
+// formatArg returns the text of an operator argument, escaping its text so
+// that it parses as the same argument.
+func formatArg(node Node, special string) string {
+	switch n := node.(type) {
+	case *ListNode:
+		var b strings.Builder
+		for _, item := range n.Nodes {
+			b.WriteString(formatArg(item, special))
+		}
+		return b.String()
+	case *TextNode:
+		return escapeText(n.Value, special)
+	}
+	return FormatNode(node)
+}
+
+// formatPattern returns the text of the pattern of a replace operator,
+// escaping its slashes and closing braces. Its backslashes are written as
+// they are, since the pattern keeps escaped backslashes.
+func formatPattern(node Node) string {
+	switch n := node.(type) {
+	case *ListNode:
+		var b strings.Builder
+		for _, item := range n.Nodes {
+			b.WriteString(formatPattern(item))
+		}
+		return b.String()
+	case *TextNode:
+		var b strings.Builder
+		for _, r := range n.Value {
+			if r == '/' || r == '}' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return FormatNode(node)
+}
+
+// escapeText escapes the characters of text that are in special, and the
+// backslashes that would otherwise escape the character that follows them.
+func escapeText(text, special string) string {
+	var b strings.Builder
+	for i, r := range text {
+		switch {
+		case strings.ContainsRune(special, r):
+			b.WriteByte('\\')
+		case r == '\\':
+			rest := text[i+1:]
+			if rest == "" || strings.ContainsRune(`\/}`, rune(rest[0])) {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// parses the ${param|func} string function
+// parses the ${param|func:arg1:arg2...} string function
+func (t *Tree) parseCallFunc(name, fn string) (Node, error) {
+	node := new(FuncNode)
+	node.Param = name
+	node.Name = fn
+
+	// each argument follows a colon and may be made up of several nodes,
+	// e.g. prefix-${var}, or none at all
+	for t.scanner.peek() == ':' {
+		t.scanner.read()
+
+		var nodes []Node
+		for t.scanner.peek() != ':' && t.scanner.peek() != '}' && !t.unterminated() {
+			param, err := t.parseParam(rejectColonClose, scanIdent|scanEscape)
+			if err != nil {
+				return nil, err
+			}
+
+			if n, ok := param.(*FuncNode); ok {
+				n.nesting = node.nesting + 1
+			}
+			nodes = append(nodes, param)
+		}
+
+		switch len(nodes) {
+		case 0:
+			node.Args = append(node.Args, newTextNode(""))
+		case 1:
+			node.Args = append(node.Args, nodes[0])
+		default:
+			node.Args = append(node.Args, newListNode(nodes...))
+		}
+	}
+
+	return node, t.consumeRbrack()
+}
+
+// parses the ${param@Q} string function
+// parses the ${param@docker} string function
+// parses the ${param@K} string function
+func (t *Tree) parseTransformFunc(name string) (Node, error) {
+	node := new(FuncNode)
+	node.Param = name
+
+	t.scanner.accept = acceptTransformFunc
+	t.scanner.mode = scanIdent
+	switch t.scanner.scan() {
+	case tokenIdent:
+		node.Name = t.scanner.string()
+	default:
+		return nil, ErrBadSubstitution
+	}
+	switch node.Name {
+	case "@Q", "@docker", "@K":
+	default:
+		return nil, ErrBadSubstitution
+	}
+
+	return node, t.consumeRbrack()
+}
+
 // parses the ${param,} string function
 // parses the ${param,,} string function
 // parses the ${param^} string function
@@ -558,75 +1083,125 @@ func (t *Tree) parseDefaultFunc(name string) (Node, error) {
 func (t *Tree) parseCasingFunc(name string) (Node, error) {
 	node := new(FuncNode)
 	node.Param = name
-	_, err := node.buf.WriteString("${" + name)
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = acceptCasingFunc
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
+		node.Name = t.scanner.string()
+	default:
+		return nil, ErrBadSubstitution
+	}
+	pos := t.scanner.tokenPos
+
+	// casing patterns, ${param^^pattern}, are not supported, so any
+	// arguments are reported as too many
+	for t.scanner.peek() != '}' && !t.unterminated() {
+		param, err := t.parseParam(acceptNotClosing, scanIdent)
 		if err != nil {
 			return nil, err
 		}
-	default:
-		return nil, ErrBadSubstitution
+		node.Args = append(node.Args, param)
+	}
+	if err := checkArity(node, pos); err != nil {
+		return nil, err
 	}
 
-	return node, t.consumeRbrack(node)
+	return node, t.consumeRbrack()
 }
 
 // parses the ${#param} string function
+// parses the ${#${param}} string function
 func (t *Tree) parseLenFunc() (Node, error) {
 	node := new(FuncNode)
-	_, err := node.buf.WriteString("${")
-	if err != nil {
-		return nil, err
-	}
 
 	t.scanner.accept = acceptOneHash
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeName := t.scanner.string()
-		node.Name = nodeName
-		_, err := node.buf.WriteString(nodeName)
+		node.Name = t.scanner.string()
+	default:
+		return nil, ErrBadSubstitution
+	}
+
+	// the length of a nested expansion, ${#${param}}, has no param and
+	// the expansion as its only argument
+	if strings.HasPrefix(t.scanner.buf[t.scanner.pos:], string(t.scanner.sigil)+"{") {
+		t.scanner.mode = scanLbrack
+		t.scanner.scan()
+		arg, err := t.parseFunc()
 		if err != nil {
 			return nil, err
 		}
-	default:
+		if n, ok := arg.(*FuncNode); ok {
+			n.nesting = node.nesting + 1
+		}
+		node.Args = append(node.Args, arg)
+		return node, t.consumeRbrack()
+	}
+
+	name, ok := t.scanName()
+	if !ok {
 		return nil, ErrBadSubstitution
 	}
+	node.Param = name
+
+	return node, t.consumeRbrack()
+}
+
+// parses the ${!param[@]} string function
+// parses the ${!param[*]} string function
+// parses the ${!prefix@} string function
+// parses the ${!prefix*} string function
+func (t *Tree) parseIndicesFunc() (Node, error) {
+	node := new(FuncNode)
+	node.Name = "!"
+	t.scanner.read() // !
 
 	t.scanner.accept = acceptIdent
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		nodeParam := t.scanner.string()
-		node.Param = nodeParam
-		_, err := node.buf.WriteString(nodeParam)
-		if err != nil {
-			return nil, err
-		}
+		node.Param = t.scanner.string()
+	default:
+		return nil, ErrParseVariableName
+	}
+
+	switch t.scanner.peek() {
+	case '@', '*':
+		node.Name += string(t.scanner.read())
+		return node, t.consumeRbrack()
+	case '[':
 	default:
 		return nil, ErrBadSubstitution
 	}
+	index, err := t.parseIndex()
+	if err != nil {
+		return nil, err
+	}
+	if index != "@" && index != "*" {
+		return nil, ErrBadSubstitution
+	}
+	node.Index = index
 
-	return node, t.consumeRbrack(node)
+	return node, t.consumeRbrack()
 }
 
 // consumeRbrack consumes a right closing bracket. If a closing
 // bracket token is not consumed an ErrBadSubstitution is returned.
-func (t *Tree) consumeRbrack(node *FuncNode) error {
+func (t *Tree) consumeRbrack() error {
+	if t.unterminated() {
+		return nil
+	}
 	t.scanner.mode = scanRbrack
 	if t.scanner.scan() != tokenRbrack {
 		return ErrBadSubstitution
 	}
-	_, err := node.buf.Write([]byte("}"))
+	return nil
+}
 
-	return err
+// unterminated reports whether the input has ended within a substitution
+// that is to be recovered rather than reported as a syntax error.
+func (t *Tree) unterminated() bool {
+	return t.RecoverUnterminated && t.scanner.peek() == eof
 }
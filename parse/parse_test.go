@@ -1,16 +1,14 @@
 package parse
 
 import (
-	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func buf(s string) bytes.Buffer {
-	return *bytes.NewBuffer([]byte(s))
-}
-
 var tests = []struct {
 	Text string
 	Node Node
@@ -35,14 +33,13 @@ var tests = []struct {
 		Text: "$${string}",
 		Node: &ListNode{Nodes: []Node{&TextNode{Value: "$"}, &FuncNode{
 			Param: "string",
-			buf: buf("${string}"),
 		}}},
 	},
 	{
 		Text: "$$string",
 		Node: &ListNode{Nodes: []Node{&TextNode{Value: "$"}, &FuncNode{
 			Param: "string",
-			buf: buf("$string"),
+			bare:  true,
 		}}},
 	},
 	{
@@ -50,12 +47,67 @@ var tests = []struct {
 		Node: &TextNode{Value: `\\.\pipe\pipename`},
 	},
 
+	//
+	// braces outside of an expansion are literal
+	//
+	{
+		Text: "a{b}c",
+		Node: &TextNode{Value: "a{b}c"},
+	},
+	{
+		Text: "${x}}extra",
+		Node: &ListNode{Nodes: []Node{
+			&FuncNode{Param: "x"},
+			&TextNode{Value: "}extra"},
+		}},
+	},
+	{
+		Text: "pre{${x}}post",
+		Node: &ListNode{Nodes: []Node{
+			&TextNode{Value: "pre{"},
+			&ListNode{Nodes: []Node{
+				&FuncNode{Param: "x"},
+				&TextNode{Value: "}post"},
+			}},
+		}},
+	},
+
+	//
+	// command substitution
+	//
+	{
+		Text: "$(date)",
+		Node: &CommandNode{Command: "date"},
+	},
+	{
+		Text: "$()",
+		Node: &CommandNode{Command: ""},
+	},
+	{
+		Text: "$(a $(b) c)",
+		Node: &CommandNode{Command: "a $(b) c"},
+	},
+	{
+		Text: "$(echo $HOME ${var}) ${var}",
+		Node: &ListNode{Nodes: []Node{
+			&CommandNode{Command: "echo $HOME ${var}"},
+			&ListNode{Nodes: []Node{
+				&TextNode{Value: " "},
+				&FuncNode{Param: "var"},
+			}},
+		}},
+	},
+	{
+		Text: "$(unterminated",
+		Node: &TextNode{Value: "$(unterminated"},
+	},
+
 	//
 	// variable only
 	//
 	{
 		Text: "${string}",
-		Node: &FuncNode{Param: "string", buf: buf("${string}")},
+		Node: &FuncNode{Param: "string"},
 	},
 
 	//
@@ -67,7 +119,6 @@ var tests = []struct {
 			Param: "string",
 			Name:  ",",
 			Args:  nil,
-			buf: buf("${string,}"),
 		},
 	},
 	{
@@ -76,7 +127,6 @@ var tests = []struct {
 			Param: "string",
 			Name:  ",,",
 			Args:  nil,
-			buf: buf("${string,,}"),
 		},
 	},
 	{
@@ -85,7 +135,6 @@ var tests = []struct {
 			Param: "string",
 			Name:  "^",
 			Args:  nil,
-			buf: buf("${string^}"),
 		},
 	},
 	{
@@ -94,7 +143,6 @@ var tests = []struct {
 			Param: "string",
 			Name:  "^^",
 			Args:  nil,
-			buf: buf("${string^^}"),
 		},
 	},
 
@@ -109,7 +157,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "position"},
 			},
-			buf: buf("${string:position}"),
 		},
 	},
 	{
@@ -121,7 +168,6 @@ var tests = []struct {
 				&TextNode{Value: "position"},
 				&TextNode{Value: "length"},
 			},
-			buf: buf("${string:position:length}"),
 		},
 	},
 
@@ -136,7 +182,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "substring"},
 			},
-			buf: buf("${string#substring}"),
 		},
 	},
 	{
@@ -147,7 +192,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "substring"},
 			},
-			buf: buf("${string##substring}"),
 		},
 	},
 	{
@@ -158,7 +202,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "substring"},
 			},
-			buf: buf("${string%substring}"),
 		},
 	},
 	{
@@ -169,7 +212,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "substring"},
 			},
-			buf: buf("${string%%substring}"),
 		},
 	},
 
@@ -185,7 +227,6 @@ var tests = []struct {
 				&TextNode{Value: "substring"},
 				&TextNode{Value: "replacement"},
 			},
-			buf: buf("${string/substring/replacement}"),
 		},
 	},
 	{
@@ -197,7 +238,6 @@ var tests = []struct {
 				&TextNode{Value: "substring"},
 				&TextNode{Value: "replacement"},
 			},
-			buf: buf("${string//substring/replacement}"),
 		},
 	},
 	{
@@ -209,7 +249,6 @@ var tests = []struct {
 				&TextNode{Value: "substring"},
 				&TextNode{Value: "replacement"},
 			},
-			buf: buf("${string/#substring/replacement}"),
 		},
 	},
 	{
@@ -221,7 +260,28 @@ var tests = []struct {
 				&TextNode{Value: "substring"},
 				&TextNode{Value: "replacement"},
 			},
-			buf: buf("${string/%substring/replacement}"),
+		},
+	},
+	{
+		Text: "${string/#/prefix}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  "/#",
+			Args: []Node{
+				&TextNode{Value: ""},
+				&TextNode{Value: "prefix"},
+			},
+		},
+	},
+	{
+		Text: "${string/%/${suffix}}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  "/%",
+			Args: []Node{
+				&TextNode{Value: ""},
+				&FuncNode{Param: "suffix", nesting: 1},
+			},
 		},
 	},
 
@@ -236,7 +296,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "default"},
 			},
-			buf: buf("${string=default}"),
 		},
 	},
 	{
@@ -247,7 +306,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "default"},
 			},
-			buf: buf("${string:=default}"),
 		},
 	},
 	{
@@ -258,7 +316,18 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "default"},
 			},
-			buf: buf("${string:-default}"),
+		},
+	},
+	{
+		// adjacent expansions are separate arguments, joined on evaluation
+		Text: "${string:-${first}${second}}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  ":-",
+			Args: []Node{
+				&FuncNode{Param: "first", nesting: 1},
+				&FuncNode{Param: "second", nesting: 1},
+			},
 		},
 	},
 	{
@@ -269,7 +338,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "default"},
 			},
-			buf: buf("${string:?default}"),
 		},
 	},
 	{
@@ -280,7 +348,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "default"},
 			},
-			buf: buf("${string:+default}"),
 		},
 	},
 
@@ -292,7 +359,20 @@ var tests = []struct {
 		Node: &FuncNode{
 			Param: "string",
 			Name:  "#",
-			buf: buf("${#string}"),
+		},
+	},
+	{
+		Text: "${#${string:-abc}}",
+		Node: &FuncNode{
+			Name: "#",
+			Args: []Node{
+				&FuncNode{
+					Param:   "string",
+					Name:    ":-",
+					Args:    []Node{&TextNode{Value: "abc"}},
+					nesting: 1,
+				},
+			},
 		},
 	},
 
@@ -307,7 +387,6 @@ var tests = []struct {
 			Args: []Node{
 				&TextNode{Value: "$%:*{"},
 			},
-			buf: buf("${string#$%:*{}"),
 		},
 	},
 
@@ -324,7 +403,6 @@ var tests = []struct {
 						&FuncNode{
 							Param: "string",
 							Name:  "#",
-							buf: buf("${#string}"),
 						},
 						&TextNode{
 							Value: " world",
@@ -347,7 +425,6 @@ var tests = []struct {
 						&FuncNode{
 							Param: "string",
 							Name:  "#",
-							buf: buf("${#string}"),
 						},
 						&TextNode{
 							Value: ` world \\`,
@@ -358,9 +435,97 @@ var tests = []struct {
 		},
 	},
 
-	// TODO
-	// Tests from here down are broken
+	// escaped default function arguments
+	{
+		Text: `${string:-a\}b}`,
+		Node: &FuncNode{
+			Param: "string",
+			Name:  ":-",
+			Args: []Node{
+				&TextNode{Value: "a}b"},
+			},
+		},
+	},
+	{
+		Text: `${string:-a\\}`,
+		Node: &FuncNode{
+			Param: "string",
+			Name:  ":-",
+			Args: []Node{
+				&TextNode{Value: `a\`},
+			},
+		},
+	},
+
+	// command substitution in default function arguments
+	{
+		Text: "${string:-$(date ${x})}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  ":-",
+			Args: []Node{
+				&CommandNode{Command: "date ${x}"},
+			},
+		},
+	},
+
+	// array references
+	{
+		Text: "${arr[@]}",
+		Node: &FuncNode{
+			Param: "arr",
+			Index: "@",
+		},
+	},
+	{
+		Text: "${arr[1]:-none}",
+		Node: &FuncNode{
+			Param: "arr",
+			Name:  ":-",
+			Index: "1",
+			Args: []Node{
+				&TextNode{Value: "none"},
+			},
+		},
+	},
+	{
+		Text: "${!arr[@]}",
+		Node: &FuncNode{
+			Param: "arr",
+			Name:  "!",
+			Index: "@",
+		},
+	},
+	{
+		Text: "${string@Q}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  "@Q",
+		},
+	},
+	{
+		Text: "${string@K}",
+		Node: &FuncNode{
+			Param: "string",
+			Name:  "@K",
+		},
+	},
+	{
+		Text: "${!prefix*}",
+		Node: &FuncNode{
+			Param: "prefix",
+			Name:  "!*",
+		},
+	},
+	{
+		Text: "${!prefix@}",
+		Node: &FuncNode{
+			Param: "prefix",
+			Name:  "!@",
+		},
+	},
 
+	// TODO
 	// escaped function arguments
 	{
 		Text: `${string/\/position/length}`,
@@ -375,7 +540,6 @@ var tests = []struct {
 					Value: "length",
 				},
 			},
-			buf: buf(`${string/\/position/length}`),
 		},
 	},
 	{
@@ -385,7 +549,8 @@ var tests = []struct {
 			Name:  "/",
 			Args: []Node{
 				&TextNode{
-					Value: "/position\\",
+					// the escaped backslash is kept for the pattern
+					Value: `/position\\`,
 				},
 				&TextNode{
 					Value: "length",
@@ -447,7 +612,8 @@ var tests = []struct {
 			Name:  ":",
 			Args: []Node{
 				&FuncNode{
-					Param: "position",
+					Param:   "position",
+					nesting: 1,
 				},
 			},
 		},
@@ -459,16 +625,18 @@ var tests = []struct {
 			Name:  ":",
 			Args: []Node{
 				&FuncNode{
-					Param: "stringy",
-					Name:  ":",
+					Param:   "stringy",
+					Name:    ":",
+					nesting: 1,
 					Args: []Node{
 						&TextNode{Value: "position"},
 						&TextNode{Value: "length"},
 					},
 				},
 				&FuncNode{
-					Param: "stringz",
-					Name:  ",,",
+					Param:   "stringz",
+					Name:    ",,",
+					nesting: 1,
 				},
 			},
 		},
@@ -479,7 +647,7 @@ var tests = []struct {
 			Param: "string",
 			Name:  "#",
 			Args: []Node{
-				&FuncNode{Param: "stringz"},
+				&FuncNode{Param: "stringz", nesting: 1},
 			},
 		},
 	},
@@ -489,7 +657,7 @@ var tests = []struct {
 			Param: "string",
 			Name:  "=",
 			Args: []Node{
-				&FuncNode{Param: "stringz"},
+				&FuncNode{Param: "stringz", nesting: 1},
 			},
 		},
 	},
@@ -500,7 +668,7 @@ var tests = []struct {
 			Name:  "=",
 			Args: []Node{
 				&TextNode{Value: "prefix-"},
-				&FuncNode{Param: "var"},
+				&FuncNode{Param: "var", nesting: 1},
 			},
 		},
 	},
@@ -510,7 +678,7 @@ var tests = []struct {
 			Param: "string",
 			Name:  "=",
 			Args: []Node{
-				&FuncNode{Param: "var"},
+				&FuncNode{Param: "var", nesting: 1},
 				&TextNode{Value: "-suffix"},
 			},
 		},
@@ -522,7 +690,7 @@ var tests = []struct {
 			Name:  "=",
 			Args: []Node{
 				&TextNode{Value: "prefix-"},
-				&FuncNode{Param: "var"},
+				&FuncNode{Param: "var", nesting: 1},
 				&TextNode{Value: "-suffix"},
 			},
 		},
@@ -534,7 +702,7 @@ var tests = []struct {
 			Name:  "=",
 			Args: []Node{
 				&TextNode{Value: "prefix"},
-				&FuncNode{Param: "var"},
+				&FuncNode{Param: "var", nesting: 1},
 				&TextNode{Value: " suffix"},
 			},
 		},
@@ -545,10 +713,9 @@ var tests = []struct {
 			Param: "string",
 			Name:  "//",
 			Args: []Node{
-				&FuncNode{Param: "stringy"},
-				&FuncNode{Param: "stringz"},
+				&FuncNode{Param: "stringy", nesting: 1},
+				&FuncNode{Param: "stringz", nesting: 1},
 			},
-			buf: buf("${string//${stringy}/${stringz}}"),
 		},
 	},
 }
@@ -561,7 +728,698 @@ func TestParse(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			clearSpans(got.Root)
+			assert.Equal(t, test.Node, got.Root)
+		})
+	}
+}
+
+// clearSpans zeroes the input offsets of the node and its children so that
+// trees can be compared by structure alone.
+func clearSpans(node Node) {
+	switch n := node.(type) {
+	case *ListNode:
+		for _, item := range n.Nodes {
+			clearSpans(item)
+		}
+	case *FuncNode:
+		for _, arg := range n.Args {
+			clearSpans(arg)
+		}
+	case *IfNode:
+		clearSpans(n.Cond)
+		clearSpans(n.Then)
+		if n.Else != nil {
+			clearSpans(n.Else)
+		}
+	}
+	setSpan(node, 0, 0)
+}
+
+func TestParseSpans(t *testing.T) {
+	text := `a ${b:-$c} $(d) $$ ${e/\//x}`
+	got, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spans []string
+	var walk func(Node)
+	walk = func(node Node) {
+		switch n := node.(type) {
+		case *ListNode:
+			for _, item := range n.Nodes {
+				walk(item)
+			}
+			return
+		case *FuncNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+		pos, end := Span(node)
+		spans = append(spans, text[pos:end])
+	}
+	walk(got.Root)
+
+	assert.Equal(t, []string{
+		"a ",
+		"$c", "${b:-$c}", " ",
+		"$(d)", " ",
+		"$", "$ ",
+		`\/`, "x", `${e/\//x}`,
+	}, spans)
+}
+
+func TestFormatNode(t *testing.T) {
+	tests := []struct {
+		Node Node
+		Text string
+	}{
+		{&FuncNode{Param: "x"}, "${x}"},
+		{&FuncNode{Param: "x", bare: true}, "$x"},
+		{&FuncNode{Param: "x", Name: ",,"}, "${x,,}"},
+		{&FuncNode{Param: "x", Name: "#"}, "${#x}"},
+		{&FuncNode{Param: "x", Name: "##", Args: []Node{&TextNode{Value: "*/"}}}, "${x##*/}"},
+		{&FuncNode{Param: "x", Name: "%", Args: []Node{&TextNode{Value: `\\`}}}, `${x%\\}`},
+		{&FuncNode{Param: "x", Name: ":", Args: []Node{&TextNode{Value: "1"}, &TextNode{Value: "2"}}}, "${x:1:2}"},
+		{&FuncNode{Param: "x", Name: "/", Args: []Node{&TextNode{Value: "a/b"}, &TextNode{Value: "c/d}"}}}, `${x/a\/b/c\/d\}}`},
+		{&FuncNode{Param: "x", Name: "//", Args: []Node{&TextNode{Value: "a"}}}, "${x//a}"},
+		{&FuncNode{Param: "x", Name: "/#", Args: []Node{&TextNode{Value: "a"}, &TextNode{Value: ""}}}, "${x/#a/}"},
+		{&FuncNode{Param: "x", Name: "/%", Args: []Node{&TextNode{Value: ""}, &TextNode{Value: "a"}}}, "${x/%/a}"},
+		{&FuncNode{Param: "x", Name: ":-", Args: []Node{&TextNode{Value: `a\`}}}, `${x:-a\\}`},
+		{&FuncNode{Param: "x", Name: ":-", Args: []Node{&TextNode{Value: "a-"}, &FuncNode{Param: "y"}}}, "${x:-a-${y}}"},
+		{&FuncNode{Param: "x", Name: ":+", Args: []Node{&CommandNode{Command: "date"}}}, "${x:+$(date)}"},
+		{&FuncNode{Param: "arr", Index: "@"}, "${arr[@]}"},
+		{&FuncNode{Param: "arr", Name: "!", Index: "*"}, "${!arr[*]}"},
+		{&FuncNode{Param: "app_", Name: "!@"}, "${!app_@}"},
+		{&FuncNode{Param: "x", Name: "|eq", Args: []Node{&TextNode{Value: "a"}, &TextNode{Value: ""}}}, "${x|eq:a:}"},
+		{&ListNode{Nodes: []Node{&TextNode{Value: "a "}, &FuncNode{Param: "b", Name: "^"}}}, "a ${b^}"},
+		{&IfNode{Cond: &FuncNode{Param: "x"}, Then: &TextNode{Value: "a"}, Else: &FuncNode{Param: "y"}}, "${if:x}a${else}${y}${endif}"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Text, FormatNode(test.Node))
+	}
+}
+
+func TestFormatNodeRoundTrip(t *testing.T) {
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			got, err := Parse(FormatNode(test.Node))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clearSpans(got.Root)
 			assert.Equal(t, test.Node, got.Root)
 		})
 	}
 }
+
+func TestParseAlias(t *testing.T) {
+	tree := &Tree{Alias: func(alias string) (string, bool) {
+		return ":-", alias == "|default"
+	}}
+
+	text := "${var|default:x} end"
+	got, err := tree.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := got.Root.(*ListNode)
+	node := list.Nodes[0].(*FuncNode)
+	assert.Equal(t, "var", node.Param)
+	assert.Equal(t, ":-", node.Name)
+	assert.Equal(t, []Node{&TextNode{Value: "x", Pos: 14, End: 15}}, node.Args)
+	assert.Equal(t, "${var|default:x}", text[node.Pos:node.End])
+
+	_, err = tree.Parse("${var|unknown:x}")
+	assert.ErrorIs(t, err, ErrBadSubstitution)
+}
+
+func TestParseBadIndex(t *testing.T) {
+	for _, text := range []string{"${arr[}", "${arr[x]}", "${arr[@1]}", "${!arr}", "${!arr[0]}"} {
+		_, err := Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestParseBadNestedLength(t *testing.T) {
+	// only a single braced expansion may be measured
+	for _, text := range []string{"${#$var}", "${#${var}x}", "${#${var}${other}}", "${#${var}"} {
+		_, err := Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestParseCallFunc(t *testing.T) {
+	tree := &Tree{Func: func(name string) bool {
+		return name == "eq"
+	}}
+
+	text := "${var|eq:a-${b}::c}"
+	got, err := tree.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := got.Root.(*FuncNode)
+	clearSpans(node)
+	assert.Equal(t, "var", node.Param)
+	assert.Equal(t, "|eq", node.Name)
+	assert.Equal(t, []Node{
+		&ListNode{Nodes: []Node{
+			&TextNode{Value: "a-"},
+			&FuncNode{Param: "b", nesting: 1},
+		}},
+		&TextNode{},
+		&TextNode{Value: "c"},
+	}, node.Args)
+	assert.Equal(t, text, node.String())
+
+	_, err = tree.Parse("${var|ne:a}")
+	assert.ErrorIs(t, err, ErrBadSubstitution)
+
+	// a function may be called without a variable
+	text = "${|eq:a:b:c}"
+	got, err = tree.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node = got.Root.(*FuncNode)
+	assert.Equal(t, "", node.Param)
+	assert.Equal(t, "|eq", node.Name)
+	assert.Equal(t, text, node.String())
+
+	tree.Alias = func(alias string) (string, bool) {
+		return ":-", alias == "|default"
+	}
+	_, err = tree.Parse("${|default:x}")
+	assert.ErrorIs(t, err, ErrParseVariableName)
+}
+
+func TestParseNamespace(t *testing.T) {
+	tree := &Tree{Namespace: func(name string) bool {
+		return name == "secret"
+	}}
+
+	for text, want := range map[string]Node{
+		"${secret:DB_PASSWORD}": &FuncNode{Namespace: "secret", Param: "DB_PASSWORD"},
+		"${secret:_x:-def}":     &FuncNode{Namespace: "secret", Param: "_x", Name: ":-", Args: []Node{&TextNode{Value: "def"}}},
+		"${secret:x,,}":         &FuncNode{Namespace: "secret", Param: "x", Name: ",,"},
+		"${secret:-x}":          &FuncNode{Param: "secret", Name: ":-", Args: []Node{&TextNode{Value: "x"}}},
+		"${secret:1}":           &FuncNode{Param: "secret", Name: ":", Args: []Node{&TextNode{Value: "1"}}},
+		"${other:x}":            &FuncNode{Param: "other", Name: ":", Args: []Node{&TextNode{Value: "x"}}},
+	} {
+		got, err := tree.Parse(text)
+		if err != nil {
+			t.Fatal(text, err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, want, got.Root, text)
+		assert.Equal(t, text, FormatNode(got.Root), text)
+	}
+
+	_, err := tree.Parse("${secret:x:y:z}")
+	assert.Nil(t, err)
+	_, err = tree.Parse("${secret:x y}")
+	assert.Error(t, err)
+}
+
+func nested(depth int) string {
+	return strings.Repeat("${a:-", depth) + "x" + strings.Repeat("}", depth)
+}
+
+func TestParseMaxNestingDepth(t *testing.T) {
+	_, err := Parse(nested(DefaultMaxNestingDepth))
+	assert.Nil(t, err)
+
+	_, err = Parse(nested(DefaultMaxNestingDepth + 1))
+	assert.ErrorIs(t, err, ErrNestingTooDeep)
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+
+	tree := &Tree{MaxNestingDepth: 2}
+	_, err = tree.Parse("${a:-${b}} ${c:-${d:-x}}")
+	assert.Nil(t, err)
+	_, err = tree.Parse("${a:-${b:-${c}}}")
+	assert.ErrorIs(t, err, ErrNestingTooDeep)
+}
+
+func TestParseDeepNesting(t *testing.T) {
+	// deeply nested input of random shapes fails cleanly instead of
+	// overflowing the stack
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		depth := DefaultMaxNestingDepth + 1 + r.Intn(100000)
+		var b strings.Builder
+		for j := 0; j < depth; j++ {
+			switch r.Intn(3) {
+			case 0:
+				b.WriteString("${a:-")
+			case 1:
+				b.WriteString("${a/x/")
+			default:
+				b.WriteString("${a#")
+			}
+		}
+		_, err := Parse(b.String())
+		assert.ErrorIs(t, err, ErrNestingTooDeep)
+	}
+}
+
+func TestParseDisableDollarEscape(t *testing.T) {
+	tree := &Tree{DisableDollarEscape: true}
+
+	tests := []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "$$",
+			Node: &TextNode{Value: "$$"},
+		},
+		{
+			Text: "$$string",
+			Node: &ListNode{Nodes: []Node{
+				&TextNode{Value: "$$"},
+				&TextNode{Value: "string"},
+			}},
+		},
+		{
+			Text: "$${string}",
+			Node: &ListNode{Nodes: []Node{
+				&TextNode{Value: "$$"},
+				&TextNode{Value: "{string}"},
+			}},
+		},
+		{
+			Text: "$$$string",
+			Node: &ListNode{Nodes: []Node{
+				&TextNode{Value: "$$"},
+				&FuncNode{Param: "string", bare: true},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := tree.Parse(test.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, test.Node, got.Root, test.Text)
+	}
+}
+
+func TestParseDottedNames(t *testing.T) {
+	tree := &Tree{DottedNames: true}
+
+	text := "${outer.inner} $outer.inner ${#a.b.c}"
+	got, err := tree.Parse(text)
+	assert.Nil(t, err)
+	clearSpans(got.Root)
+	assert.Equal(t, &ListNode{Nodes: []Node{
+		&FuncNode{Param: "outer.inner"},
+		&ListNode{Nodes: []Node{
+			&TextNode{Value: " "},
+			&ListNode{Nodes: []Node{
+				&FuncNode{Param: "outer", bare: true},
+				&ListNode{Nodes: []Node{
+					&TextNode{Value: ".inner "},
+					&FuncNode{Param: "a.b.c", Name: "#"},
+				}},
+			}},
+		}},
+	}}, got.Root)
+	assert.Equal(t, text, FormatNode(got.Root))
+
+	// segments may not be empty
+	for _, text := range []string{"${a..b}", "${a.}", "${a.:-x}", "${#a.}", "${.a}"} {
+		_, err := tree.Parse(text)
+		assert.NotNil(t, err, text)
+	}
+
+	// without DottedNames a dot is not part of a name
+	for _, text := range []string{"${outer.inner}", "${#a.b}"} {
+		_, err := Parse(text)
+		assert.NotNil(t, err, text)
+	}
+}
+
+func TestParseBackslashEscape(t *testing.T) {
+	tree := &Tree{BackslashEscape: true}
+
+	tests := []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: `\${string} \$string \$`,
+			Node: &TextNode{Value: `${string} $string $`},
+		},
+		{
+			Text: `\\${string}`,
+			Node: &ListNode{Nodes: []Node{&TextNode{Value: `\`}, &FuncNode{
+				Param: "string",
+			}}},
+		},
+		{
+			Text: `\\\$string\\`,
+			Node: &TextNode{Value: `\$string\\`},
+		},
+		{
+			Text: `$\$string`,
+			Node: &TextNode{Value: `$$string`},
+		},
+		{
+			Text: `${string:-\${x\}\\$y}`,
+			Node: &FuncNode{
+				Param: "string",
+				Name:  ":-",
+				Args: []Node{
+					&TextNode{Value: `${x}\`},
+					&FuncNode{Param: "y", bare: true, nesting: 1},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := tree.Parse(test.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, test.Node, got.Root, test.Text)
+	}
+
+	// without BackslashEscape a backslash before a dollar sign is text
+	got, err := Parse(`\${string}`)
+	assert.Nil(t, err)
+	clearSpans(got.Root)
+	assert.Equal(t, &ListNode{Nodes: []Node{&TextNode{Value: `\`}, &FuncNode{
+		Param: "string",
+	}}}, got.Root)
+}
+
+func TestParseStrictDollar(t *testing.T) {
+	tree := &Tree{StrictDollar: true}
+
+	for text, offset := range map[string]int{
+		"$ ":          0,
+		"$}":          0,
+		"$":           0,
+		"a $ {FOO}":   2,
+		"${A} cost $": 10,
+		"${A:-x$ }":   6,
+		"héllo $.":    7,
+	} {
+		_, err := tree.Parse(text)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("Want %q to fail, got %v", text, err)
+		}
+		assert.ErrorIs(t, err, ErrDanglingDollar, text)
+		assert.Equal(t, offset, syntaxErr.Offset, text)
+	}
+
+	for _, text := range []string{"$name", "${name}", "$$", "$$ x", "$${name}", "$(date)", "${A:-$$}", "no dollar"} {
+		_, err := tree.Parse(text)
+		assert.Nil(t, err, text)
+	}
+
+	// a sigil escaped by a backslash is accepted with BackslashEscape
+	for _, text := range []string{`\$ x`, "${A:-\\$}"} {
+		_, err := tree.Parse(text)
+		assert.ErrorIs(t, err, ErrDanglingDollar, text)
+		_, err = (&Tree{StrictDollar: true, BackslashEscape: true}).Parse(text)
+		assert.Nil(t, err, text)
+	}
+
+	// other sigils are checked in place of the dollar sign
+	tree = &Tree{StrictDollar: true, Sigil: '@'}
+	_, err := tree.Parse("$ user@{host}")
+	assert.Nil(t, err)
+	_, err = tree.Parse("user @ {host}")
+	assert.ErrorIs(t, err, ErrDanglingDollar)
+}
+
+func TestParseSigil(t *testing.T) {
+	tree := &Tree{Sigil: '@'}
+
+	tests := []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "@{VAR}",
+			Node: &FuncNode{Param: "VAR"},
+		},
+		{
+			Text: "@VAR",
+			Node: &FuncNode{Param: "VAR", bare: true},
+		},
+		{
+			Text: "@{VAR:-@{OTHER}}",
+			Node: &FuncNode{Param: "VAR", Name: ":-", Args: []Node{
+				&FuncNode{Param: "OTHER", nesting: 1},
+			}},
+		},
+		{
+			Text: "@@{VAR}",
+			Node: &ListNode{Nodes: []Node{
+				&TextNode{Value: "@"},
+				&FuncNode{Param: "VAR"},
+			}},
+		},
+		{
+			Text: "$VAR ${VAR}",
+			Node: &TextNode{Value: "$VAR ${VAR}"},
+		},
+		{
+			Text: "a @ b @",
+			Node: &TextNode{Value: "a @ b @"},
+		},
+		{
+			Text: "@(cmd)",
+			Node: &CommandNode{Command: "cmd"},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := tree.Parse(test.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, test.Node, got.Root, test.Text)
+	}
+}
+
+func TestParseExpandCompat(t *testing.T) {
+	text := "a $b ${c:-d}${}"
+	got, err := (&Tree{ExpandCompat: true}).Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &ListNode{Nodes: []Node{
+		&TextNode{Value: "a ", Pos: 0, End: 2},
+		&FuncNode{Param: "b", Pos: 2, End: 4, bare: true},
+		&TextNode{Value: " ", Pos: 4, End: 5},
+		&FuncNode{Param: "c:-d", Pos: 5, End: 12},
+	}}, got.Root)
+}
+
+func TestParseRecoverUnterminated(t *testing.T) {
+	tree := &Tree{RecoverUnterminated: true}
+
+	tests := []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "${VAR",
+			Node: &FuncNode{Param: "VAR"},
+		},
+		{
+			Text: "${VAR:-def",
+			Node: &FuncNode{Param: "VAR", Name: ":-", Args: []Node{&TextNode{Value: "def"}}},
+		},
+		{
+			// the operator has no operand, so it is dropped
+			Text: "${VAR:",
+			Node: &FuncNode{Param: "VAR"},
+		},
+		{
+			Text: "${VAR:1",
+			Node: &FuncNode{Param: "VAR", Name: ":", Args: []Node{&TextNode{Value: "1"}}},
+		},
+		{
+			Text: "${VAR/a/",
+			Node: &FuncNode{Param: "VAR", Name: "/", Args: []Node{&TextNode{Value: "a"}, &TextNode{Value: ""}}},
+		},
+		{
+			Text: "a ${VAR:-${DEF",
+			Node: &ListNode{Nodes: []Node{
+				&TextNode{Value: "a "},
+				&FuncNode{Param: "VAR", Name: ":-", Args: []Node{&FuncNode{Param: "DEF", nesting: 1}}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := tree.Parse(test.Text)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Text, err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, test.Node, got.Root, test.Text)
+
+		// the default is strict
+		_, err = Parse(test.Text)
+		assert.NotNil(t, err, test.Text)
+	}
+
+	_, err := tree.Parse("${")
+	assert.NotNil(t, err)
+}
+
+func TestParseConditionals(t *testing.T) {
+	tree := &Tree{Conditionals: true}
+
+	got, err := tree.Parse("a${if:X}b${if:Y}c${endif}${else}d${endif}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clearSpans(got.Root)
+	assert.Equal(t, &ListNode{Nodes: []Node{
+		&TextNode{Value: "a"},
+		&IfNode{
+			Cond: &FuncNode{Param: "X"},
+			Then: &ListNode{Nodes: []Node{
+				&TextNode{Value: "b"},
+				&IfNode{Cond: &FuncNode{Param: "Y"}, Then: &TextNode{Value: "c"}},
+			}},
+			Else: &TextNode{Value: "d"},
+		},
+	}}, got.Root)
+
+	// the same input is a pair of substrings and plain references without
+	// the option
+	got, err = Parse("${if:X}b${endif}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &FuncNode{}, got.Root.(*ListNode).Nodes[0])
+
+	for text, want := range map[string]struct {
+		err    error
+		offset int
+	}{
+		"a ${if:X} b":                     {ErrUnclosedIf, 2},
+		"${if:X}${if:Y}${endif}":          {ErrUnclosedIf, 0},
+		"a ${else}":                       {ErrUnexpectedElse, 2},
+		"${if:X}${else}${else}${endif}":   {ErrUnexpectedElse, 14},
+		"${if:X}${endif} ${endif}":        {ErrUnexpectedEndif, 16},
+		"${if:X}${else}${endif}${endif} ": {ErrUnexpectedEndif, 22},
+	} {
+		_, err := tree.Parse(text)
+		var syntaxErr *SyntaxError
+		if assert.True(t, errors.As(err, &syntaxErr), text) {
+			assert.True(t, errors.Is(err, want.err), text)
+			assert.Equal(t, want.offset, syntaxErr.Offset, text)
+		}
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	tree := &Tree{Comments: true}
+
+	text := "a${//! note: see ${b} }c ${d:-${//!x}e}"
+	got, err := tree.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := got.Root.(*ListNode).Nodes[1].(*ListNode).Nodes[0]
+	assert.Equal(t, &CommentNode{Text: " note: see ${b", Pos: 1, End: 21}, comment)
+
+	clearSpans(got.Root)
+	assert.Equal(t, &ListNode{Nodes: []Node{
+		&TextNode{Value: "a"},
+		&ListNode{Nodes: []Node{
+			&CommentNode{Text: " note: see ${b"},
+			&ListNode{Nodes: []Node{
+				&TextNode{Value: " }c "},
+				&FuncNode{Param: "d", Name: ":-", Args: []Node{
+					&CommentNode{Text: "x"},
+					&TextNode{Value: "e"},
+				}},
+			}},
+		}},
+	}}, got.Root)
+
+	got, err = tree.Parse("${//! comment}${#x} ${x//a/b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "${//! comment}${#x} ${x//a/b}", FormatNode(got.Root))
+
+	_, err = tree.Parse("a ${//! unclosed")
+	assert.True(t, errors.Is(err, ErrMissingClosingBrace))
+
+	// without the option the marker is not a variable name
+	_, err = Parse("${//! comment}")
+	assert.True(t, errors.Is(err, ErrParseVariableName))
+}
+
+func TestParseSingleCharOperators(t *testing.T) {
+	// each operator with an empty argument keeps its exact form, so that
+	// the colon forms, which treat an empty value as unset, are not
+	// confused with the others
+	for _, name := range []string{"-", "=", "+", "?", ":-", ":=", ":+", ":?"} {
+		text := "${x" + name + "}"
+		got, err := Parse(text)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, &FuncNode{Param: "x", Name: name}, got.Root, text)
+		assert.Equal(t, text, FormatNode(got.Root))
+	}
+
+	// the argument may start with the operator character
+	for _, name := range []string{"-", "=", "+", "?"} {
+		text := "${x" + name + name + "}"
+		got, err := Parse(text)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, &FuncNode{Param: "x", Name: name, Args: []Node{&TextNode{Value: name}}}, got.Root, text)
+	}
+
+	// # and % are not confused with their doubled forms or the length
+	// operator, and their pattern may be empty
+	for text, want := range map[string]Node{
+		"${#x}":   &FuncNode{Param: "x", Name: "#"},
+		"${x#a}":  &FuncNode{Param: "x", Name: "#", Args: []Node{&TextNode{Value: "a"}}},
+		"${x##a}": &FuncNode{Param: "x", Name: "##", Args: []Node{&TextNode{Value: "a"}}},
+		"${x#-}":  &FuncNode{Param: "x", Name: "#", Args: []Node{&TextNode{Value: "-"}}},
+		"${x%a}":  &FuncNode{Param: "x", Name: "%", Args: []Node{&TextNode{Value: "a"}}},
+		"${x%%a}": &FuncNode{Param: "x", Name: "%%", Args: []Node{&TextNode{Value: "a"}}},
+		"${x%-}":  &FuncNode{Param: "x", Name: "%", Args: []Node{&TextNode{Value: "-"}}},
+		"${x#}":   &FuncNode{Param: "x", Name: "#", Args: []Node{&TextNode{Value: ""}}},
+		"${x%%}":  &FuncNode{Param: "x", Name: "%%", Args: []Node{&TextNode{Value: ""}}},
+	} {
+		got, err := Parse(text)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		clearSpans(got.Root)
+		assert.Equal(t, want, got.Root, text)
+	}
+}
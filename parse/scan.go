@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -26,6 +27,7 @@ const (
 	tokenQuote
 	tokenBarevar
 	tokenDoubleDollar
+	tokenCommand
 )
 
 // predefined mode bits to control recognition of tokens.
@@ -34,12 +36,15 @@ const (
 	scanLbrack
 	scanRbrack
 	scanEscape
+	scanCommand
+	scanPattern
 )
 
 // predefined mode bits to control escape tokens.
 const (
 	dollar byte = 1 << iota
 	backslash
+	backslashDollar
 	escapeAll = dollar | backslash
 )
 
@@ -56,6 +61,25 @@ type scanner struct {
 	mode        byte
 	escapeChars byte
 
+	// sigil is the character that starts an expansion, $ by default.
+	sigil rune
+
+	// skipped counts the escape characters removed from buf, so that
+	// positions can be reported as offsets into the original input.
+	skipped int
+
+	// offset in the original input of the most recently scanned token.
+	tokenPos int
+
+	// strictSigil records in dangling the offset in the original input
+	// of the first sigil that does not start an expansion, or -1.
+	strictSigil bool
+	dangling    int
+
+	// offset in the original input of the second sigil of the most
+	// recent double sigil, which is not dangling.
+	escapedSigil int
+
 	accept acceptFunc
 }
 
@@ -65,7 +89,11 @@ func (s *scanner) init(buf string) {
 	s.pos = 0
 	s.start = 0
 	s.width = 0
+	s.skipped = 0
+	s.tokenPos = 0
 	s.accept = nil
+	s.dangling = -1
+	s.escapedSigil = -1
 }
 
 // read returns the next unicode character. It returns eof at
@@ -91,6 +119,15 @@ func (s *scanner) skip() {
 	l := s.buf[:s.pos-1]
 	r := s.buf[s.pos:]
 	s.buf = l + r
+	s.skipped++
+}
+
+// replace replaces the buffer between start and the current position with
+// the string r and moves the scanner back to start.
+func (s *scanner) replace(start int, r string) {
+	s.skipped += s.pos - start - len(r)
+	s.buf = s.buf[:start] + r + s.buf[s.pos:]
+	s.pos = start
 }
 
 // peek returns the next unicode character in the buffer without
@@ -107,9 +144,10 @@ func (s *scanner) peektwo() rune {
 	if r == eof {
 		return r
 	}
+	w := s.width
 	r2 := s.read()
 	s.unread()
-	s.unread()
+	s.pos -= w
 	return r2
 }
 
@@ -119,6 +157,24 @@ func (s *scanner) string() string {
 	return s.buf[s.start:s.pos]
 }
 
+// command returns the command of the most recently scanned command
+// substitution token, without the sigil and parentheses around it.
+func (s *scanner) command() string {
+	return s.buf[s.start+utf8.RuneLen(s.sigil)+1 : s.pos-1]
+}
+
+// offset returns the scanner's position as an offset into the original
+// input.
+func (s *scanner) offset() int {
+	return s.pos + s.skipped
+}
+
+// span returns the offsets in the original input of the start and end of
+// the most recently scanned token.
+func (s *scanner) span() (pos, end int) {
+	return s.tokenPos, s.offset()
+}
+
 // tests if the bit exists for a given character bit
 func (s *scanner) shouldEscape(character byte) bool {
 	return s.escapeChars&character != 0
@@ -128,6 +184,7 @@ func (s *scanner) shouldEscape(character byte) bool {
 // returns it. It returns EOF at the end of the source.
 func (s *scanner) scan() token {
 	s.start = s.pos
+	s.tokenPos = s.offset()
 	r := s.read()
 	switch {
 	case r == eof:
@@ -138,6 +195,8 @@ func (s *scanner) scan() token {
 		return tokenBarevar
 	case s.scanDoubleDollar(r):
 		return tokenDoubleDollar
+	case s.scanCommand(r):
+		return tokenCommand
 	case s.scanRbrack(r):
 		return tokenRbrack
 	case s.scanIdent(r):
@@ -156,6 +215,8 @@ func (s *scanner) scanIdent(r rune) bool {
 		s.skip()
 	} else if !s.accept(r, s.pos-s.start) {
 		return false
+	} else {
+		s.markDangling(r)
 	}
 loop:
 	for {
@@ -174,6 +235,9 @@ loop:
 		case s.scanDoubleDollar(r):
 			s.unread()
 			break loop
+		case s.commandEnd(r) != -1:
+			s.unread()
+			break loop
 		case s.scanBareVar(r):
 			s.unread()
 			break loop
@@ -186,10 +250,22 @@ loop:
 			s.unread()
 			break loop
 		}
+		s.markDangling(r)
 	}
 	return true
 }
 
+// markDangling records the offset of r, the rune just read as text, if it
+// is a sigil that does not start an expansion.
+func (s *scanner) markDangling(r rune) {
+	if !s.strictSigil || r != s.sigil || s.dangling != -1 {
+		return
+	}
+	if offset := s.offset() - utf8.RuneLen(r); offset != s.escapedSigil {
+		s.dangling = offset
+	}
+}
+
 // scanBareVar reads the next token or Unicode character from source
 // and returns true if the start of a bare variable (i.e. without brackets)
 // is encountered
@@ -197,7 +273,7 @@ func (s *scanner) scanBareVar(r rune) bool {
 	if s.mode&scanIdent == 0 {
 		return false
 	}
-	if r == '$' {
+	if r == s.sigil {
 		return acceptIdent(s.peek(), 0)
 	}
 
@@ -210,20 +286,56 @@ func (s *scanner) scanDoubleDollar(r rune) bool {
 	if s.mode&scanIdent == 0 {
 		return false
 	}
-	if r == '$' {
-		return s.peek() == '$'
+	if r == s.sigil && s.peek() == s.sigil {
+		s.escapedSigil = s.offset()
+		return true
 	}
 
 	return false
 }
 
+// scanCommand reads the next token or Unicode character from source
+// and returns true if a balanced command substitution $(...) is
+// encountered. The command is consumed in its entirety, including any
+// nested parentheses.
+func (s *scanner) scanCommand(r rune) bool {
+	end := s.commandEnd(r)
+	if end == -1 {
+		return false
+	}
+	s.pos = end
+	return true
+}
+
+// commandEnd returns the position following the closing parenthesis of
+// the command substitution started by r, or -1 if r does not start a
+// balanced command substitution. The scanner is not advanced.
+func (s *scanner) commandEnd(r rune) int {
+	if s.mode&scanCommand == 0 || r != s.sigil || s.peek() != '(' {
+		return -1
+	}
+	depth := 0
+	for i := s.pos; i < len(s.buf); i++ {
+		switch s.buf[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
 // scanLbrack reads the next token or Unicode character from source
 // and returns true if the open bracket is encountered.
 func (s *scanner) scanLbrack(r rune) bool {
 	if s.mode&scanLbrack == 0 {
 		return false
 	}
-	if r == '$' {
+	if r == s.sigil {
 		if s.read() == '{' {
 			return true
 		}
@@ -247,19 +359,38 @@ func (s *scanner) scanEscaped(r rune) bool {
 	if s.mode&scanEscape == 0 {
 		return false
 	}
-	if r == '$' && s.shouldEscape(dollar) {
-		if s.peek() == '$' {
+	if r == s.sigil && s.shouldEscape(dollar) {
+		if s.peek() == s.sigil {
 			return true
 		}
 	}
 	if r == '\\' && s.shouldEscape(backslash) {
 		switch s.peek() {
-		case '/', '\\':
+		case '\\':
+			if s.mode&scanPattern != 0 {
+				// an escaped backslash is kept as it is, for the pattern
+				// to match a literal backslash
+				s.read()
+				return false
+			}
+			return true
+		case '/', '}':
 			return true
+		case s.sigil:
+			return s.shouldEscape(backslashDollar)
 		default:
 			return false
 		}
 	}
+	if r == '\\' && s.shouldEscape(backslashDollar) {
+		// in a run of backslashes before a dollar sign each pair is a
+		// single backslash, and an odd one out escapes the dollar sign
+		i := s.pos
+		for i < len(s.buf) && s.buf[i] == '\\' {
+			i++
+		}
+		return i < len(s.buf) && strings.HasPrefix(s.buf[i:], string(s.sigil))
+	}
 
 	return false
 }
@@ -276,6 +407,13 @@ func acceptIdent(r rune, i int) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
 }
 
+// acceptName accepts the name of a variable within braces, which may
+// contain dots after its first character to name a nested value, e.g.
+// ${outer.inner}, if the tree allows dotted names.
+func acceptName(r rune, i int) bool {
+	return acceptIdent(r, i) || r == '.' && i > 1
+}
+
 func acceptColon(r rune, i int) bool {
 	return r == ':'
 }
@@ -322,8 +460,8 @@ func acceptReplaceFunc(r rune, i int) bool {
 	}
 }
 
-func acceptOneEqual(r rune, i int) bool {
-	return i == 1 && r == '='
+func acceptOneDefault(r rune, i int) bool {
+	return i == 1 && (r == '=' || r == '-' || r == '?' || r == '+')
 }
 
 func acceptOneColon(r rune, i int) bool {
@@ -342,6 +480,28 @@ func acceptNotSlash(r rune, i int) bool {
 	return r != '/'
 }
 
+func rejectSlashClose(r rune, i int) bool {
+	return r != '/' && r != '}'
+}
+
+func acceptAlias(r rune, i int) bool {
+	if i == 1 {
+		return r == '|'
+	}
+	return acceptIdent(r, i)
+}
+
+func acceptIndex(r rune, i int) bool {
+	if i == 1 && (r == '@' || r == '*') {
+		return true
+	}
+	return unicode.IsDigit(r)
+}
+
+func acceptTransformFunc(r rune, i int) bool {
+	return i == 1 && r == '@' || i >= 2 && unicode.IsLetter(r)
+}
+
 func acceptCasingFunc(r rune, i int) bool {
 	return (r == ',' || r == '^') && i < 3
 }
@@ -0,0 +1,194 @@
+package parse
+
+import "strings"
+
+// TokenKind identifies the kind of a Token.
+type TokenKind int
+
+const (
+	// TokenText is literal text, whether at the top level or within the
+	// arguments of a substitution. Its text is as written in the input,
+	// including escapes.
+	TokenText TokenKind = iota
+
+	// TokenVariable is the start of a substitution up to its first
+	// argument, such as ${VAR:- in ${VAR:-default}, or the whole
+	// substitution if it has no arguments, such as $VAR or ${#VAR}.
+	TokenVariable
+
+	// TokenDelimiter separates the arguments of a substitution, such as
+	// the / in ${VAR/pattern/string}.
+	TokenDelimiter
+
+	// TokenClose is the } that ends a substitution with arguments.
+	TokenClose
+
+	// TokenCommand is a command substitution, $(command).
+	TokenCommand
+
+	// TokenDirective is a ${if:name}, ${else} or ${endif} marker of a
+	// conditional block.
+	TokenDirective
+
+	// TokenComment is a comment, ${//! text}.
+	TokenComment
+)
+
+// Position is a location in the input. Line and Column are 1-based, and
+// columns are counted in characters rather than bytes.
+type Position struct {
+	Offset int // byte offset
+	Line   int
+	Column int
+}
+
+// Token is a span of the input and the node it belongs to. The tokens of an
+// input cover it in order, so that their text joined together is the
+// input.
+type Token struct {
+	Kind TokenKind
+	Text string
+
+	// Node is the node the token is part of, such as the *FuncNode of a
+	// TokenVariable or the *IfNode of a TokenDirective.
+	Node Node
+
+	Start Position
+	End   Position // the position just after the token
+}
+
+// Tokens parses the string like Parse and returns its tokens.
+func Tokens(buf string) ([]Token, error) {
+	return new(Tree).Tokens(buf)
+}
+
+// Tokens parses the string buffer with the settings of the tree and
+// returns its tokens, for editors and other tools that need the position
+// of each part of the input.
+func (t *Tree) Tokens(buf string) ([]Token, error) {
+	tree, err := t.Parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	z := &tokenizer{input: buf, sigil: string(t.sigil()), pos: Position{Line: 1, Column: 1}}
+	z.walk(tree.Root)
+	return z.tokens, nil
+}
+
+// tokenizer turns the nodes of a tree into tokens, tracking the line and
+// column as it goes.
+type tokenizer struct {
+	input  string
+	sigil  string
+	pos    Position
+	tokens []Token
+}
+
+func (z *tokenizer) walk(node Node) {
+	switch n := node.(type) {
+	case *ListNode:
+		for _, item := range n.Nodes {
+			z.walk(item)
+		}
+	case *TextNode:
+		z.emit(TokenText, n, n.Pos, n.End)
+	case *CommandNode:
+		z.emit(TokenCommand, n, n.Pos, n.End)
+	case *CommentNode:
+		z.emit(TokenComment, n, n.Pos, n.End)
+	case *FuncNode:
+		z.walkFunc(n)
+	case *IfNode:
+		z.walkIf(n)
+	}
+}
+
+func (z *tokenizer) walkFunc(n *FuncNode) {
+	kind := TokenVariable
+	cursor := n.Pos
+	for _, arg := range n.Args {
+		pos, end := Span(arg)
+		if pos == end {
+			// empty arguments, as in ${VAR/#/prefix}, are not in the input
+			continue
+		}
+		z.emit(kind, n, cursor, pos)
+		z.walk(arg)
+		kind, cursor = TokenDelimiter, end
+	}
+	if kind == TokenVariable {
+		z.emit(kind, n, cursor, n.End)
+		return
+	}
+
+	// the rest may be a delimiter before an empty argument, as well as
+	// the closing brace
+	end := n.End
+	if end > cursor && z.input[end-1] == '}' {
+		end--
+	}
+	z.emit(TokenDelimiter, n, cursor, end)
+	z.emit(TokenClose, n, end, n.End)
+}
+
+func (z *tokenizer) walkIf(n *IfNode) {
+	z.emit(TokenDirective, n, n.Pos, n.Cond.End)
+	cursor := n.Cond.End
+	for _, branch := range []Node{n.Then, n.Else} {
+		if branch == nil {
+			continue
+		}
+		pos, end := Span(branch)
+		if pos == end {
+			continue
+		}
+		z.directives(n, cursor, pos)
+		z.walk(branch)
+		cursor = end
+	}
+	z.directives(n, cursor, n.End)
+}
+
+// directives emits the ${else} and ${endif} markers between the branches
+// of a conditional block, which are adjacent if a branch is empty.
+func (z *tokenizer) directives(n *IfNode, pos, end int) {
+	for pos < end {
+		next := strings.Index(z.input[pos+1:end], z.sigil)
+		if next == -1 {
+			z.emit(TokenDirective, n, pos, end)
+			return
+		}
+		z.emit(TokenDirective, n, pos, pos+1+next)
+		pos += 1 + next
+	}
+}
+
+// emit adds a token for the input between the offsets, if any.
+func (z *tokenizer) emit(kind TokenKind, node Node, pos, end int) {
+	if end <= pos {
+		return
+	}
+	z.advance(pos)
+	start := z.pos
+	z.advance(end)
+	z.tokens = append(z.tokens, Token{
+		Kind:  kind,
+		Text:  z.input[pos:end],
+		Node:  node,
+		Start: start,
+		End:   z.pos,
+	})
+}
+
+// advance moves the current position forward to the offset.
+func (z *tokenizer) advance(offset int) {
+	for _, r := range z.input[z.pos.Offset:offset] {
+		if r == '\n' {
+			z.pos.Line++
+			z.pos.Column = 1
+		} else {
+			z.pos.Column++
+		}
+	}
+	z.pos.Offset = offset
+}
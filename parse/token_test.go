@@ -0,0 +1,84 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokens(t *testing.T) {
+	input := "héllo ${NAME:-wörld}\n日本 $X ${Y/#a/}\n\t${Z:1:2}$(date)"
+	tokens, err := Tokens(input)
+	assert.Nil(t, err)
+
+	type token struct {
+		kind         TokenKind
+		text         string
+		line, column int
+		offset       int
+	}
+	var got []token
+	for _, tok := range tokens {
+		got = append(got, token{tok.Kind, tok.Text, tok.Start.Line, tok.Start.Column, tok.Start.Offset})
+	}
+	assert.Equal(t, []token{
+		{TokenText, "héllo ", 1, 1, 0},
+		{TokenVariable, "${NAME:-", 1, 7, 7},
+		{TokenText, "wörld", 1, 15, 15},
+		{TokenClose, "}", 1, 20, 21},
+		{TokenText, "\n日本 ", 1, 21, 22},
+		{TokenVariable, "$X", 2, 4, 30},
+		{TokenText, " ", 2, 6, 32},
+		{TokenVariable, "${Y/#", 2, 7, 33},
+		{TokenText, "a", 2, 12, 38},
+		{TokenDelimiter, "/", 2, 13, 39},
+		{TokenClose, "}", 2, 14, 40},
+		{TokenText, "\n\t", 2, 15, 41},
+		{TokenVariable, "${Z:", 3, 2, 43},
+		{TokenText, "1", 3, 6, 47},
+		{TokenDelimiter, ":", 3, 7, 48},
+		{TokenText, "2", 3, 8, 49},
+		{TokenClose, "}", 3, 9, 50},
+		{TokenCommand, "$(date)", 3, 10, 51},
+	}, got)
+
+	last := tokens[len(tokens)-1]
+	assert.Equal(t, Position{Offset: len(input), Line: 3, Column: 17}, last.End)
+	assert.Equal(t, "NAME", tokens[1].Node.(*FuncNode).Param)
+}
+
+func TestTokensCoverInput(t *testing.T) {
+	tree := &Tree{Conditionals: true}
+	for _, input := range []string{
+		"",
+		"plain text",
+		"${a:-b${c}d} \\$e $$f",
+		"${x/a\\/b/${y}} ${x:-} ${#x} ${!app_@} ${arr[1]}",
+		"${if:A}${else}b${endif}",
+		"${if:A}x${if:B}y${endif}${else}${endif}z",
+	} {
+		tokens, err := tree.Tokens(input)
+		assert.Nil(t, err, input)
+
+		var b strings.Builder
+		for _, tok := range tokens {
+			b.WriteString(tok.Text)
+		}
+		assert.Equal(t, input, b.String())
+	}
+
+	tokens, err := tree.Tokens("${if:A}\nx${else}${endif}")
+	assert.Nil(t, err)
+	var directives []string
+	for _, tok := range tokens {
+		if tok.Kind == TokenDirective {
+			directives = append(directives, tok.Text)
+		}
+	}
+	assert.Equal(t, []string{"${if:A}", "${else}", "${endif}"}, directives)
+	assert.Equal(t, Position{Offset: 9, Line: 2, Column: 2}, tokens[2].Start)
+
+	_, err = Tokens("${a")
+	assert.ErrorIs(t, err, ErrMissingClosingBrace)
+}
@@ -0,0 +1,36 @@
+package envsubst
+
+import "regexp"
+
+// EvalMatching replaces ${var} in the string based on the mapping function,
+// but only for variables whose names match re. Substitutions of other
+// variables are left as their original text, including any nested
+// substitutions they contain.
+func EvalMatching(s string, re *regexp.Regexp, mapping Mapping) (string, error) {
+	return EvalAdvanced(s, func(name string, n NodeInfo) (string, bool) {
+		if !re.MatchString(name) {
+			return n.Orig(), false
+		}
+		return mapping(name), true
+	})
+}
+
+// VerbatimMapping maps a variable name to its value, or returns false to
+// leave the substitutions of the variable as they are written.
+type VerbatimMapping func(name string) (value string, substitute bool)
+
+// EvalVerbatim replaces ${var} in the string based on a mapping that may
+// decline to substitute some variables. If the mapping returns true, its
+// value is used as with Eval, so operators such as ${var:-default} still
+// apply, and an empty value is treated as unset. If it returns false, the
+// whole substitution is left as its original text, e.g. ${SKIP:-x} stays
+// ${SKIP:-x}, including any nested substitutions it contains.
+func EvalVerbatim(s string, mapping VerbatimMapping) (string, error) {
+	return EvalAdvanced(s, func(name string, n NodeInfo) (string, bool) {
+		v, ok := mapping(name)
+		if !ok {
+			return n.Orig(), false
+		}
+		return v, true
+	})
+}
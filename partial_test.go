@@ -0,0 +1,57 @@
+package envsubst
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalMatching(t *testing.T) {
+	re := regexp.MustCompile(`^APP_`)
+	m := func(s string) string {
+		return map[string]string{
+			"APP_NAME": "billing",
+			"APP_PORT": "8080",
+			"HOME":     "/root",
+		}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${APP_NAME} ${HOME}":                "billing ${HOME}",
+		"$APP_PORT $HOME":                    "8080 $HOME",
+		"${APP_NAME^^}-${HOME%/*}":           "BILLING-${HOME%/*}",
+		"${APP_HOST:-${HOME}}":               "${HOME}",
+		"${APP_HOST:-${APP_NAME}.local}":     "billing.local",
+		"${HOME:-${APP_NAME}}":               "${HOME:-${APP_NAME}}",
+		"${APP_HOST:-${OTHER:-${APP_PORT}}}": "${OTHER:-${APP_PORT}}",
+		`${HOME:-a\}b}`:                      `${HOME:-a\}b}`,
+	} {
+		got, err := EvalMatching(input, re, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+}
+
+func TestEvalVerbatim(t *testing.T) {
+	m := func(s string) (string, bool) {
+		if s == "SKIP" {
+			return "", false
+		}
+		return map[string]string{"NAME": "app"}[s], true
+	}
+
+	for input, want := range map[string]string{
+		"${SKIP} ${NAME}":       "${SKIP} app",
+		"$SKIP-$NAME":           "$SKIP-app",
+		"${SKIP:-${NAME}}":      "${SKIP:-${NAME}}",
+		"${UNSET:-${SKIP}}":     "${SKIP}",
+		"${UNSET:-x} ${NAME^^}": "x APP",
+		"${NAME:+${SKIP//a/b}}": "${SKIP//a/b}",
+		`$$SKIP ${SKIP:-a\}b}`:  `$$SKIP ${SKIP:-a\}b}`,
+	} {
+		got, err := EvalVerbatim(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+}
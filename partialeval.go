@@ -0,0 +1,155 @@
+package envsubst
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// errUnknown is returned by the mapping of PartialEval for variables that
+// are not known yet.
+var errUnknown = errors.New("unknown variable")
+
+// PartialEval replaces the substitutions of the variables in known, with
+// their operators, and leaves the rest of the template as it is written,
+// so that it can be evaluated later with the other variables. Known
+// variables nested within the substitution of an unknown one are replaced
+// too, e.g. with known B, ${A:-${B}} becomes ${A:-b}. A substitution of a
+// known variable that needs an unknown one, such as ${B:-${A}} with B
+// empty, is left as it is, apart from its nested substitutions. Text that
+// contains no known substitutions is copied from s exactly. A value set
+// to the empty string is set, as the - and :- operators distinguish. A
+// substitution whose value contains a dollar sign, or that follows one, as
+// in $${B}, is left as it is too, as its value could start an expansion.
+func PartialEval(s string, known map[string]string) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	p := &partialEvaluator{t: t, known: known}
+
+	var items []parse.Node
+	flattenTop(t.tree.Root, &items)
+
+	parts := make([]string, len(items))
+	for i, item := range items {
+		node, changed, err := p.rewrite(item)
+		if err != nil {
+			return s, err
+		}
+		if _, ok := node.(*parse.TextNode); ok && i > 0 && endsWithDollar(items[i-1]) {
+			changed = false
+		}
+		if !changed {
+			pos, end := parse.Span(item)
+			parts[i] = s[pos:end]
+			continue
+		}
+		parts[i] = parse.FormatNode(node)
+	}
+	return strings.Join(parts, ""), nil
+}
+
+// flattenTop appends the nodes of the top level of the tree to items.
+func flattenTop(node parse.Node, items *[]parse.Node) {
+	if list, ok := node.(*parse.ListNode); ok {
+		for _, item := range list.Nodes {
+			flattenTop(item, items)
+		}
+		return
+	}
+	*items = append(*items, node)
+}
+
+// endsWithDollar reports whether node is text that ends with a dollar
+// sign, which the text of a value after it could turn into an expansion.
+func endsWithDollar(node parse.Node) bool {
+	text, ok := node.(*parse.TextNode)
+	return ok && strings.HasSuffix(text.Value, "$")
+}
+
+// partialEvaluator rewrites the nodes of a template, replacing the
+// substitutions that can be evaluated with the known variables by text.
+type partialEvaluator struct {
+	t     *Template
+	known map[string]string
+}
+
+// rewrite returns the node with the substitutions that can be evaluated
+// replaced by their values, and whether anything was replaced.
+func (p *partialEvaluator) rewrite(node parse.Node) (parse.Node, bool, error) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		nodes, changed, err := p.rewriteNodes(n.Nodes)
+		if err != nil || !changed {
+			return node, false, err
+		}
+		return &parse.ListNode{Nodes: nodes}, true, nil
+	case *parse.FuncNode:
+		if p.evaluable(n) {
+			v, err := p.eval(n)
+			if err == nil && !strings.Contains(v, "$") {
+				return &parse.TextNode{Value: v}, true, nil
+			}
+			if err != nil && !errors.Is(err, errUnknown) {
+				return nil, false, err
+			}
+		}
+		args, changed, err := p.rewriteNodes(n.Args)
+		if err != nil || !changed {
+			return node, false, err
+		}
+		c := *n
+		c.Args = args
+		return &c, true, nil
+	}
+	return node, false, nil
+}
+
+func (p *partialEvaluator) rewriteNodes(nodes []parse.Node) ([]parse.Node, bool, error) {
+	var out []parse.Node
+	for i, node := range nodes {
+		n, changed, err := p.rewrite(node)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, ok := n.(*parse.TextNode); ok && i > 0 && endsWithDollar(nodes[i-1]) {
+			n, changed = node, false
+		}
+		if changed && out == nil {
+			out = append([]parse.Node(nil), nodes...)
+		}
+		if out != nil {
+			out[i] = n
+		}
+	}
+	return out, out != nil, nil
+}
+
+// evaluable reports whether the substitution may be evaluated now: its
+// variable is known, or it is a function called without a variable.
+// Arrays, namespaced variables and ${!prefix*} are left for later.
+func (p *partialEvaluator) evaluable(n *parse.FuncNode) bool {
+	if n.Param == "" {
+		return true
+	}
+	if n.Index != "" || n.Namespace != "" || isPrefixNames(n.Name) {
+		return false
+	}
+	_, ok := p.known[n.Param]
+	return ok
+}
+
+// eval evaluates the substitution alone, failing with errUnknown if it
+// needs a variable that is not known.
+func (p *partialEvaluator) eval(n *parse.FuncNode) (string, error) {
+	sub := &Template{tree: &parse.Tree{Root: n}, opts: p.t.opts}
+	return sub.execute(func(name string, _ ResolveContext) (string, bool, error) {
+		v, ok := p.known[name]
+		if !ok {
+			return "", false, errUnknown
+		}
+		return v, true, nil
+	})
+}
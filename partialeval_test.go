@@ -0,0 +1,68 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialEval(t *testing.T) {
+	known := map[string]string{
+		"HOST":  "example.com",
+		"EMPTY": "",
+		"PATH_": "a/b}",
+		"SLASH": `C:\`,
+		"PRICE": "$5 ${X}",
+	}
+
+	for input, want := range map[string]string{
+		// unknown variables are copied exactly
+		"host: ${HOST}, port: ${PORT:-80}":       "host: example.com, port: ${PORT:-80}",
+		"$$PORT $${PORT} \\${PORT} ${PORT/%x/y}": "$$PORT $${PORT} \\${PORT} ${PORT/%x/y}",
+		"$HOST:${PORT:-${DEFAULT_PORT}}":         "example.com:${PORT:-${DEFAULT_PORT}}",
+		"${HOST^^} ${#HOST} ${HOST%.com}":        "EXAMPLE.COM 11 example",
+
+		// known variables nested within unknown ones
+		"${URL:-https://${HOST}/}":          "${URL:-https://example.com/}",
+		"${URL:-${SCHEME:-http}://${HOST}}": "${URL:-${SCHEME:-http}://example.com}",
+		"${P:-${PATH_}} ${P/x/${PATH_}}":    `${P:-a/b\}} ${P/x/a\/b\}}`,
+		"${A:-${B:-${HOST}}}":               "${A:-${B:-example.com}}",
+
+		// known variables that need unknown ones
+		"${HOST:-${PORT}} ${EMPTY:-${PORT}}": "example.com ${EMPTY:-${PORT}}",
+		"${EMPTY-${PORT}} ${EMPTY:+${PORT}}": " ",
+		"${EMPTY:-${PORT:-${HOST}}}":         "${EMPTY:-${PORT:-example.com}}",
+
+		// values that could start an expansion are left for later
+		"${SLASH}${PORT} ${SLASH}":       `C:\${PORT} C:\`,
+		"${PRICE} ${A:-${PRICE}}":        "${PRICE} ${A:-${PRICE}}",
+		"$${HOST} ${A:-$${HOST}} $$HOST": "$${HOST} ${A:-$${HOST}} $$HOST",
+	} {
+		out, err := PartialEval(input, known)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, out, input)
+
+		// evaluating the rest gives the same result as evaluating it all
+		rest := func(name string) string {
+			if v, ok := known[name]; ok {
+				return v
+			}
+			return map[string]string{"PORT": "8080", "SCHEME": "https"}[name]
+		}
+		direct, err := EvalSet(input, func(name string) (string, bool) {
+			if v, ok := known[name]; ok {
+				return v, true
+			}
+			v := rest(name)
+			return v, v != ""
+		})
+		assert.Nil(t, err, input)
+		staged, err := Eval(out, rest)
+		assert.Nil(t, err, out)
+		assert.Equal(t, direct, staged, input)
+	}
+
+	out, err := PartialEval("${HOST", known)
+	assert.NotNil(t, err)
+	assert.Equal(t, "${HOST", out)
+}
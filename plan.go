@@ -0,0 +1,40 @@
+package envsubst
+
+// PlanEval parses s and reports the variables that would be unset when it
+// is evaluated with mapping, in the order in which they are first found, so
+// that a caller can ask for their values. Variables that have a default or
+// are only used by a default that is not used are not missing. The returned
+// run function evaluates s with the values it is given layered over
+// mapping, so it can be called once those values are known.
+func PlanEval(s string, mapping Mapping) (missing []string, run func(map[string]string) (string, error), err error) {
+	t, err := ParseWithOptions(s, &Options{Strict: true, ReportAllUnset: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = t.Execute(mapping)
+	switch err := err.(type) {
+	case nil:
+	case UnsetErrors:
+		for _, e := range err {
+			missing = append(missing, e.Name)
+		}
+	case *UnsetError:
+		// ${var:?message} stops evaluation at the first such variable
+		missing = append(missing, err.Name)
+	default:
+		return nil, nil, err
+	}
+
+	render := t.Clone()
+	render.opts = Options{}
+	run = func(values map[string]string) (string, error) {
+		return render.Execute(func(name string) string {
+			if v, ok := values[name]; ok {
+				return v
+			}
+			return mapping(name)
+		})
+	}
+	return missing, run, nil
+}
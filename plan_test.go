@@ -0,0 +1,45 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanEval(t *testing.T) {
+	base := func(s string) string {
+		return map[string]string{"HOST": "example.com"}[s]
+	}
+
+	missing, run, err := PlanEval("${USER}@${HOST}:${PORT:-22} ${USER} ${KEY}", base)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"USER", "KEY"}, missing)
+
+	// the values asked for are layered over the base mapping
+	out, err := run(map[string]string{"USER": "admin", "KEY": "id_rsa"})
+	assert.Nil(t, err)
+	assert.Equal(t, "admin@example.com:22 admin id_rsa", out)
+
+	out, err = run(map[string]string{"USER": "root", "HOST": "localhost", "PORT": "2222"})
+	assert.Nil(t, err)
+	assert.Equal(t, "root@localhost:2222 root ", out)
+}
+
+func TestPlanEvalNothingMissing(t *testing.T) {
+	missing, run, err := PlanEval("${A:-a}${B:+b}", func(string) string { return "" })
+	assert.Nil(t, err)
+	assert.Empty(t, missing)
+
+	out, err := run(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", out)
+}
+
+func TestPlanEvalErrors(t *testing.T) {
+	_, _, err := PlanEval("${A", func(string) string { return "" })
+	assert.NotNil(t, err)
+
+	missing, _, err := PlanEval("${A:?required} ${B}", func(string) string { return "" })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"A"}, missing)
+}
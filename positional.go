@@ -0,0 +1,39 @@
+package envsubst
+
+import "strconv"
+
+// positional returns a resolver that resolves the positional parameters,
+// ${1}, ${2} and so on, from the PositionalArgs option and every other
+// variable with mapping. A positional parameter beyond the arguments given
+// is unset.
+func (t *Template) positional(mapping resolver) resolver {
+	if t.opts.PositionalArgs == nil {
+		return mapping
+	}
+	args := t.opts.PositionalArgs
+	return func(name string, ctx ResolveContext) (string, bool, error) {
+		n, ok := positionalIndex(name)
+		if !ok {
+			return mapping(name, ctx)
+		}
+		if n > len(args) {
+			return "", false, nil
+		}
+		return args[n-1], true, nil
+	}
+}
+
+// positionalIndex returns the number of the positional parameter name, if
+// it is one. $0 is not a positional parameter.
+func positionalIndex(name string) (int, bool) {
+	if name == "" || name[0] < '1' || name[0] > '9' {
+		return 0, false
+	}
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(name)
+	return n, err == nil
+}
@@ -0,0 +1,38 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositionalArgs(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"1": "from mapping", "NAME": "app"}[s]
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{}, "fallback|fallback"},
+		{[]string{"one"}, "one|one"},
+		{[]string{"one", "two"}, "one|one"},
+		{[]string{"", "two"}, "two|"},
+	}
+	for _, test := range tests {
+		got, err := EvalWithOptions("${1:-${2:-fallback}}|${1=${2=fallback}}",
+			mapping, &Options{PositionalArgs: test.args})
+		assert.Nil(t, err, test.args)
+		assert.Equal(t, test.want, got, test.args)
+	}
+
+	got, err := EvalWithOptions("$NAME ${2} ${10:-none}", mapping, &Options{PositionalArgs: []string{"a", "b"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "app b none", got)
+
+	// without positional arguments, ${1} is resolved by the mapping
+	got, err = EvalWithOptions("${1}", mapping, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "from mapping", got)
+}
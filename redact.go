@@ -0,0 +1,51 @@
+package envsubst
+
+import (
+	"sort"
+	"strings"
+)
+
+// resolved records the value a variable resolved to.
+type resolved struct {
+	name  string
+	value string
+}
+
+// redactedError is an error whose text has had secret values masked. The
+// underlying error remains available to errors.Is and errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redact masks the values resolved during evaluation in the text of err
+// using the redactor, if one is set.
+func (t *Template) redact(s *state, err error) error {
+	if t.opts.Redactor == nil || len(s.values) == 0 {
+		return err
+	}
+
+	// replace longer values first, so that a value containing another
+	// is masked as a whole
+	values := append([]resolved(nil), s.values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		return len(values[i].value) > len(values[j].value)
+	})
+
+	msg := err.Error()
+	for _, v := range values {
+		msg = strings.ReplaceAll(msg, v.value, t.opts.Redactor(v.name, v.value))
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
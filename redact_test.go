@@ -0,0 +1,46 @@
+package envsubst
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	unregisterFunc(t, "checksecret")
+	assert.Nil(t, RegisterFunc("checksecret", func(value string, args ...string) (string, error) {
+		return "", fmt.Errorf("invalid secret %q", value)
+	}))
+
+	m := func(s string) string {
+		return map[string]string{
+			"PASSWORD": "hunter2",
+			"USER":     "admin",
+		}[s]
+	}
+	opts := &Options{
+		Redactor: func(name, value string) string {
+			if strings.Contains(name, "PASSWORD") {
+				return "****"
+			}
+			return value
+		},
+	}
+
+	_, err := EvalWithOptions("${PASSWORD|checksecret}", m, opts)
+	assert.EqualError(t, err, `invalid secret "****"`)
+
+	_, err = EvalWithOptions("${TOKEN:?not set for $USER with $PASSWORD}", m, opts)
+	assert.EqualError(t, err, "TOKEN: not set for admin with ****")
+
+	var unsetErr *UnsetError
+	assert.True(t, errors.As(err, &unsetErr))
+	assert.Equal(t, "TOKEN", unsetErr.Name)
+
+	// values are not redacted without a redactor
+	_, err = Eval("${PASSWORD|checksecret}", m)
+	assert.EqualError(t, err, `invalid secret "hunter2"`)
+}
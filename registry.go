@@ -0,0 +1,235 @@
+package envsubst
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Func is a custom substitution function, called as ${var|name:arg...}
+// with the value of the variable and the arguments.
+type Func func(value string, args ...string) (string, error)
+
+// MappingFunc is a custom substitution function that can look up other
+// variables, such as one whose arguments are variable names. It is called
+// as ${var|name:arg...}, or as ${|name:arg...} without a variable, in
+// which case the value is empty. lookup resolves a variable with the
+// mapping of the evaluation and reports whether it is set.
+type MappingFunc func(lookup MappingSet, value string, args ...string) (string, error)
+
+var (
+	funcMu sync.RWMutex
+	funcs  = map[string]MappingFunc{
+		"coalesce":  coalesce,
+		"eq":        valueFunc(eq),
+		"join":      valueFunc(join),
+		"pathjoin":  valueFunc(pathjoin),
+		"split":     valueFunc(split),
+		"urlencode": valueFunc(urlencode),
+		"urldecode": valueFunc(urldecode),
+	}
+)
+
+// valueFunc adapts a function of the value alone.
+func valueFunc(fn Func) MappingFunc {
+	return func(_ MappingSet, value string, args ...string) (string, error) {
+		return fn(value, args...)
+	}
+}
+
+// funcPattern matches valid function names.
+var funcPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RegisterFunc makes the function fn available to templates as
+// ${var|name:arg1:arg2}. The arguments are separated by colons and may
+// contain nested expansions. A function cannot be redefined, and its name
+// cannot be used by an operator alias.
+func RegisterFunc(name string, fn Func) error {
+	if fn == nil {
+		return fmt.Errorf("function %q is nil", name)
+	}
+	return RegisterMappingFunc(name, valueFunc(fn))
+}
+
+// RegisterMappingFunc is like RegisterFunc, but the function is also given
+// a lookup of the other variables, e.g.
+//
+//	RegisterMappingFunc("indirect", func(lookup MappingSet, value string, args ...string) (string, error) {
+//		v, _ := lookup(value)
+//		return v, nil
+//	})
+func RegisterMappingFunc(name string, fn MappingFunc) error {
+	if !funcPattern.MatchString(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("function %q is nil", name)
+	}
+	if _, ok := lookupAlias("|" + name); ok {
+		return fmt.Errorf("function %q is already an operator alias", name)
+	}
+
+	funcMu.Lock()
+	defer funcMu.Unlock()
+	if _, ok := funcs[name]; ok {
+		return fmt.Errorf("function %q is already registered", name)
+	}
+	funcs[name] = fn
+	parseCache.reset()
+	return nil
+}
+
+// lookupFunction returns the registered function by name.
+func lookupFunction(name string) (MappingFunc, bool) {
+	funcMu.RLock()
+	defer funcMu.RUnlock()
+	fn, ok := funcs[name]
+	return fn, ok
+}
+
+// isFunction reports whether name is a registered function.
+func isFunction(name string) bool {
+	_, ok := lookupFunction(name)
+	return ok
+}
+
+// eq implements ${var|eq:expected:yes:no}, which is yes if the value equals
+// expected and no otherwise. With a fourth argument of numeric the values
+// are compared as numbers, and a value that is not a number is unequal.
+func eq(value string, args ...string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", fmt.Errorf(currentMessages().EqArgs, len(args))
+	}
+	expected, yes, no := args[0], args[1], args[2]
+
+	if len(args) == 3 {
+		if value == expected {
+			return yes, nil
+		}
+		return no, nil
+	}
+
+	if args[3] != "numeric" {
+		return "", fmt.Errorf(currentMessages().UnknownMode, "eq", args[3])
+	}
+	want, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return "", fmt.Errorf(currentMessages().NotANumber, expected)
+	}
+	if got, err := strconv.ParseFloat(value, 64); err == nil && got == want {
+		return yes, nil
+	}
+	return no, nil
+}
+
+// pathjoin implements ${var|pathjoin:elem...}, which joins the value and
+// each element with a single slash, like ${var%/}/${elem#/}. Only the slash
+// at each side of the join is removed, so the result is valid for URLs as
+// well as file paths, and empty elements are skipped.
+func pathjoin(value string, args ...string) (string, error) {
+	for _, elem := range args {
+		if elem == "" {
+			continue
+		}
+		value = strings.TrimSuffix(value, "/") + "/" + strings.TrimPrefix(elem, "/")
+	}
+	return value, nil
+}
+
+// split implements ${var|split:sep:index}, which is the field of the value
+// at index when it is split on sep. A negative index counts back from the
+// last field, and an index out of range is empty. As a colon separates the
+// arguments, an empty sep splits on colons, e.g. ${PATH|split::0} is the
+// first directory of PATH.
+func split(value string, args ...string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf(currentMessages().SplitArgs, len(args))
+	}
+	sep := args[0]
+	if sep == "" {
+		sep = ":"
+	}
+	i, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", fmt.Errorf(currentMessages().NotAnInteger, args[1])
+	}
+
+	fields := strings.Split(value, sep)
+	if i < 0 {
+		i += len(fields)
+	}
+	if i < 0 || i >= len(fields) {
+		return "", nil
+	}
+	return fields[i], nil
+}
+
+// coalesce implements ${var|coalesce:name...}, which is the value if it is
+// not empty and otherwise the value of the first named variable that is
+// set and not empty, or empty if there is none. It is usually called
+// without a variable, as ${|coalesce:A:B:C}.
+func coalesce(lookup MappingSet, value string, args ...string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	for _, name := range args {
+		if v, ok := lookup(name); ok && v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// urlencode implements ${var|urlencode}, which percent-encodes the value for
+// use in a URL query. With an argument of path the value is encoded as a
+// path segment instead, so that spaces become %20 rather than +.
+func urlencode(value string, args ...string) (string, error) {
+	path, err := urlMode("urlencode", args)
+	if err != nil {
+		return "", err
+	}
+	if path {
+		return url.PathEscape(value), nil
+	}
+	return url.QueryEscape(value), nil
+}
+
+// urldecode implements ${var|urldecode}, the inverse of urlencode. It fails
+// if the value is not validly encoded.
+func urldecode(value string, args ...string) (string, error) {
+	path, err := urlMode("urldecode", args)
+	if err != nil {
+		return "", err
+	}
+	var s string
+	if path {
+		s, err = url.PathUnescape(value)
+	} else {
+		s, err = url.QueryUnescape(value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("urldecode: %w", err)
+	}
+	return s, nil
+}
+
+// urlMode reports whether the arguments of the named URL function select
+// path escaping. The default is query escaping.
+func urlMode(name string, args []string) (path bool, err error) {
+	switch {
+	case len(args) == 0:
+		return false, nil
+	case len(args) > 1:
+		return false, fmt.Errorf(currentMessages().ModeArgs, name, len(args))
+	}
+	switch args[0] {
+	case "query":
+		return false, nil
+	case "path":
+		return true, nil
+	}
+	return false, fmt.Errorf(currentMessages().UnknownMode, name, args[0])
+}
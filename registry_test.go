@@ -0,0 +1,216 @@
+package envsubst
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEq(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{
+			"ENV":      "prod",
+			"REPLICAS": "3.0",
+			"EXPECTED": "prod",
+		}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${ENV|eq:prod:yes:no}":             "yes",
+		"${ENV|eq:dev:yes:no}":              "no",
+		"${UNSET|eq:prod:yes:no}":           "no",
+		"${UNSET|eq::empty:set}":            "empty",
+		"${ENV|eq:${EXPECTED}:on-$ENV:off}": "on-prod",
+		"${REPLICAS|eq:3:yes:no}":           "no",
+		"${REPLICAS|eq:3:yes:no:numeric}":   "yes",
+		"${REPLICAS|eq:4:yes:no:numeric}":   "no",
+		"${UNSET|eq:0:yes:no:numeric}":      "no",
+	} {
+		got, err := Eval(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{
+		"${ENV|eq:prod}",
+		"${ENV|eq:prod:yes:no:octal}",
+		"${ENV|eq:three:yes:no:numeric}",
+	} {
+		_, err := Eval(input, m)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestURLEncode(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{
+			"QUERY":   "a b&c=d/e",
+			"ENCODED": "a+b%26c%3Dd%2Fe",
+			"PATH":    "a%20b&c=d%2Fe",
+		}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${QUERY|urlencode}":          "a+b%26c%3Dd%2Fe",
+		"${QUERY|urlencode:query}":    "a+b%26c%3Dd%2Fe",
+		"${QUERY|urlencode:path}":     "a%20b&c=d%2Fe",
+		"${ENCODED|urlencode}":        "a%2Bb%2526c%253Dd%252Fe",
+		"${ENCODED|urldecode}":        "a b&c=d/e",
+		"${PATH|urldecode:path}":      "a b&c=d/e",
+		"${ENCODED|urldecode:path}":   "a+b&c=d/e",
+		"q=${QUERY|urlencode}&page=1": "q=a+b%26c%3Dd%2Fe&page=1",
+	} {
+		got, err := Eval(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{
+		"${BAD|urldecode}",
+		"${BAD|urldecode:path}",
+		"${QUERY|urlencode:fragment}",
+		"${QUERY|urlencode:path:query}",
+	} {
+		_, err := Eval(input, func(string) string { return "100%" })
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestPathJoin(t *testing.T) {
+	for _, base := range []string{"https://example.com", "https://example.com/"} {
+		m := func(s string) string {
+			return map[string]string{"BASE": base, "LEADING": "/api", "PLAIN": "api", "ID": "42/"}[s]
+		}
+
+		for input, want := range map[string]string{
+			"${BASE|pathjoin:${LEADING}}":        "https://example.com/api",
+			"${BASE|pathjoin:${PLAIN}}":          "https://example.com/api",
+			"${BASE|pathjoin:${PLAIN}:${ID}}":    "https://example.com/api/42/",
+			"${BASE|pathjoin:${UNSET}:${PLAIN}}": "https://example.com/api",
+			"${BASE|pathjoin}":                   base,
+		} {
+			got, err := Eval(input, m)
+			assert.Nil(t, err, input)
+			assert.Equal(t, want, got, input)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	m := func(s string) string {
+		return map[string]string{"PATH": "/usr/local/bin:/usr/bin:/bin", "CSV": "a,b,,d", "I": "1"}[s]
+	}
+
+	for input, want := range map[string]string{
+		"${PATH|split::0}":      "/usr/local/bin",
+		"${PATH|split::2}":      "/bin",
+		"${PATH|split::-1}":     "/bin",
+		"${PATH|split::-3}":     "/usr/local/bin",
+		"${PATH|split::3}":      "",
+		"${PATH|split::-4}":     "",
+		"${CSV|split:,:2}":      "",
+		"${CSV|split:,:3}":      "d",
+		"${CSV|split:,:${I}}":   "b",
+		"${PATH|split:/usr/:1}": "local/bin:",
+		"${UNSET|split:,:0}":    "",
+	} {
+		got, err := Eval(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"${PATH|split}", "${PATH|split::}", "${PATH|split::x}", "${PATH|split::0:1}"} {
+		_, err := Eval(input, m)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	m := func(s string) (string, bool) {
+		v, ok := map[string]string{"EMPTY": "", "B": "b", "C": "c", "NAME": "C"}[s]
+		return v, ok
+	}
+
+	for input, want := range map[string]string{
+		"${|coalesce:A:B:C}":     "b",
+		"${|coalesce:EMPTY:C:B}": "c",
+		"${|coalesce:A:EMPTY}":   "",
+		"${|coalesce}":           "",
+		"${B|coalesce:C}":        "b",
+		"${EMPTY|coalesce:A:C}":  "c",
+		"${|coalesce:A:${NAME}}": "c",
+		"[${|coalesce:A}]":       "[]",
+		"${X:-${|coalesce:A:B}}": "b",
+	} {
+		got, err := EvalSet(input, m)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	// strict evaluation does not report the missing variable of a call
+	tmpl, err := ParseWithOptions("${|coalesce:A:B}", &Options{Strict: true})
+	assert.Nil(t, err)
+	got, err := tmpl.ExecuteSet(m)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", got)
+}
+
+func TestRegisterMappingFunc(t *testing.T) {
+	unregisterFunc(t, "indirect")
+	assert.Nil(t, RegisterMappingFunc("indirect", func(lookup MappingSet, value string, args ...string) (string, error) {
+		v, _ := lookup(value)
+		return v, nil
+	}))
+
+	got, err := Eval("${REF|indirect}", func(s string) string {
+		return map[string]string{"REF": "TARGET", "TARGET": "value"}[s]
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", got)
+
+	// errors of the mapping fail the substitution
+	failed := errors.New("lookup failed")
+	_, err = EvalE("${|coalesce:A:B}", func(string) (string, error) {
+		return "", failed
+	})
+	var mappingErr *MappingError
+	assert.True(t, errors.As(err, &mappingErr))
+	assert.Equal(t, "A", mappingErr.Name)
+	assert.True(t, errors.Is(err, failed))
+
+	assert.NotNil(t, RegisterMappingFunc("indirect", coalesce))
+	assert.NotNil(t, RegisterMappingFunc("none", nil))
+}
+
+// unregisterFunc removes a function registered by a test, so that the
+// test can run again.
+func unregisterFunc(t *testing.T, name string) {
+	t.Cleanup(func() {
+		funcMu.Lock()
+		defer funcMu.Unlock()
+		delete(funcs, name)
+		parseCache.reset()
+	})
+}
+
+func TestRegisterFunc(t *testing.T) {
+	unregisterFunc(t, "repeat")
+	assert.Nil(t, RegisterFunc("repeat", func(value string, args ...string) (string, error) {
+		return strings.Repeat(value, len(args)+1), nil
+	}))
+
+	got, err := Eval("${x|repeat} ${x|repeat::}", func(string) string { return "ab" })
+	assert.Nil(t, err)
+	assert.Equal(t, "ab ababab", got)
+
+	noop := func(value string, args ...string) (string, error) { return value, nil }
+	assert.NotNil(t, RegisterFunc("repeat", noop))
+	assert.NotNil(t, RegisterFunc("|repeat", noop))
+	assert.NotNil(t, RegisterFunc("nil", nil))
+	assert.NotNil(t, RegisterOperatorAlias("|repeat", ":-"))
+
+	assert.Nil(t, RegisterOperatorAlias("|or", ":-"))
+	assert.NotNil(t, RegisterFunc("or", noop))
+}
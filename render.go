@@ -0,0 +1,241 @@
+package envsubst
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// BinaryMode controls how binary files are handled when rendering a
+// filesystem.
+type BinaryMode int
+
+const (
+	// BinarySkip leaves binary files out of the rendered output.
+	BinarySkip BinaryMode = iota
+
+	// BinaryCopy copies binary files to the output verbatim.
+	BinaryCopy
+)
+
+// RenderOptions configures how a filesystem is rendered.
+type RenderOptions struct {
+	// Binary selects how files that do not look like text are handled.
+	Binary BinaryMode
+
+	// SubstituteNames enables substitution within file and directory
+	// names, e.g. src/${SERVICE}/main.go. It is independent of content
+	// substitution, which is always performed.
+	SubstituteNames bool
+
+	// SkipEmptyNames leaves out files and directories whose name expands
+	// to the empty string. By default an empty name is an error.
+	SkipEmptyNames bool
+
+	// Include, if not empty, restricts content substitution to the files
+	// that match one of the glob patterns, e.g. *.tpl. Exclude prevents
+	// substitution in the files that match one of its patterns. Files
+	// that are not substituted are copied verbatim, whatever their
+	// content. A pattern containing a / is matched against the file's
+	// path within the filesystem and any other against its base name,
+	// using the syntax of path.Match.
+	Include []string
+	Exclude []string
+}
+
+// ErrEmptyName is returned when a file or directory name expands to the
+// empty string.
+var ErrEmptyName = messageError(func(m *Messages) string { return m.EmptyName })
+
+// FileError records the failure to render a single file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// RenderErrors is returned when one or more files could not be rendered.
+type RenderErrors []*FileError
+
+func (e RenderErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// RenderFS walks the filesystem in, evaluates the contents of every file
+// with the mapping function and writes the results to the directory out,
+// preserving the directory structure. Binary files are skipped.
+func RenderFS(in fs.FS, out string, mapping Mapping) error {
+	return RenderFSWithOptions(in, out, mapping, nil)
+}
+
+// RenderFSWithOptions is like RenderFS but allows the caller to control how
+// the filesystem is rendered. A nil opts uses the default options.
+//
+// Rendering continues past files that fail; the returned error is a
+// RenderErrors identifying every file that could not be rendered.
+func RenderFSWithOptions(in fs.FS, out string, mapping Mapping, opts *RenderOptions) error {
+	if opts == nil {
+		opts = new(RenderOptions)
+	}
+
+	var errs RenderErrors
+	err := fs.WalkDir(in, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, &FileError{Path: name, Err: err})
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := renderName(name, mapping, opts)
+		switch {
+		case err == ErrEmptyName && opts.SkipEmptyNames:
+			return skip(d)
+		case err != nil:
+			errs = append(errs, &FileError{Path: name, Err: err})
+			return skip(d)
+		}
+
+		dst := filepath.Join(out, rel)
+		if d.IsDir() {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				errs = append(errs, &FileError{Path: name, Err: err})
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if err := renderFile(in, name, dst, mapping, opts); err != nil {
+			errs = append(errs, &FileError{Path: name, Err: err})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// skip returns the value that tells fs.WalkDir to pass over d.
+func skip(d fs.DirEntry) error {
+	if d.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// renderName returns the output path for the named file, relative to the
+// output directory. When name substitution is enabled each path segment is
+// evaluated separately.
+func renderName(name string, mapping Mapping, opts *RenderOptions) (string, error) {
+	if !opts.SubstituteNames || name == "." {
+		return filepath.FromSlash(name), nil
+	}
+
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		s, err := Eval(segment, mapping)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case s == "":
+			return "", ErrEmptyName
+		case s == "." || s == ".." || strings.ContainsAny(s, `/\`):
+			return "", fmt.Errorf(currentMessages().InvalidName, s)
+		}
+		segments[i] = s
+	}
+	return filepath.Join(segments...), nil
+}
+
+// renderFile evaluates the named file from in and writes the result to dst.
+func renderFile(in fs.FS, name, dst string, mapping Mapping, opts *RenderOptions) error {
+	b, err := fs.ReadFile(in, name)
+	if err != nil {
+		return err
+	}
+
+	info, err := fs.Stat(in, name)
+	if err != nil {
+		return err
+	}
+	perm := info.Mode().Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+
+	ok, err := opts.substitutes(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return os.WriteFile(dst, b, perm)
+	}
+
+	if isBinary(b) {
+		if opts.Binary == BinaryCopy {
+			return os.WriteFile(dst, b, perm)
+		}
+		return nil
+	}
+
+	s, err := Eval(string(b), mapping)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte(s), perm)
+}
+
+// substitutes reports whether the contents of the named file are to be
+// substituted, according to the Include and Exclude patterns.
+func (o *RenderOptions) substitutes(name string) (bool, error) {
+	if len(o.Include) != 0 {
+		ok, err := matchAny(o.Include, name)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+	ok, err := matchAny(o.Exclude, name)
+	return !ok, err
+}
+
+// matchAny reports whether the named file matches any of the patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		target := path.Base(name)
+		if strings.Contains(pattern, "/") {
+			target = name
+		}
+		ok, err := path.Match(pattern, target)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// isBinary reports whether b looks like the contents of a binary file.
+func isBinary(b []byte) bool {
+	return bytes.IndexByte(b, 0) != -1 || !utf8.Valid(b)
+}
@@ -0,0 +1,162 @@
+package envsubst
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderFS(t *testing.T) {
+	in := fstest.MapFS{
+		"readme.md":       {Data: []byte("# ${NAME}")},
+		"src/main.go":     {Data: []byte(`const name = "${NAME:-none}"`)},
+		"assets/logo.png": {Data: []byte("\x89PNG\x00${NAME}")},
+	}
+	m := func(s string) string {
+		return map[string]string{"NAME": "app"}[s]
+	}
+
+	out := t.TempDir()
+	err := RenderFS(in, out, m)
+	assert.Nil(t, err)
+
+	b, err := os.ReadFile(filepath.Join(out, "readme.md"))
+	assert.Nil(t, err)
+	assert.Equal(t, "# app", string(b))
+
+	b, err = os.ReadFile(filepath.Join(out, "src", "main.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, `const name = "app"`, string(b))
+
+	_, err = os.Stat(filepath.Join(out, "assets", "logo.png"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRenderFSCopyBinary(t *testing.T) {
+	in := fstest.MapFS{
+		"logo.png": {Data: []byte("\x89PNG\x00${NAME}")},
+	}
+
+	out := t.TempDir()
+	err := RenderFSWithOptions(in, out, os.Getenv, &RenderOptions{Binary: BinaryCopy})
+	assert.Nil(t, err)
+
+	b, err := os.ReadFile(filepath.Join(out, "logo.png"))
+	assert.Nil(t, err)
+	assert.Equal(t, "\x89PNG\x00${NAME}", string(b))
+}
+
+func TestRenderFSErrors(t *testing.T) {
+	in := fstest.MapFS{
+		"a.txt": {Data: []byte("${A")},
+		"b.txt": {Data: []byte("ok")},
+		"c.txt": {Data: []byte("${C")},
+	}
+
+	out := t.TempDir()
+	err := RenderFS(in, out, os.Getenv)
+
+	var errs RenderErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "a.txt", errs[0].Path)
+	assert.Equal(t, "c.txt", errs[1].Path)
+
+	b, err := os.ReadFile(filepath.Join(out, "b.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(b))
+}
+
+func TestRenderFSSubstituteNames(t *testing.T) {
+	in := fstest.MapFS{
+		"src/${SERVICE}/${VERSION}/${SERVICE}.go": {Data: []byte("package ${SERVICE}")},
+	}
+	m := func(s string) string {
+		return map[string]string{"SERVICE": "billing", "VERSION": "v2"}[s]
+	}
+
+	out := t.TempDir()
+	err := RenderFSWithOptions(in, out, m, &RenderOptions{SubstituteNames: true})
+	assert.Nil(t, err)
+
+	b, err := os.ReadFile(filepath.Join(out, "src", "billing", "v2", "billing.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, "package billing", string(b))
+
+	// names are left alone unless substitution is enabled
+	out = t.TempDir()
+	err = RenderFS(in, out, m)
+	assert.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(out, "src", "${SERVICE}", "${VERSION}", "${SERVICE}.go"))
+	assert.Nil(t, err)
+}
+
+func TestRenderFSEmptyNames(t *testing.T) {
+	in := fstest.MapFS{
+		"${OPTIONAL}/config.yaml": {Data: []byte("key: value")},
+		"main.go":                 {Data: []byte("package main")},
+	}
+	opts := &RenderOptions{SubstituteNames: true}
+
+	out := t.TempDir()
+	err := RenderFSWithOptions(in, out, os.Getenv, opts)
+
+	var errs RenderErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "${OPTIONAL}", errs[0].Path)
+	assert.True(t, errors.Is(errs[0], ErrEmptyName))
+
+	opts.SkipEmptyNames = true
+	out = t.TempDir()
+	err = RenderFSWithOptions(in, out, os.Getenv, opts)
+	assert.Nil(t, err)
+
+	entries, err := os.ReadDir(out)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "main.go", entries[0].Name())
+}
+
+func TestRenderFSIncludeExclude(t *testing.T) {
+	in := fstest.MapFS{
+		"config.yaml.tpl":   {Data: []byte("name: ${NAME}")},
+		"docs/readme.tpl":   {Data: []byte("# ${NAME}")},
+		"docs/example.tpl":  {Data: []byte("run ${NAME}")},
+		"assets/logo.png":   {Data: []byte("\x89PNG\x00${NAME}")},
+		"scripts/run.sh":    {Data: []byte(`echo "${NAME}"`)},
+		"templates/raw.tpl": {Data: []byte("${NAME}")},
+	}
+	m := func(s string) string {
+		return map[string]string{"NAME": "app"}[s]
+	}
+
+	out := t.TempDir()
+	err := RenderFSWithOptions(in, out, m, &RenderOptions{
+		Include: []string{"*.tpl"},
+		Exclude: []string{"templates/*", "example.*"},
+	})
+	assert.Nil(t, err)
+
+	for name, want := range map[string]string{
+		"config.yaml.tpl":   "name: app",
+		"docs/readme.tpl":   "# app",
+		"docs/example.tpl":  "run ${NAME}",
+		"assets/logo.png":   "\x89PNG\x00${NAME}",
+		"scripts/run.sh":    `echo "${NAME}"`,
+		"templates/raw.tpl": "${NAME}",
+	} {
+		b, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		assert.Nil(t, err, name)
+		assert.Equal(t, want, string(b), name)
+	}
+
+	err = RenderFSWithOptions(in, t.TempDir(), m, &RenderOptions{Include: []string{"["}})
+	var errs RenderErrors
+	assert.True(t, errors.As(err, &errs))
+}
@@ -0,0 +1,143 @@
+package envsubst
+
+import (
+	"strings"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// resolver is the form every mapping function is adapted to internally. It
+// returns the value of the named variable and whether it is set.
+type resolver func(name string, ctx ResolveContext) (string, bool, error)
+
+// simpleResolver adapts a mapping function that cannot report whether a
+// variable is set. Empty values are treated as unset.
+func simpleResolver(mapping Mapping) resolver {
+	return func(name string, _ ResolveContext) (string, bool, error) {
+		v := mapping(name)
+		return v, v != "", nil
+	}
+}
+
+// ResolveMapping is a function that maps a variable name to its value,
+// given the context in which the variable is resolved. It reports whether
+// the variable is set.
+type ResolveMapping func(name string, ctx ResolveContext) (string, bool)
+
+// ResolveContext describes where in the template a variable is being
+// resolved.
+type ResolveContext struct {
+	node      *parse.FuncNode
+	inDefault bool
+}
+
+// Orig returns the original text of the substitution that references the
+// variable, e.g. `${var:-default}`.
+func (c ResolveContext) Orig() string {
+	return parse.FormatNode(c.node)
+}
+
+// Operator returns the string representing the shell-style substitution
+// function applied to the variable, e.g. `:-`. It is empty for a plain
+// reference.
+func (c ResolveContext) Operator() string {
+	return c.node.Name
+}
+
+// InDefault reports whether the variable is being resolved as part of the
+// default value of another substitution, e.g. `${B}` in `${A:-${B}}`.
+func (c ResolveContext) InDefault() bool {
+	return c.inDefault
+}
+
+// EvalResolve replaces ${var} in the string based on a mapping function
+// that is given the context of each resolution.
+func EvalResolve(s string, mapping ResolveMapping) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteResolve(mapping)
+}
+
+// ExecuteResolve applies a parsed template to the specified mapping, which
+// is given the context of each resolution.
+func (t *Template) ExecuteResolve(mapping ResolveMapping) (string, error) {
+	return t.execute(func(name string, ctx ResolveContext) (string, bool, error) {
+		v, ok := mapping(name, ctx)
+		return v, ok, nil
+	})
+}
+
+// evalDefault returns the result of a default function, such as :- or :+,
+// given the value of the variable and whether it is set. The arguments are
+// only evaluated if they are used. An empty value is unset if emptyUnset,
+// which the colon forms are by default.
+func evalDefault(node *parse.FuncNode, v string, set, emptyUnset bool, args func() ([]string, error)) (string, error) {
+	if emptyUnset && v == "" {
+		set = false
+	}
+
+	switch strings.TrimPrefix(node.Name, ":") {
+	case "+":
+		if !set {
+			return "", nil
+		}
+		return joinArgs(args())
+	case "?":
+		if set {
+			return v, nil
+		}
+		msg, err := joinArgs(args())
+		if err != nil {
+			return "", err
+		}
+		return "", &UnsetError{Name: node.Param, Message: msg}
+	default:
+		if set {
+			return v, nil
+		}
+		return joinArgs(args())
+	}
+}
+
+// emptyUnset reports whether the default function treats an empty value
+// as unset, according to its form and the options.
+func (t *Template) emptyUnset(name string) bool {
+	switch {
+	case t.opts.TreatEmptyAsUnset:
+		return true
+	case t.opts.TreatEmptyAsSet:
+		return false
+	}
+	return strings.HasPrefix(name, ":")
+}
+
+// joinArgs concatenates the evaluated arguments of a substitution function.
+func joinArgs(args []string, err error) (string, error) {
+	return strings.Join(args, ""), err
+}
+
+// UnsetError is returned by ${var:?message} when var is unset or empty, and
+// by ${var?message} when var is unset.
+type UnsetError struct {
+	Name    string
+	Message string
+}
+
+func (e *UnsetError) Error() string {
+	if e.Message == "" {
+		return e.Name + ": " + currentMessages().ParameterNotSet
+	}
+	return e.Name + ": " + e.Message
+}
+
+// isDefaultFunc reports whether the named substitution function only uses
+// its arguments depending on whether the variable is set.
+func isDefaultFunc(name string) bool {
+	switch name {
+	case "-", "=", "?", "+", ":-", ":=", ":?", ":+":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalResolve(t *testing.T) {
+	type resolution struct {
+		name, orig, operator string
+		inDefault            bool
+	}
+	var got []resolution
+	m := func(name string, ctx ResolveContext) (string, bool) {
+		got = append(got, resolution{name, ctx.Orig(), ctx.Operator(), ctx.InDefault()})
+		if name == "host" {
+			return "example.com", true
+		}
+		return "", false
+	}
+
+	out, err := EvalResolve("${port:-${fallback,,}} ${host}", m)
+	assert.Nil(t, err)
+	assert.Equal(t, " example.com", out)
+
+	assert.Equal(t, []resolution{
+		{"port", "${port:-${fallback,,}}", ":-", false},
+		{"fallback", "${fallback,,}", ",,", true},
+		{"host", "${host}", "", false},
+	}, got)
+}
+
+func TestTreatEmpty(t *testing.T) {
+	mapping := func(name string) (string, bool) {
+		if name == "EMPTY" {
+			return "", true
+		}
+		return "", false
+	}
+	input := "[${EMPTY:-d}] [${EMPTY-d}] [${EMPTY:+a}] [${EMPTY+a}] [${UNSET:-d}] [${UNSET-d}]"
+
+	tests := []struct {
+		opts *Options
+		want string
+	}{
+		{nil, "[d] [] [] [a] [d] [d]"},
+		{&Options{TreatEmptyAsSet: true}, "[] [] [a] [a] [d] [d]"},
+		{&Options{TreatEmptyAsUnset: true}, "[d] [d] [] [] [d] [d]"},
+		{&Options{TreatEmptyAsSet: true, TreatEmptyAsUnset: true}, "[d] [d] [] [] [d] [d]"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseWithOptions(input, test.opts)
+		assert.Nil(t, err)
+		out, err := tmpl.ExecuteSet(mapping)
+		assert.Nil(t, err)
+		assert.Equal(t, test.want, out, "%+v", test.opts)
+	}
+
+	// ${var:?} fails for an empty variable unless it is treated as set
+	tmpl, err := ParseWithOptions("${EMPTY:?} ${EMPTY?}", &Options{TreatEmptyAsSet: true})
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteSet(mapping)
+	assert.Nil(t, err)
+
+	tmpl, err = ParseWithOptions("${EMPTY?}", &Options{TreatEmptyAsUnset: true})
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteSet(mapping)
+	assert.Equal(t, "EMPTY: parameter null or not set", err.Error())
+}
+
+func TestResolveBareArgs(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"othervar": "other", "path": "/srv/app", "ROOT": "/opt"}[name]
+	}
+	input := "${VAR:-othervar} ${VAR-missing} ${othervar:+path} ${path/#\\/srv/ROOT} ${VAR:-othervar-x}"
+
+	out, err := EvalWithOptions(input, mapping, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "othervar missing path ROOT/app othervar-x", out)
+
+	out, err = EvalWithOptions(input, mapping, &Options{ResolveBareArgs: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "other missing /srv/app /opt/app othervar-x", out)
+
+	// error messages and patterns stay literal
+	out, err = EvalWithOptions("${path/srv/ROOT} ${path/ROOT/x}", mapping, &Options{ResolveBareArgs: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "//opt/app /srv/app", out)
+	_, err = EvalWithOptions("${VAR:?othervar}", mapping, &Options{ResolveBareArgs: true})
+	assert.Equal(t, "VAR: othervar", err.Error())
+}
+
+func TestTrimDefaultWhitespace(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"SET": "value", "SPACED": " a b "}[name]
+	}
+	input := "[${var:- x}] [${var-  x ${SPACED} }] [${SET:+ alt }] [${SPACED:- x}] [${SET/e/ E }]"
+
+	out, err := EvalWithOptions(input, mapping, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "[ x] [  x  a b  ] [ alt ] [ a b ] [valu E ]", out)
+
+	out, err = EvalWithOptions(input, mapping, &Options{TrimDefaultWhitespace: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "[x] [x  a b] [alt] [ a b ] [valu E ]", out)
+
+	_, err = EvalWithOptions("${var:? is required }", mapping, &Options{TrimDefaultWhitespace: true})
+	assert.Equal(t, "var: is required", err.Error())
+}
+
+func TestIndirectDefaults(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"A": "set", "B": "TARGET", "TARGET": "value", "BAD": "not a name"}[name]
+	}
+	input := "${A:-!B} ${MISSING:-!B} ${MISSING-!B} ${MISSING:-!UNSET} ${MISSING:-!BAD} ${MISSING:-!B-x}"
+
+	out, err := EvalWithOptions(input, mapping, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "set !B !B !UNSET !BAD !B-x", out)
+
+	out, err = EvalWithOptions(input, mapping, &Options{IndirectDefaults: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "set value value   !B-x", out)
+
+	// alternates and error messages stay literal
+	out, err = EvalWithOptions("${A:+!B}", mapping, &Options{IndirectDefaults: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "!B", out)
+	_, err = EvalWithOptions("${MISSING:?!B}", mapping, &Options{IndirectDefaults: true})
+	assert.Equal(t, "MISSING: !B", err.Error())
+
+	// both variables are resolved as within a default
+	var contexts []bool
+	tmpl, err := ParseWithOptions("${MISSING:-!B}", &Options{IndirectDefaults: true})
+	assert.Nil(t, err)
+	out, err = tmpl.ExecuteResolve(func(name string, ctx ResolveContext) (string, bool) {
+		if name != "MISSING" {
+			contexts = append(contexts, ctx.InDefault())
+		}
+		v := mapping(name)
+		return v, v != ""
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", out)
+	assert.Equal(t, []bool{true, true}, contexts)
+}
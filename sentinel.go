@@ -0,0 +1,17 @@
+package envsubst
+
+// sentinels returns a resolver that treats the variables that mapping
+// resolves to one of the UnsetSentinels option as unset.
+func (t *Template) sentinels(mapping resolver) resolver {
+	if len(t.opts.UnsetSentinels) == 0 {
+		return mapping
+	}
+	sentinels := t.opts.UnsetSentinels
+	return func(name string, ctx ResolveContext) (string, bool, error) {
+		v, set, err := mapping(name, ctx)
+		if err != nil || !set || !contains(sentinels, v) {
+			return v, set, err
+		}
+		return "", false, nil
+	}
+}
@@ -0,0 +1,47 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsetSentinels(t *testing.T) {
+	mapping := func(s string) (string, bool) {
+		v, ok := map[string]string{
+			"NULL":  "null",
+			"NIL":   "<nil>",
+			"EMPTY": "",
+			"VALUE": "nullable",
+		}[s]
+		return v, ok
+	}
+	opts := &Options{UnsetSentinels: []string{"null", "<nil>"}}
+
+	for input, want := range map[string]string{
+		"${NULL:-default}":  "default",
+		"${NIL:-default}":   "default",
+		"${NULL-default}":   "default",
+		"${NULL:+alt}":      "",
+		"${NULL}":           "",
+		"${EMPTY-default}":  "",
+		"${VALUE:-default}": "nullable",
+	} {
+		tmpl, err := ParseWithOptions(input, opts)
+		assert.Nil(t, err, input)
+		out, err := tmpl.ExecuteSet(mapping)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, out, input)
+	}
+
+	// sentinels are ordinary values by default
+	out, err := EvalSet("${NULL:-default}", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "null", out)
+
+	// a sentinel is reported by strict evaluation
+	tmpl, err := ParseWithOptions("${NIL}", &Options{Strict: true, UnsetSentinels: []string{"<nil>"}})
+	assert.Nil(t, err)
+	_, err = tmpl.ExecuteSet(mapping)
+	assert.IsType(t, &UnsetError{}, err)
+}
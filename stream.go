@@ -0,0 +1,308 @@
+package envsubst
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// StreamError reports a syntax error found by ValidateStream or EvalStream
+// and where in the stream it was detected.
+type StreamError struct {
+	Err    error // the *parse.SyntaxError describing the error
+	Offset int64 // byte offset in the stream at which the error was detected
+	Line   int   // 1-based line at which the error was detected
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateStream reports the first syntax error in the template read from
+// r as a *StreamError, or nil if it parses. Nothing is evaluated. The
+// input is read incrementally and only one substitution is held in memory
+// at a time, so that large files can be checked without loading them.
+func ValidateStream(r io.Reader) error {
+	v := &streamValidator{r: bufio.NewReader(r), line: 1}
+	return v.split(func(fragment string, start int64, line int) error {
+		_, err := v.parse(fragment, start, line)
+		return err
+	})
+}
+
+// EvalStream replaces ${var} in the template read from r based on the
+// mapping function and writes the result to w. The input is read and
+// written incrementally, a line or a substitution at a time, so that large
+// files can be expanded without loading them. Syntax errors are reported
+// as a *StreamError. If evaluation fails, such as for ${var:?message}, the
+// output up to the failure is written before the error is returned, and
+// nothing is written after it. The context is checked between fragments,
+// so that a long stream can be cancelled.
+func EvalStream(ctx context.Context, w io.Writer, r io.Reader, mapping Mapping) error {
+	v := &streamValidator{r: bufio.NewReader(r), line: 1, keepText: true}
+	return v.split(func(fragment string, start int64, line int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, err := v.parse(fragment, start, line)
+		if err != nil {
+			return err
+		}
+		out, err := t.Execute(mapping)
+		if _, werr := io.WriteString(w, out); werr != nil {
+			return werr
+		}
+		return err
+	})
+}
+
+// EvalLines replaces ${var} in each line read from r based on the mapping
+// function and writes the result to w, a line at a time. Each line is
+// evaluated on its own, so that a substitution cannot span lines, and its
+// line ending, \n or \r\n, is written as it was read. Syntax errors, such
+// as an unterminated ${, are reported as a *StreamError with the line. If
+// evaluation fails the output of the line up to the failure is written
+// before the error is returned.
+func EvalLines(r io.Reader, w io.Writer, mapping Mapping) error {
+	br := bufio.NewReader(r)
+	var v streamValidator
+	var offset int64
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		text := strings.TrimSuffix(line, "\n")
+		eol := line[len(text):]
+		if strings.HasSuffix(text, "\r") && eol != "" {
+			text, eol = text[:len(text)-1], "\r\n"
+		}
+
+		t, perr := v.parse(text, offset, n)
+		if perr != nil {
+			return perr
+		}
+		out, eerr := t.Execute(mapping)
+		if _, werr := io.WriteString(w, out); werr != nil {
+			return werr
+		}
+		if eerr != nil {
+			return eerr
+		}
+		if _, werr := io.WriteString(w, eol); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		offset += int64(len(line))
+	}
+}
+
+// streamValidator splits a stream into fragments that can be parsed one at
+// a time. Only the substitutions are kept unless keepText is set, in which
+// case the text between them is kept too and split at line ends.
+type streamValidator struct {
+	r        *bufio.Reader
+	offset   int64 // bytes read so far
+	line     int   // line of the next byte
+	keepText bool
+}
+
+// split calls emit with each fragment of the stream, the byte offset at
+// which it starts and its line.
+func (v *streamValidator) split(emit func(fragment string, start int64, line int) error) error {
+	var b strings.Builder
+	start, line := v.offset, v.line
+	flush := func() error {
+		defer func() {
+			b.Reset()
+			start, line = v.offset, v.line
+		}()
+		if b.Len() == 0 {
+			return nil
+		}
+		return emit(b.String(), start, line)
+	}
+
+	for {
+		r, err := v.read()
+		if err == io.EOF {
+			if !v.keepText {
+				return nil
+			}
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		if v.keepText {
+			b.WriteRune(r)
+		}
+		if r == '\n' && v.keepText {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if r != '$' {
+			continue
+		}
+
+		// a $ followed by another may still start the substitution that
+		// follows, so only braces and parentheses are consumed here
+		if !v.keepText && (v.peek() == '{' || v.peek() == '(') {
+			b.Reset()
+			b.WriteRune(r)
+			start, line = v.offset-1, v.line
+		}
+		switch v.peek() {
+		case '{':
+			err = v.readSubst(&b)
+		case '(':
+			// an unbalanced $( is text, so the remainder of the input
+			// is parsed with it
+			if err = v.readCommand(&b); err == io.EOF {
+				err = v.readAll(&b)
+			}
+		default:
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// readSubst reads a substitution, ${...}, up to and including the brace
+// that closes it. Only the braces of nested substitutions are counted, as
+// a lone { is text to the parser.
+func (v *streamValidator) readSubst(b *strings.Builder) error {
+	r, _ := v.read() // {
+	b.WriteRune(r)
+
+	depth := 1
+	for {
+		r, err := v.read()
+		if err != nil {
+			return err
+		}
+		b.WriteRune(r)
+
+		switch r {
+		case '}':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		case '\\':
+			switch v.peek() {
+			case '/', '\\', '}':
+				r, _ := v.read()
+				b.WriteRune(r)
+			}
+		case '$':
+			switch v.peek() {
+			case '{':
+				r, _ := v.read()
+				b.WriteRune(r)
+				depth++
+			case '(':
+				if err := v.readCommand(b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// readCommand reads a command substitution, $(...), up to and including
+// the parenthesis that balances the first.
+func (v *streamValidator) readCommand(b *strings.Builder) error {
+	depth := 0
+	for {
+		r, err := v.read()
+		if err != nil {
+			return err
+		}
+		b.WriteRune(r)
+
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// readAll reads the remainder of the stream.
+func (v *streamValidator) readAll(b *strings.Builder) error {
+	for {
+		r, err := v.read()
+		if err != nil {
+			return err
+		}
+		b.WriteRune(r)
+	}
+}
+
+// parse parses the fragment of the stream that starts at offset start, on
+// the given line, and returns its syntax error translated to the stream.
+func (v *streamValidator) parse(fragment string, start int64, line int) (*Template, error) {
+	t, err := Parse(fragment)
+	var syntaxErr *parse.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return t, err
+	}
+
+	offset := syntaxErr.Offset
+	if offset > len(fragment) {
+		offset = len(fragment)
+	}
+	return nil, &StreamError{
+		Err:    syntaxErr,
+		Offset: start + int64(offset),
+		Line:   line + strings.Count(fragment[:offset], "\n"),
+	}
+}
+
+func (v *streamValidator) read() (rune, error) {
+	r, size, err := v.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	v.offset += int64(size)
+	if r == '\n' {
+		v.line++
+	}
+	return r, nil
+}
+
+// peek returns the next byte without consuming it, or 0 at the end of the
+// stream.
+func (v *streamValidator) peek() byte {
+	b, err := v.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
@@ -0,0 +1,158 @@
+package envsubst
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/logandavies181/envsubst/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStream(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"text only",
+		"a ${b} $c $$ $${d} ${e:-${f:-$(g)}} ${h//\\}/x}",
+		"$(echo ${unclosed) ${a}",
+		"${a:-{} trailing {",
+		"$( unbalanced ${a}",
+	} {
+		assert.Nil(t, ValidateStream(strings.NewReader(input)), input)
+	}
+
+	// the offsets agree with those of Parse
+	for _, input := range []string{
+		"${a",
+		"line\n${a:-${b}",
+		"${a} ${b/}",
+		"text $(cmd) ${a,,x}",
+		"$${a:-${b^^c}}",
+		"$( unbalanced ${a",
+	} {
+		err := ValidateStream(strings.NewReader(input))
+		var streamErr *StreamError
+		if !assert.True(t, errors.As(err, &streamErr), input) {
+			continue
+		}
+
+		_, want := Parse(input)
+		var syntaxErr *parse.SyntaxError
+		assert.True(t, errors.As(want, &syntaxErr), input)
+		assert.Equal(t, int64(syntaxErr.Offset), streamErr.Offset, input)
+
+		line, _ := syntaxErr.Position()
+		assert.Equal(t, line, streamErr.Line, input)
+	}
+}
+
+// repeatReader produces n copies of s without holding them in memory.
+type repeatReader struct {
+	s   string
+	n   int
+	buf string
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.buf == "" {
+		if r.n == 0 {
+			return 0, io.EOF
+		}
+		r.buf = r.s
+		r.n--
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func TestValidateStreamLarge(t *testing.T) {
+	line := "key: ${VALUE:-default} $(cmd) $$ text\n"
+	lines := 200000
+	r := io.MultiReader(&repeatReader{s: line, n: lines}, strings.NewReader("last: ${BROKEN"))
+
+	err := ValidateStream(r)
+	var streamErr *StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.True(t, errors.Is(err, parse.ErrMissingClosingBrace))
+	assert.Equal(t, int64(len(line)*lines+len("last: ${BROKEN")), streamErr.Offset)
+	assert.Equal(t, lines+1, streamErr.Line)
+}
+
+func TestEvalStream(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"NAME": "app", "PORT": "8080"}[name]
+	}
+
+	// the output agrees with that of Eval
+	for _, input := range []string{
+		"",
+		"text only\nline two\n",
+		"name: ${NAME}\nport: $PORT\n",
+		"$$NAME $${NAME} \\$NAME \\\\$NAME\n\\",
+		"${UNSET:-${NAME:-x}\n} ${NAME/a/\\}} $(cmd)\n$",
+	} {
+		var b bytes.Buffer
+		err := EvalStream(context.Background(), &b, strings.NewReader(input), mapping)
+		assert.Nil(t, err, input)
+
+		want, _ := Eval(input, mapping)
+		assert.Equal(t, want, b.String(), input)
+	}
+
+	// the output up to the failure is written once
+	var b bytes.Buffer
+	input := "name: ${NAME}\nport: ${PORT}\nhost: ${HOST:?is required}\nlast: ${NAME}\n"
+	err := EvalStream(context.Background(), &b, strings.NewReader(input), mapping)
+	var unsetErr *UnsetError
+	assert.True(t, errors.As(err, &unsetErr))
+	assert.Equal(t, "HOST: is required", err.Error())
+	assert.Equal(t, "name: app\nport: 8080\nhost: ", b.String())
+
+	// syntax errors are reported at their place in the stream
+	b.Reset()
+	err = EvalStream(context.Background(), &b, strings.NewReader("a: ${NAME}\nb: ${NAME"), mapping)
+	var streamErr *StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.Equal(t, int64(20), streamErr.Offset)
+	assert.Equal(t, 2, streamErr.Line)
+	assert.Equal(t, "a: app\n", b.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Reset()
+	err = EvalStream(ctx, &b, strings.NewReader("${NAME}"), mapping)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "", b.String())
+}
+
+func TestEvalLines(t *testing.T) {
+	mapping := func(name string) string {
+		return map[string]string{"NAME": "app", "PORT": "8080"}[name]
+	}
+
+	var b bytes.Buffer
+	input := "name=${NAME}\r\nport=$PORT\n\nboth=${NAME}:${PORT:-80}\ncr\r\rlast=${MISSING:-none}"
+	err := EvalLines(strings.NewReader(input), &b, mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "name=app\r\nport=8080\n\nboth=app:8080\ncr\r\rlast=none", b.String())
+
+	// substitutions do not span lines
+	b.Reset()
+	err = EvalLines(strings.NewReader("a: ${NAME}\nb: ${NAME:-x\n}\n"), &b, mapping)
+	var streamErr *StreamError
+	if assert.True(t, errors.As(err, &streamErr)) {
+		assert.Equal(t, 2, streamErr.Line)
+		assert.Equal(t, int64(23), streamErr.Offset)
+		assert.True(t, strings.HasPrefix(err.Error(), "line 2: "), err.Error())
+	}
+	assert.Equal(t, "a: app\n", b.String())
+
+	b.Reset()
+	err = EvalLines(strings.NewReader("ok ${NAME}\nhost: ${HOST:?is required} ${NAME}\nnext\n"), &b, mapping)
+	assert.Equal(t, "HOST: is required", err.Error())
+	assert.Equal(t, "ok app\nhost: ", b.String())
+}
@@ -0,0 +1,63 @@
+package envsubst
+
+import (
+	"os"
+	"strings"
+
+	"github.com/logandavies181/envsubst/parse"
+)
+
+// UnsetErrors is returned by strict evaluation that reports every unset
+// variable. Each variable is named once, in the order in which it was
+// first found.
+type UnsetErrors []*UnsetError
+
+func (e UnsetErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// EvalEnvStrict replaces ${var} in the string according to the values of
+// the current environment variables, like EvalEnv, but fails with an
+// *UnsetError naming the first referenced variable that is not present in
+// the environment and has no default. Variables set to the empty string
+// are present.
+func EvalEnvStrict(s string) (string, error) {
+	t, err := ParseWithOptions(s, &Options{Strict: true})
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteSet(os.LookupEnv)
+}
+
+// evalUnset returns the replacement for a substitution whose variable is
+// unset and has no default. In strict mode the variable is reported.
+func (t *Template) evalUnset(s *state, node *parse.FuncNode) (string, error) {
+	if t.opts.Strict {
+		err := &UnsetError{Name: node.Param, Message: currentMessages().UnboundVariable}
+		if !t.opts.ReportAllUnset {
+			return "", err
+		}
+		if !s.reported(node.Param) {
+			s.unset = append(s.unset, err)
+		}
+	}
+
+	if t.opts.UnsetPlaceholder != nil {
+		return t.opts.UnsetPlaceholder(node.Param), nil
+	}
+	return "", nil
+}
+
+// reported reports whether the unset variable has already been found.
+func (s *state) reported(name string) bool {
+	for _, err := range s.unset {
+		if err.Name == name {
+			return true
+		}
+	}
+	return false
+}
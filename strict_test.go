@@ -0,0 +1,57 @@
+package envsubst
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalEnvStrict(t *testing.T) {
+	t.Setenv("ENVSUBST_SET", "value")
+	t.Setenv("ENVSUBST_EMPTY", "")
+
+	for input, want := range map[string]string{
+		"${ENVSUBST_SET} [$ENVSUBST_EMPTY]":          "value []",
+		"${ENVSUBST_MISSING:-default}":               "default",
+		"${ENVSUBST_MISSING:=default}":               "default",
+		"[${ENVSUBST_MISSING:+alternate}]":           "[]",
+		"${ENVSUBST_SET:-${ENVSUBST_MISSING}}":       "value",
+		"${ENVSUBST_MISSING:-${ENVSUBST_SET}} again": "value again",
+	} {
+		got, err := EvalEnvStrict(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err := EvalEnvStrict("${ENVSUBST_SET} ${ENVSUBST_MISSING} ${ENVSUBST_OTHER}")
+	var unsetErr *UnsetError
+	assert.True(t, errors.As(err, &unsetErr))
+	assert.Equal(t, "ENVSUBST_MISSING", unsetErr.Name)
+	assert.Equal(t, "ENVSUBST_MISSING: unbound variable", err.Error())
+
+	// variables in a default that is used are checked too
+	_, err = EvalEnvStrict("${ENVSUBST_MISSING:-${ENVSUBST_OTHER^^}}")
+	assert.True(t, errors.As(err, &unsetErr))
+	assert.Equal(t, "ENVSUBST_OTHER", unsetErr.Name)
+}
+
+func TestStrictReportAllUnset(t *testing.T) {
+	mapping := func(s string) string {
+		return map[string]string{"set": "value"}[s]
+	}
+	opts := &Options{Strict: true, ReportAllUnset: true}
+
+	_, err := EvalWithOptions("${a} ${set} ${b:-x} $c ${a}", mapping, opts)
+
+	var errs UnsetErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "a", errs[0].Name)
+	assert.Equal(t, "c", errs[1].Name)
+	assert.Equal(t, "a: unbound variable; c: unbound variable", err.Error())
+
+	out, err := EvalWithOptions("${set}", mapping, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", out)
+}
@@ -0,0 +1,107 @@
+package envsubst
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StructMapping returns a mapping that resolves variables from the fields
+// of the struct v, or of the struct v points to. A variable names a field
+// by its envsubst tag, e.g. `envsubst:"port"`, or by its name, and a field
+// of a nested struct by joining the names with dots, e.g. ${Server.Port},
+// which parses with Options.DottedNames. The fields of embedded structs
+// are promoted as in Go. Pointers are followed, and a nil pointer, a tag
+// of "-", an unexported field or a field that does not exist is unset.
+// Scalar fields become their usual text and fmt.Stringer values their
+// String result; other values, such as slices and maps, are unset.
+func StructMapping(v any) Mapping {
+	root := reflect.ValueOf(v)
+	return func(name string) string {
+		field := root
+		for _, part := range strings.Split(name, ".") {
+			var ok bool
+			if field, ok = structField(field, part); !ok {
+				return ""
+			}
+		}
+		return formatField(field)
+	}
+}
+
+// structField returns the field of the struct v, or the struct v points
+// to, with the given name.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	v = indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	// fields of the struct itself hide those promoted from embedded
+	// structs, as in Go
+	typ := v.Type()
+	var embedded []int
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("envsubst")
+		switch {
+		case tag == "-":
+			continue
+		case f.Anonymous && tag == "":
+			embedded = append(embedded, i)
+			continue
+		case f.PkgPath != "":
+			continue
+		}
+		if tag == name || tag == "" && f.Name == name {
+			return v.Field(i), true
+		}
+	}
+	for _, i := range embedded {
+		if field, ok := structField(v.Field(i), name); ok {
+			return field, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// indirect follows pointers and interfaces to the value they hold.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// formatField returns the text of a scalar or fmt.Stringer field.
+func formatField(v reflect.Value) string {
+	if v.IsValid() && v.CanInterface() {
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			if v.Kind() == reflect.Ptr && v.IsNil() {
+				return ""
+			}
+			return s.String()
+		}
+	}
+
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package envsubst
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCredentials struct {
+	User     string
+	Password string `envsubst:"-"`
+}
+
+type testServer struct {
+	Host    string `envsubst:"host"`
+	Port    int    `envsubst:"port"`
+	TLS     bool
+	Timeout time.Duration
+	Auth    *testCredentials
+}
+
+type testConfig struct {
+	testCredentials
+	Name    string
+	Ratio   float64
+	Server  testServer
+	Backup  *testServer
+	Tags    []string
+	private string
+}
+
+func TestStructMapping(t *testing.T) {
+	cfg := &testConfig{
+		testCredentials: testCredentials{User: "admin", Password: "secret"},
+		Name:            "app",
+		Ratio:           0.25,
+		Server: testServer{
+			Host:    "example.com",
+			Port:    8080,
+			TLS:     true,
+			Timeout: 5 * time.Second,
+			Auth:    &testCredentials{User: "svc"},
+		},
+		Tags:    []string{"a"},
+		private: "hidden",
+	}
+	m := StructMapping(cfg)
+	opts := &Options{DottedNames: true}
+
+	for input, want := range map[string]string{
+		"${Name}":                       "app",
+		"${Ratio}":                      "0.25",
+		"${Server.host}:${Server.port}": "example.com:8080",
+		"${Server.TLS}":                 "true",
+		"${Server.Timeout}":             "5s",
+		"${Server.Auth.User}":           "svc",
+		"${User}":                       "admin",
+		"${#Name}":                      "3",
+		"${Name^^}":                     "APP",
+	} {
+		got, err := EvalWithOptions(input, m, opts)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	// missing, hidden and nil fields are unset
+	for _, input := range []string{
+		"${Missing:-unset}",
+		"${Server.Missing:-unset}",
+		"${Name.Inner:-unset}",
+		"${Server.Host:-unset}",
+		"${Password:-unset}",
+		"${Backup.host:-unset}",
+		"${private:-unset}",
+		"${Tags:-unset}",
+	} {
+		got, err := EvalWithOptions(input, m, opts)
+		assert.Nil(t, err, input)
+		assert.Equal(t, "unset", got, input)
+	}
+
+	// a struct value works as well as a pointer
+	got, err := EvalWithOptions("${Server.host}", StructMapping(*cfg), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", got)
+}
@@ -2,8 +2,13 @@ package envsubst
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/logandavies181/envsubst/parse"
 )
@@ -16,7 +21,19 @@ type state struct {
 	node     parse.Node // current node
 
 	// maps variable names to values
-	mapper func(string) string
+	mapper resolver
+
+	// true while evaluating the arguments of a default function
+	inDefault bool
+
+	// true while evaluating the arguments of any function
+	inArgs bool
+
+	// unset variables found by strict evaluation
+	unset []*UnsetError
+
+	// values resolved so far, which are redacted from errors
+	values []resolved
 
 	advMapper AdvancedMapping
 }
@@ -24,16 +41,65 @@ type state struct {
 // Template is the representation of a parsed shell format string.
 type Template struct {
 	tree *parse.Tree
+	opts Options
+
+	// size is the length of the input, which the output is assumed to
+	// be at least as long as
+	size int
 }
 
 // Parse creates a new shell format template and parses the template
 // definition from string s.
 func Parse(s string) (t *Template, err error) {
+	return ParseWithOptions(s, nil)
+}
+
+// MustParse is like Parse but panics if s cannot be parsed, naming the
+// offset of the syntax error. It simplifies the initialization of global
+// variables holding templates.
+func MustParse(s string) *Template {
+	t, err := Parse(s)
+	if err != nil {
+		var syntaxErr *parse.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			panic(fmt.Sprintf("envsubst: Parse(%q): %v at offset %d", s, err, syntaxErr.Offset))
+		}
+		panic(fmt.Sprintf("envsubst: Parse(%q): %v", s, err))
+	}
+	return t
+}
+
+// ParseWithOptions is like Parse but the template is parsed and executed
+// according to opts. A nil opts uses the default options.
+func ParseWithOptions(s string, opts *Options) (t *Template, err error) {
 	t = new(Template)
-	t.tree, err = parse.Parse(s)
+	t.size = len(s)
+	if opts != nil {
+		t.opts = *opts
+	}
+	t.tree, err = (&parse.Tree{
+		Alias:               lookupAlias,
+		Func:                isFunction,
+		Namespace:           isNamespace,
+		MaxNestingDepth:     t.opts.MaxNestingDepth,
+		DisableDollarEscape: t.opts.DisableDollarEscape,
+		RecoverUnterminated: t.opts.RecoverUnterminated,
+		Conditionals:        t.opts.Conditionals,
+		Comments:            t.opts.Comments,
+		Sigil:               t.opts.Sigil,
+		StrictDollar:        t.opts.StrictDollar,
+		BackslashEscape:     t.opts.BackslashEscape,
+		DottedNames:         t.opts.DottedNames,
+	}).Parse(s)
 	if err != nil {
 		return nil, err
 	}
+	if err := t.checkVariables(); err != nil {
+		return nil, err
+	}
+	if err := t.checkCaseLanguage(); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
@@ -47,18 +113,86 @@ func ParseFile(path string) (*Template, error) {
 	return Parse(string(b))
 }
 
-// Execute applies a parsed template to the specified data mapping.
-func (t *Template) Execute(mapping func(string) string) (str string, err error) {
+// Clone returns a copy of the template, including a deep copy of its parse
+// tree, so that the copy can be changed without affecting the original.
+func (t *Template) Clone() *Template {
+	c := &Template{tree: t.tree.Copy(), opts: t.opts, size: t.size}
+	if t.opts.AllowedCommands != nil {
+		c.opts.AllowedCommands = append([]string(nil), t.opts.AllowedCommands...)
+	}
+	if t.opts.UnsetSentinels != nil {
+		c.opts.UnsetSentinels = append([]string(nil), t.opts.UnsetSentinels...)
+	}
+	return c
+}
+
+// Execute applies a parsed template to the specified data mapping. If
+// evaluation fails, the output produced before the failure is returned
+// with the error.
+func (t *Template) Execute(mapping Mapping) (str string, err error) {
+	return t.execute(simpleResolver(mapping))
+}
+
+// ExecuteE applies a parsed template to the specified data mapping. If the
+// mapping returns an error, execution stops and a *MappingError naming the
+// variable is returned.
+func (t *Template) ExecuteE(mapping func(string) (string, error)) (str string, err error) {
+	return t.execute(func(name string, _ ResolveContext) (string, bool, error) {
+		v, err := mapping(name)
+		return v, v != "", err
+	})
+}
+
+// ExecuteSet applies a parsed template to the specified data mapping,
+// which reports whether each variable is set.
+func (t *Template) ExecuteSet(mapping MappingSet) (str string, err error) {
+	return t.execute(func(name string, _ ResolveContext) (string, bool, error) {
+		v, ok := mapping(name)
+		return v, ok, nil
+	})
+}
+
+func (t *Template) execute(mapping resolver) (str string, err error) {
 	b := new(bytes.Buffer)
+	b.Grow(t.outputSize())
+	err = t.executeTo(b, mapping)
+	if err != nil {
+		// return the output up to the failure to show how far it got
+		return b.String(), err
+	}
+	str = b.String()
+	if t.opts.PostProcess != nil {
+		str, err = t.opts.PostProcess(str)
+		if err != nil {
+			return b.String(), err
+		}
+	}
+	return normalizeEOL(str, t.opts.NormalizeEOL), nil
+}
+
+// executeTo applies the template to the mapping, writing the output to w
+// as it is produced. Line endings are not normalized.
+func (t *Template) executeTo(w io.Writer, mapping resolver) error {
 	s := new(state)
 	s.node = t.tree.Root
-	s.mapper = mapping
-	s.writer = b
-	err = t.eval(s)
-	if err != nil {
-		return
+	s.mapper = t.sentinels(t.positional(t.transformNames(mapping)))
+	s.writer = w
+	if err := t.eval(s); err != nil {
+		return t.redact(s, err)
+	}
+	if len(s.unset) != 0 {
+		return UnsetErrors(s.unset)
 	}
-	return b.String(), nil
+	return nil
+}
+
+// outputSize returns the number of bytes to allocate for the output up
+// front, so that a large output is not copied each time the buffer grows.
+func (t *Template) outputSize() int {
+	if t.opts.OutputSize > 0 {
+		return t.opts.OutputSize
+	}
+	return t.size
 }
 
 func (t *Template) eval(s *state) (err error) {
@@ -69,6 +203,10 @@ func (t *Template) eval(s *state) (err error) {
 		err = t.evalFunc(s, node)
 	case *parse.ListNode:
 		err = t.evalList(s, node)
+	case *parse.CommandNode:
+		err = t.evalCommand(s, node)
+	case *parse.IfNode:
+		err = t.evalIf(s, node)
 	}
 	return err
 }
@@ -78,6 +216,46 @@ func (t *Template) evalText(s *state, node *parse.TextNode) error {
 	return err
 }
 
+// evalCommand writes a command substitution to the output verbatim so it
+// is preserved for the next stage, unless command substitution is enabled.
+func (t *Template) evalCommand(s *state, node *parse.CommandNode) error {
+	if !t.opts.AllowCommandSubstitution {
+		_, err := io.WriteString(s.writer, parse.FormatNode(node))
+		return err
+	}
+
+	out, err := t.runCommand(node.Command)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.writer, out)
+	return err
+}
+
+// evalIf evaluates the nodes of a conditional block that the value of its
+// variable selects.
+func (t *Template) evalIf(s *state, node *parse.IfNode) error {
+	v, set, err := s.mapper(node.Cond.Param, ResolveContext{node.Cond, s.inDefault})
+	if err != nil {
+		return &MappingError{Name: node.Cond.Param, Err: err}
+	}
+	if set && v != "" && t.opts.Redactor != nil {
+		s.values = append(s.values, resolved{node.Cond.Param, v})
+	}
+
+	branch := node.Then
+	if !set || v == "" {
+		branch = node.Else
+	}
+	if branch == nil {
+		return nil
+	}
+	s.node = branch
+	err = t.eval(s)
+	s.node = node
+	return err
+}
+
 func (t *Template) evalList(s *state, node *parse.ListNode) (err error) {
 	for _, n := range node.Nodes {
 		s.node = n
@@ -90,30 +268,252 @@ func (t *Template) evalList(s *state, node *parse.ListNode) (err error) {
 }
 
 func (t *Template) evalFunc(s *state, node *parse.FuncNode) error {
+	var v string
+	var set bool
+	var values []string
+	var err error
+	switch {
+	case node.Param == "":
+		// a function called without a variable, e.g. ${|coalesce:A:B},
+		// has an empty value that is never reported as unset
+		set = true
+	case isPrefixNames(node.Name):
+		v, set = t.prefixNames(node.Param), true
+	case isArrayJoin(node):
+		// the elements are joined by joinArray, and as with ${arr[@]} an
+		// array without elements is unset
+		values, err = t.lookupArray(s, node)
+		set = len(values) != 0
+	case node.Name == "@K":
+		// the name itself is the value, so the variable is never resolved
+		v, set = node.Param, true
+	case node.Index != "":
+		v, set, err = t.resolveArray(s, node)
+	case node.Namespace != "":
+		v, set = resolveNamespace(node.Namespace, node.Param)
+	default:
+		v, set, err = s.mapper(node.Param, ResolveContext{node, s.inDefault})
+	}
+	if err != nil {
+		return &MappingError{Name: node.Param, Err: err}
+	}
+	if set && v != "" && t.opts.Redactor != nil {
+		s.values = append(s.values, resolved{node.Param, v})
+	}
+
+	switch {
+	case !set && !isDefaultFunc(node.Name) && (t.opts.Strict || t.opts.UnsetPlaceholder != nil):
+		v, err = t.evalUnset(s, node)
+	case isArrayJoin(node):
+		v, err = t.joinArray(s, node, values)
+	case isDefaultFunc(node.Name):
+		// the arguments of default functions are only evaluated when they
+		// are used, so that variables in an unused default are never resolved
+		v, err = evalDefault(node, v, set, t.emptyUnset(node.Name), func() ([]string, error) {
+			if gen, ok := generatorArg(node); ok {
+				v, err := gen()
+				return []string{v}, err
+			}
+			if name, ok := t.indirectArg(node); ok {
+				v, err := t.resolveIndirect(s, node, name)
+				return []string{v}, err
+			}
+			args, err := t.evalArgs(s, node)
+			if err == nil && t.opts.TrimDefaultWhitespace {
+				args = []string{strings.TrimSpace(strings.Join(args, ""))}
+			}
+			return args, err
+		})
+	default:
+		var args []string
+		args, err = t.evalArgs(s, node)
+		if err == nil {
+			v, err = t.apply(s, node, v, args)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !s.inArgs {
+		if t.opts.Escaper != nil {
+			v = t.opts.Escaper(v)
+		}
+		v = t.affix(v)
+	}
+
+	_, err = io.WriteString(s.writer, v)
+	return err
+}
+
+// affix adds the value prefix and suffix options to the substituted value.
+func (t *Template) affix(v string) string {
+	if v == "" && t.opts.SkipEmptyAffix {
+		return v
+	}
+	return t.opts.ValuePrefix + v + t.opts.ValueSuffix
+}
+
+// evalArgs evaluates the arguments of the substitution function.
+func (t *Template) evalArgs(s *state, node *parse.FuncNode) ([]string, error) {
 	var w = s.writer
+	var inDefault = s.inDefault
+	var inArgs = s.inArgs
 	var buf bytes.Buffer
 	var args []string
-	for _, n := range node.Args {
+	s.inDefault = inDefault || isDefaultFunc(node.Name)
+	s.inArgs = true
+
+	// restore the origin writer, also on failure, as evaluation may
+	// continue past an error
+	defer func() {
+		s.writer = w
+		s.inDefault = inDefault
+		s.inArgs = inArgs
+		s.node = node
+	}()
+	for i, n := range node.Args {
+		if name, ok := t.bareArg(node, i); ok {
+			v, set, err := t.lookupArg(s, node, name)
+			if err != nil {
+				return nil, err
+			}
+			if set {
+				args = append(args, v)
+				continue
+			}
+		}
 		buf.Reset()
 		s.writer = &buf
 		s.node = n
 		err := t.eval(s)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		args = append(args, buf.String())
 	}
+	return args, nil
+}
 
-	// restore the origin writer
-	s.writer = w
-	s.node = node
+// bareArg returns the name that the i-th argument of node stands for with
+// the ResolveBareArgs option, if it is the default, alternate or
+// replacement text and is written as a plain variable name.
+func (t *Template) bareArg(node *parse.FuncNode, i int) (string, bool) {
+	if !t.opts.ResolveBareArgs {
+		return "", false
+	}
+	switch node.Name {
+	case "-", "=", "+", ":-", ":=", ":+":
+		if len(node.Args) != 1 {
+			return "", false
+		}
+	case "/", "//", "/#", "/%":
+		if i != 1 {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	text, ok := node.Args[i].(*parse.TextNode)
+	if !ok || !funcPattern.MatchString(text.Value) {
+		return "", false
+	}
+	return text.Value, true
+}
 
-	v := s.mapper(node.Param)
+// indirectArg returns the name of the variable that holds the name of the
+// default with the IndirectDefaults option, if the default of node is
+// written as ! and a variable name.
+func (t *Template) indirectArg(node *parse.FuncNode) (string, bool) {
+	if !t.opts.IndirectDefaults || len(node.Args) != 1 {
+		return "", false
+	}
+	switch node.Name {
+	case "-", "=", ":-", ":=":
+	default:
+		return "", false
+	}
+	text, ok := node.Args[0].(*parse.TextNode)
+	if !ok || !strings.HasPrefix(text.Value, "!") || !funcPattern.MatchString(text.Value[1:]) {
+		return "", false
+	}
+	return text.Value[1:], true
+}
 
-	fn := lookupFunc(node.Name, len(args))
+// resolveIndirect returns the value of the variable named by the value of
+// the variable name, or the empty string if either is unset.
+func (t *Template) resolveIndirect(s *state, node *parse.FuncNode, name string) (string, error) {
+	inDefault := s.inDefault
+	s.inDefault = true
+	defer func() { s.inDefault = inDefault }()
 
-	_, err := io.WriteString(s.writer, fn(v, args...))
-	return err
+	ref, _, err := t.lookupArg(s, node, name)
+	if err != nil || !funcPattern.MatchString(ref) {
+		return "", err
+	}
+	v, _, err := t.lookupArg(s, node, ref)
+	return v, err
+}
+
+// lookupArg resolves a variable that an argument of node refers to.
+func (t *Template) lookupArg(s *state, node *parse.FuncNode, name string) (string, bool, error) {
+	v, set, err := s.mapper(name, ResolveContext{node, s.inDefault})
+	if err != nil {
+		return "", false, &MappingError{Name: name, Err: err}
+	}
+	if v != "" && t.opts.Redactor != nil {
+		s.values = append(s.values, resolved{name, v})
+	}
+	return v, set, nil
+}
+
+// apply runs the substitution function of node on the value v.
+func (t *Template) apply(s *state, node *parse.FuncNode, v string, args []string) (string, error) {
+	name := node.Name
+	if strings.HasPrefix(name, "|") {
+		fn, ok := lookupFunction(name[1:])
+		if !ok {
+			return "", fmt.Errorf(currentMessages().UnknownFunction, name[1:])
+		}
+
+		// the first error of the mapping fails the substitution
+		var lookupErr error
+		lookup := func(name string) (string, bool) {
+			if s.mapper == nil || lookupErr != nil {
+				return "", false
+			}
+			v, set, err := s.mapper(name, ResolveContext{node, s.inDefault})
+			if err != nil {
+				lookupErr = &MappingError{Name: name, Err: err}
+				return "", false
+			}
+			if set && v != "" && t.opts.Redactor != nil {
+				s.values = append(s.values, resolved{name, v})
+			}
+			return v, set
+		}
+		v, err := fn(lookup, v, args...)
+		if lookupErr != nil {
+			return "", lookupErr
+		}
+		return v, err
+	}
+
+	if name == "#" && node.Param == "" {
+		// ${#${param}} measures the result of the nested expansion
+		return strconv.Itoa(utf8.RuneCountInString(strings.Join(args, ""))), nil
+	}
+
+	if name == ":" && !t.opts.SubstringNegativeClamp {
+		if err := checkSubstr(v, args...); err != nil {
+			return "", err
+		}
+	}
+
+	fn, ok := t.caseFunc(name)
+	if !ok {
+		fn = lookupFunc(name, len(args))
+	}
+	return fn(v, args...), nil
 }
 
 // lookupFunc returns the parameters substitution function by name. If the
@@ -141,6 +541,10 @@ func lookupFunc(name string, args int) substituteFunc {
 		return trimLongestSuffix
 	case ":":
 		return toSubstr
+	case "@Q":
+		return toQuoted
+	case "@docker":
+		return toDockerEnv
 	case "/#":
 		return replacePrefix
 	case "/%":
@@ -151,7 +555,9 @@ func lookupFunc(name string, args int) substituteFunc {
 		return replaceAll
 	case "=", ":=", ":-":
 		return toDefault
-	case ":?", ":+", "-", "+", "bare":
+	case ":+", "+":
+		return toAlternate
+	case ":?", "-", "bare":
 		return toDefault
 	default:
 		return toDefault
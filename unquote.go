@@ -0,0 +1,131 @@
+package envsubst
+
+import (
+	"strconv"
+	"strings"
+)
+
+// UnquoteMapping returns a mapping that removes shell quoting from the
+// values resolved by m, such as those of a shell dump made with declare -p
+// or ${var@Q}, so that the quotes are not copied into the output. A value
+// is read as a shell word: text in single quotes is literal, text in
+// double quotes may escape ", \, $ and ` with a backslash, text in $'...'
+// has ANSI-C escapes such as \n and \x1b, and elsewhere a backslash escapes
+// the character after it. Quoted and unquoted parts may be mixed, as in
+// 'it'\''s'. A value whose quotes are unbalanced is returned as it is.
+func UnquoteMapping(m Mapping) Mapping {
+	return func(name string) string {
+		v := m(name)
+		if u, ok := shellUnquote(v); ok {
+			return u
+		}
+		return v
+	}
+}
+
+// shellUnquote removes the shell quoting from the word s, reporting false
+// if its quotes are unbalanced.
+func shellUnquote(s string) (string, bool) {
+	if !strings.ContainsAny(s, `'"\`) {
+		return s, true
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return "", false
+			}
+			b.WriteString(s[i+1 : i+1+end])
+			i += 1 + end
+		case c == '"':
+			n, ok := unquoteDouble(&b, s[i+1:])
+			if !ok {
+				return "", false
+			}
+			i += 1 + n
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			n, ok := unquoteANSI(&b, s[i+2:])
+			if !ok {
+				return "", false
+			}
+			i += 2 + n
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+// unquoteDouble writes the text of s up to the closing double quote and
+// returns the index of the quote.
+func unquoteDouble(b *strings.Builder, s string) (int, bool) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return i, true
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) != -1 {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, false
+}
+
+// ansiEscapes maps the single character escapes of $'...' to the
+// characters they stand for, as written by ${var@Q}.
+var ansiEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'e': 0x1b, 'E': 0x1b, 'f': '\f', 'n': '\n',
+	'r': '\r', 't': '\t', 'v': '\v', '\\': '\\', '\'': '\'', '"': '"',
+}
+
+// unquoteANSI writes the text of s up to the closing single quote of a
+// $'...' word, interpreting its escapes, and returns the index of the
+// quote.
+func unquoteANSI(b *strings.Builder, s string) (int, bool) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			return i, true
+		case c != '\\' || i+1 == len(s):
+			b.WriteByte(c)
+		case s[i+1] == 'x':
+			// up to two hexadecimal digits
+			n := 0
+			for n < 2 && i+2+n < len(s) && isHexDigit(s[i+2+n]) {
+				n++
+			}
+			if n == 0 {
+				b.WriteString(`\x`)
+			} else {
+				v, _ := strconv.ParseUint(s[i+2:i+2+n], 16, 8)
+				b.WriteByte(byte(v))
+			}
+			i += 1 + n
+		default:
+			if r, ok := ansiEscapes[s[i+1]]; ok {
+				b.WriteByte(r)
+			} else {
+				b.WriteByte(c)
+				b.WriteByte(s[i+1])
+			}
+			i++
+		}
+	}
+	return 0, false
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
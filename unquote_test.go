@@ -0,0 +1,49 @@
+package envsubst
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnquoteMapping(t *testing.T) {
+	values := map[string]string{
+		"PLAIN":     "hello",
+		"SINGLE":    `'hello world'`,
+		"DOUBLE":    `"say \"hi\" to \$USER"`,
+		"BACKSLASH": `"a\b" c\ d`,
+		"MIXED":     `'it'\''s '"fine"`,
+		"PARTIAL":   `prefix-'quoted part'-suffix`,
+		"EMBEDDED":  `"it's" 'a "b"'`,
+		"ANSI":      `$'line\none\ttab\x41\E'`,
+		"UNCLOSED":  `'unclosed`,
+		"EMPTY":     `''`,
+	}
+	mapping := UnquoteMapping(func(name string) string { return values[name] })
+
+	for name, want := range map[string]string{
+		"PLAIN":     "hello",
+		"SINGLE":    "hello world",
+		"DOUBLE":    `say "hi" to $USER`,
+		"BACKSLASH": `a\b c d`,
+		"MIXED":     "it's fine",
+		"PARTIAL":   "prefix-quoted part-suffix",
+		"EMBEDDED":  `it's a "b"`,
+		"ANSI":      "line\none\ttabA\x1b",
+		"UNCLOSED":  `'unclosed`,
+		"EMPTY":     "",
+		"UNSET":     "",
+	} {
+		assert.Equal(t, want, mapping(name), name)
+	}
+
+	// the quoting of ${var@Q} is undone
+	for _, v := range []string{"it's", "tab\there", "utf-8 ü", "bs\\"} {
+		q := toQuoted(v)
+		assert.Equal(t, v, UnquoteMapping(func(string) string { return q })("V"), q)
+	}
+
+	out, err := Eval("[${SINGLE}] [${DOUBLE:-x}]", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, `[hello world] [say "hi" to $USER]`, out)
+}